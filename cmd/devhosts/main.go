@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 
@@ -11,6 +12,12 @@ import (
 func main() {
 	ctx := context.Background()
 	if err := cli.Execute(ctx, os.Args[1:]); err != nil {
+		if errors.Is(err, cli.ErrChangesPending) {
+			os.Exit(2)
+		}
+		if cli.Reported(err) {
+			os.Exit(1)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,234 @@
+// Package backend abstracts the reverse proxies devhosts can configure.
+package backend
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/nginx"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/traefik"
+)
+
+// Backend is a reverse proxy that devhosts keeps in sync with the snapshot.
+type Backend interface {
+	Name() string
+	// EnsureBaseReady checks the proxy's own config can take the managed include. It must
+	// not write anything so plans can call it.
+	EnsureBaseReady(snapshot state.Snapshot) error
+	// Provision writes files the generated include references, such as certificates.
+	Provision(snapshot state.Snapshot) error
+	GenerateInclude(snapshot state.Snapshot) (string, error)
+	// IncludePath is the managed file, or "" when the backend has none.
+	IncludePath(snapshot state.Snapshot) string
+	PlanInclude(path, content string) (managedfile.Plan, error)
+	WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error)
+	RestoreInclude(res managedfile.UpdateResult) error
+	Reload(ctx context.Context, snapshot state.Snapshot) (cmdutil.Result, error)
+}
+
+// Options carries the dependencies shared by the concrete backends.
+type Options struct {
+	FS     filesystem.FS
+	Runner cmdutil.Runner
+	Caddy  caddy.Manager
+	// StateDir holds the local CA and issued certificates for backends without an internal issuer.
+	StateDir string
+}
+
+// For returns the backend selected by the snapshot.
+func For(snapshot state.Snapshot, opts Options) Backend {
+	if opts.FS == nil {
+		opts.FS = filesystem.OS{}
+	}
+	switch snapshot.Backend {
+	case state.BackendBuiltin:
+		return Builtin{}
+	case state.BackendNginx:
+		return Nginx{Manager: nginx.NewManager(opts.FS, opts.Runner), StateDir: opts.StateDir}
+	case state.BackendTraefik:
+		return Traefik{Manager: traefik.NewManager(opts.FS), StateDir: opts.StateDir}
+	default:
+		return Caddy{Manager: opts.Caddy}
+	}
+}
+
+// CADir returns where the shared local CA lives inside stateDir.
+func CADir(stateDir string) string { return filepath.Join(stateDir, "ca") }
+
+// CertDir returns where leaf certificates for file-based backends are written inside stateDir.
+func CertDir(stateDir string) string { return filepath.Join(stateDir, "certs") }
+
+// writeCertificates issues on-disk certificates for every TLS host from the local CA.
+func writeCertificates(fsys filesystem.FS, stateDir string, hosts []state.Host) error {
+	var ca *localca.CA
+	for _, h := range hosts {
+		if !h.TLS || !h.IsEnabled() {
+			continue
+		}
+		if ca == nil {
+			var err error
+			if ca, err = localca.LoadOrCreateCA(fsys, CADir(stateDir)); err != nil {
+				return err
+			}
+		}
+		if err := ca.WriteLeaf(fsys, CertDir(stateDir), h.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Caddy drives Caddy through an imported Caddyfile include or merged JSON config.
+type Caddy struct {
+	Manager caddy.Manager
+}
+
+func (Caddy) Name() string { return state.BackendCaddy }
+
+func (b Caddy) EnsureBaseReady(snapshot state.Snapshot) error {
+	if snapshot.IncludeFormat == state.IncludeFormatJSON {
+		// GenerateJSON validates the base while merging into it.
+		return nil
+	}
+	return b.Manager.EnsureBaseReady(snapshot.BaseCaddyfile, snapshot.IncludeCaddyfile, snapshot.Hosts)
+}
+
+func (Caddy) Provision(state.Snapshot) error { return nil }
+
+func (b Caddy) GenerateInclude(snapshot state.Snapshot) (string, error) {
+	if snapshot.IncludeFormat == state.IncludeFormatJSON {
+		return b.Manager.GenerateJSON(snapshot.BaseCaddyfile, snapshot.Hosts)
+	}
+	return b.Manager.GenerateInclude(snapshot.Hosts), nil
+}
+
+func (Caddy) IncludePath(snapshot state.Snapshot) string { return snapshot.IncludeCaddyfile }
+
+func (b Caddy) PlanInclude(path, content string) (managedfile.Plan, error) {
+	return b.Manager.PlanInclude(path, content)
+}
+
+func (b Caddy) WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error) {
+	return b.Manager.WriteInclude(plan)
+}
+
+func (b Caddy) RestoreInclude(res managedfile.UpdateResult) error {
+	return b.Manager.RestoreInclude(res)
+}
+
+func (b Caddy) Reload(ctx context.Context, snapshot state.Snapshot) (cmdutil.Result, error) {
+	if snapshot.IncludeFormat == state.IncludeFormatJSON {
+		return b.Manager.ReloadJSON(ctx, snapshot.IncludeCaddyfile, snapshot.AdminAddress)
+	}
+	return b.Manager.Reload(ctx, snapshot.BaseCaddyfile, snapshot.AdminAddress)
+}
+
+// Builtin targets `devhosts serve`, which has no include file and picks up
+// devhosts.json changes on its own.
+type Builtin struct{}
+
+func (Builtin) Name() string { return state.BackendBuiltin }
+
+func (Builtin) EnsureBaseReady(state.Snapshot) error { return nil }
+
+func (Builtin) Provision(state.Snapshot) error { return nil }
+
+func (Builtin) GenerateInclude(state.Snapshot) (string, error) { return "", nil }
+
+func (Builtin) IncludePath(state.Snapshot) string { return "" }
+
+func (Builtin) PlanInclude(string, string) (managedfile.Plan, error) {
+	return managedfile.Plan{}, nil
+}
+
+func (Builtin) WriteInclude(managedfile.Plan) (managedfile.UpdateResult, error) {
+	return managedfile.UpdateResult{}, nil
+}
+
+func (Builtin) RestoreInclude(managedfile.UpdateResult) error { return nil }
+
+func (Builtin) Reload(context.Context, state.Snapshot) (cmdutil.Result, error) {
+	return cmdutil.Result{}, nil
+}
+
+// Nginx writes server blocks to an include that nginx.conf pulls in.
+type Nginx struct {
+	Manager  nginx.Manager
+	StateDir string
+}
+
+func (Nginx) Name() string { return state.BackendNginx }
+
+// EnsureBaseReady has nothing to check; nginx.conf's include is verified by `nginx -t`.
+func (Nginx) EnsureBaseReady(state.Snapshot) error { return nil }
+
+// Provision issues certificates for TLS hosts so `nginx -t` can find them.
+func (b Nginx) Provision(snapshot state.Snapshot) error {
+	return writeCertificates(b.Manager.FS, b.StateDir, snapshot.Hosts)
+}
+
+func (b Nginx) GenerateInclude(snapshot state.Snapshot) (string, error) {
+	return b.Manager.GenerateInclude(snapshot.Hosts, CertDir(b.StateDir))
+}
+
+func (Nginx) IncludePath(snapshot state.Snapshot) string { return snapshot.Nginx.Include }
+
+func (b Nginx) PlanInclude(path, content string) (managedfile.Plan, error) {
+	return b.Manager.PlanInclude(path, content)
+}
+
+func (b Nginx) WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error) {
+	return b.Manager.WriteInclude(plan)
+}
+
+func (b Nginx) RestoreInclude(res managedfile.UpdateResult) error {
+	return b.Manager.RestoreInclude(res)
+}
+
+func (b Nginx) Reload(ctx context.Context, _ state.Snapshot) (cmdutil.Result, error) {
+	return b.Manager.Reload(ctx)
+}
+
+// Traefik writes a dynamic config file that Traefik's file provider watches, so there is
+// nothing to reload.
+type Traefik struct {
+	Manager  traefik.Manager
+	StateDir string
+}
+
+func (Traefik) Name() string { return state.BackendTraefik }
+
+func (Traefik) EnsureBaseReady(state.Snapshot) error { return nil }
+
+// Provision issues certificates before the watched file references them.
+func (b Traefik) Provision(snapshot state.Snapshot) error {
+	return writeCertificates(b.Manager.FS, b.StateDir, snapshot.Hosts)
+}
+
+func (b Traefik) GenerateInclude(snapshot state.Snapshot) (string, error) {
+	return b.Manager.GenerateDynamic(snapshot.Hosts, CertDir(b.StateDir))
+}
+
+func (Traefik) IncludePath(snapshot state.Snapshot) string { return snapshot.Traefik.DynamicConfig }
+
+func (b Traefik) PlanInclude(path, content string) (managedfile.Plan, error) {
+	return b.Manager.PlanInclude(path, content)
+}
+
+func (b Traefik) WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error) {
+	return b.Manager.WriteInclude(plan)
+}
+
+func (b Traefik) RestoreInclude(res managedfile.UpdateResult) error {
+	return b.Manager.RestoreInclude(res)
+}
+
+func (Traefik) Reload(context.Context, state.Snapshot) (cmdutil.Result, error) {
+	return cmdutil.Result{}, nil
+}
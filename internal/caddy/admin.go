@@ -0,0 +1,146 @@
+package caddy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultAdminAddress is where Caddy serves its admin API unless configured otherwise.
+const DefaultAdminAddress = "localhost:2019"
+
+// AdminTimeout bounds each admin request, so a Caddy that accepts the connection but never
+// answers cannot stall a reload while the devhosts lock is held.
+const AdminTimeout = 30 * time.Second
+
+// defaultAdminHTTP is used when no client is given.
+var defaultAdminHTTP = &http.Client{Timeout: AdminTimeout}
+
+// ErrAdminUnreachable indicates nothing answered on the admin address.
+var ErrAdminUnreachable = errors.New("caddy admin API unreachable")
+
+// AdminClient pushes configuration to a running Caddy through its admin API.
+type AdminClient struct {
+	Address string
+	HTTP    *http.Client
+}
+
+// NewAdminClient creates an AdminClient for the given address, falling back to DefaultAdminAddress.
+func NewAdminClient(address string, client *http.Client) AdminClient {
+	if address == "" {
+		address = DefaultAdminAddress
+	}
+	if client == nil {
+		client = defaultAdminHTTP
+	}
+	return AdminClient{Address: address, HTTP: client}
+}
+
+// AdminError carries the structured error Caddy returns from a failed admin request.
+type AdminError struct {
+	Endpoint string
+	Status   int
+	Message  string
+}
+
+func (e *AdminError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("caddy admin %s: HTTP %d", e.Endpoint, e.Status)
+	}
+	return fmt.Sprintf("caddy admin %s: HTTP %d: %s", e.Endpoint, e.Status, e.Message)
+}
+
+// Load adapts the config (unless it is already JSON) and replaces the running configuration with it.
+func (c AdminClient) Load(ctx context.Context, config []byte, adapter string) error {
+	body := config
+	if adapter != "" && adapter != "json" {
+		adapted, err := c.adapt(ctx, config, adapter)
+		if err != nil {
+			return err
+		}
+		body = adapted
+	}
+	_, err := c.post(ctx, "/load", "application/json", body)
+	return err
+}
+
+func (c AdminClient) adapt(ctx context.Context, config []byte, adapter string) ([]byte, error) {
+	resp, err := c.post(ctx, "/adapt", "text/"+adapter, config)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(resp, &out); err != nil {
+		return nil, fmt.Errorf("caddy admin /adapt: decode response: %w", err)
+	}
+	if len(out.Result) == 0 {
+		return nil, fmt.Errorf("caddy admin /adapt: empty result")
+	}
+	return out.Result, nil
+}
+
+// Ping checks that the admin API answers by reading the running configuration.
+func (c AdminClient) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/config/", "", nil)
+	return err
+}
+
+func (c AdminClient) post(ctx context.Context, endpoint, contentType string, body []byte) ([]byte, error) {
+	return c.do(ctx, http.MethodPost, endpoint, contentType, body)
+}
+
+func (c AdminClient) do(ctx context.Context, method, endpoint, contentType string, body []byte) ([]byte, error) {
+	client := c.HTTP
+	if client == nil {
+		client = defaultAdminHTTP
+	}
+	address := c.Address
+	if address == "" {
+		address = DefaultAdminAddress
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+address+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, fmt.Errorf("%w at %s: %v", ErrAdminUnreachable, address, err)
+		}
+		return nil, fmt.Errorf("caddy admin %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("caddy admin %s: read response: %w", endpoint, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &AdminError{Endpoint: endpoint, Status: resp.StatusCode, Message: adminErrorMessage(data)}
+	}
+	return data, nil
+}
+
+func adminErrorMessage(body []byte) string {
+	var structured struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &structured); err == nil && structured.Error != "" {
+		return structured.Error
+	}
+	return strings.TrimSpace(string(body))
+}
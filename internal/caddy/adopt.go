@@ -0,0 +1,170 @@
+package caddy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/caddyfile"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// Site is a hand-written site in the base Caddyfile simple enough to become a managed host:
+// one name proxied to one loopback upstream. Line and End bound its block; Shared marks a block
+// that has another block or import on one of those lines, so it cannot be commented out alone.
+type Site struct {
+	Name     string
+	Upstream string
+	TLS      bool
+	Line     int
+	End      int
+	Shared   bool
+}
+
+// SimpleSites finds the sites in the base Caddyfile that do nothing but reverse_proxy one
+// address to one loopback upstream, optionally with `tls internal`. A missing base has none.
+func (m Manager) SimpleSites(basePath string) ([]Site, error) {
+	resolvedBase, err := filesystem.ExpandUser(basePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.FS.ReadFile(resolvedBase)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, system.WrapPermission("read", resolvedBase, err)
+	}
+	cfg, err := caddyfile.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("base caddyfile %s invalid: %w", resolvedBase, err)
+	}
+	var sites []Site
+	for i, block := range cfg.Blocks {
+		if _, snippet := block.SnippetName(); block.Global || snippet {
+			continue
+		}
+		if site, ok := simpleSite(block); ok {
+			site.Shared = sharesLines(cfg, i)
+			sites = append(sites, site)
+		}
+	}
+	return sites, nil
+}
+
+// sharesLines reports whether anything else at the top level of cfg sits on one of the lines
+// of the block at index i.
+func sharesLines(cfg caddyfile.Config, i int) bool {
+	block := cfg.Blocks[i]
+	for j, other := range cfg.Blocks {
+		if j != i && other.Line <= block.End && other.End >= block.Line {
+			return true
+		}
+	}
+	for _, d := range cfg.Imports {
+		if d.Line >= block.Line && d.Line <= block.End {
+			return true
+		}
+	}
+	return false
+}
+
+func simpleSite(block caddyfile.ServerBlock) (Site, bool) {
+	if len(block.Keys) != 1 {
+		return Site{}, false
+	}
+	scheme, addr := "", block.Keys[0]
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, addr = addr[:i], addr[i+3:]
+	}
+	// Ports, paths, wildcards, and placeholders all need more than a managed host offers.
+	if (scheme != "" && scheme != "http" && scheme != "https") || addr == "" || strings.ContainsAny(addr, ":/*{") {
+		return Site{}, false
+	}
+	site := Site{Name: strings.ToLower(addr), TLS: scheme != "http", Line: block.Line, End: block.End}
+	for _, d := range block.Directives {
+		switch {
+		case d.Name == "reverse_proxy" && len(d.Args) == 1 && d.Block == nil && site.Upstream == "":
+			site.Upstream = proxyUpstream(d.Args[0])
+		case d.Name == "tls" && len(d.Args) == 1 && d.Args[0] == "internal" && d.Block == nil && site.TLS:
+		default:
+			return Site{}, false
+		}
+	}
+	if _, err := state.ParseUpstream(site.Upstream); err != nil {
+		return Site{}, false
+	}
+	return site, true
+}
+
+// proxyUpstream turns a reverse_proxy address such as :3000 or localhost:3000 into the URL
+// form devhosts stores.
+func proxyUpstream(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return addr
+}
+
+// PlanCommentSites computes the base Caddyfile with every line of sites commented out. Sites
+// sharing a line with another block are refused, and the result must still parse.
+func (m Manager) PlanCommentSites(basePath string, sites []Site) (managedfile.Plan, error) {
+	resolvedBase, err := filesystem.ExpandUser(basePath)
+	if err != nil {
+		return managedfile.Plan{}, err
+	}
+	data, err := m.FS.ReadFile(resolvedBase)
+	if err != nil {
+		return managedfile.Plan{}, system.WrapPermission("read", resolvedBase, err)
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, site := range sites {
+		if site.Shared {
+			return managedfile.Plan{}, fmt.Errorf("site %s at %s:%d shares a line with another block", site.Name, resolvedBase, site.Line)
+		}
+		for n := site.Line; n <= site.End && n <= len(lines); n++ {
+			lines[n-1] = "# " + lines[n-1]
+		}
+	}
+	content := strings.Join(lines, "\n")
+	if _, err := caddyfile.Parse([]byte(content)); err != nil {
+		return managedfile.Plan{}, fmt.Errorf("base caddyfile %s would be invalid after commenting out sites: %w", resolvedBase, err)
+	}
+	return managedfile.Plan{
+		Changed:  content != string(data),
+		Path:     resolvedBase,
+		Previous: data,
+		Existed:  true,
+		Content:  content,
+	}, nil
+}
+
+// WriteBase writes a plan for the base Caddyfile atomically after saving a backup of it,
+// returning the backup's path.
+func (m Manager) WriteBase(plan managedfile.Plan) (UpdateResult, string, error) {
+	if !plan.Changed {
+		return UpdateResult{}, "", nil
+	}
+	var backupPath string
+	if plan.Existed {
+		var err error
+		if backupPath, err = managedfile.Backup(m.FS, plan.Path, plan.Previous, time.Now()); err != nil {
+			return UpdateResult{}, "", err
+		}
+	}
+	res, err := managedfile.Write(m.FS, plan)
+	return res, backupPath, err
+}
+
+// RestoreBase puts the base Caddyfile back to its content before WriteBase.
+func (m Manager) RestoreBase(res UpdateResult) error {
+	return managedfile.Restore(m.FS, res)
+}
@@ -0,0 +1,341 @@
+package caddy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+const (
+	jsonIDPrefix      = "devhosts-"
+	jsonHTTPSServer   = "devhosts"
+	jsonHTTPServer    = "devhosts_http"
+	jsonTLSPolicyID   = "devhosts-internal"
+	jsonHTTPSListener = ":443"
+	jsonHTTPListener  = ":80"
+)
+
+type jsonRoute struct {
+	ID       string        `json:"@id,omitempty"`
+	Match    []jsonMatch   `json:"match,omitempty"`
+	Handle   []jsonHandler `json:"handle"`
+	Terminal bool          `json:"terminal,omitempty"`
+}
+
+type jsonMatch struct {
+	Host []string `json:"host,omitempty"`
+	Path []string `json:"path,omitempty"`
+}
+
+type jsonHandler struct {
+	Handler         string             `json:"handler"`
+	Upstreams       []jsonUpstream     `json:"upstreams,omitempty"`
+	Routes          []jsonRoute        `json:"routes,omitempty"`
+	StripPathPrefix string             `json:"strip_path_prefix,omitempty"`
+	Transport       *jsonTransport     `json:"transport,omitempty"`
+	LoadBalancing   *jsonLoadBalancing `json:"load_balancing,omitempty"`
+	HealthChecks    *jsonHealthChecks  `json:"health_checks,omitempty"`
+}
+
+type jsonUpstream struct {
+	Dial string `json:"dial"`
+}
+
+type jsonTransport struct {
+	Protocol string            `json:"protocol"`
+	TLS      *jsonTransportTLS `json:"tls,omitempty"`
+}
+
+type jsonTransportTLS struct {
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+type jsonLoadBalancing struct {
+	SelectionPolicy jsonSelectionPolicy `json:"selection_policy"`
+}
+
+type jsonSelectionPolicy struct {
+	Policy string `json:"policy"`
+}
+
+type jsonHealthChecks struct {
+	Active jsonActiveHealthCheck `json:"active"`
+}
+
+type jsonActiveHealthCheck struct {
+	URI      string `json:"uri"`
+	Interval string `json:"interval,omitempty"`
+}
+
+type jsonTLSPolicy struct {
+	ID       string       `json:"@id,omitempty"`
+	Subjects []string     `json:"subjects"`
+	Issuers  []jsonIssuer `json:"issuers"`
+}
+
+type jsonIssuer struct {
+	Module string `json:"module"`
+}
+
+// GenerateJSON merges managed routes into the base JSON config and returns the full Caddy config.
+//
+// TLS hosts are routed on the server listening on :443 and covered by an internal-issuer
+// automation policy; plain hosts are routed on the server listening on :80. Servers are created
+// when the base config has none on those ports. Every object devhosts owns carries an @id
+// starting with "devhosts-" so a later run can replace it.
+func (m Manager) GenerateJSON(basePath string, hosts []state.Host) (string, error) {
+	hosts = state.EnabledHosts(hosts)
+	resolvedBase, err := filesystem.ExpandUser(basePath)
+	if err != nil {
+		return "", err
+	}
+	data, err := m.FS.ReadFile(resolvedBase)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", system.WrapPermission("read", resolvedBase, err)
+	}
+
+	root := map[string]any{}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &root); err != nil {
+			return "", fmt.Errorf("base config %s is not Caddy JSON: %w", resolvedBase, err)
+		}
+	}
+
+	servers := childMap(childMap(childMap(root, "apps"), "http"), "servers")
+	stripManagedRoutes(servers)
+	if conflicts := detectJSONConflicts(servers, hosts); len(conflicts) > 0 {
+		return "", fmt.Errorf("base config %s already defines hosts: %s", resolvedBase, strings.Join(conflicts, ", "))
+	}
+
+	var secure, plain []jsonRoute
+	var subjects []string
+	for _, h := range hosts {
+		route, err := jsonRouteFor(h)
+		if err != nil {
+			return "", err
+		}
+		if h.TLS {
+			secure = append(secure, route)
+			subjects = append(subjects, h.Name)
+		} else {
+			plain = append(plain, route)
+		}
+	}
+
+	if len(secure) > 0 {
+		prependRoutes(serverFor(servers, jsonHTTPSListener, jsonHTTPSServer), secure)
+	}
+	if len(plain) > 0 {
+		prependRoutes(serverFor(servers, jsonHTTPListener, jsonHTTPServer), plain)
+	}
+	apps := childMap(root, "apps")
+	tls := childMap(apps, "tls")
+	setTLSPolicy(tls, subjects)
+	if len(tls) == 0 {
+		delete(apps, "tls")
+	}
+
+	out, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out) + "\n", nil
+}
+
+func jsonRouteFor(h state.Host) (jsonRoute, error) {
+	proxy, err := jsonProxy(h.Pool(), h.Balancing)
+	if err != nil {
+		return jsonRoute{}, fmt.Errorf("host %s upstream: %w", h.Name, err)
+	}
+	route := jsonRoute{
+		ID:       jsonIDPrefix + h.Name,
+		Match:    []jsonMatch{{Host: []string{h.Name}}},
+		Handle:   []jsonHandler{proxy},
+		Terminal: true,
+	}
+	if len(h.Routes) == 0 {
+		return route, nil
+	}
+
+	sub := make([]jsonRoute, 0, len(h.Routes)+1)
+	for _, r := range h.Routes {
+		routeProxy, err := jsonProxy([]string{r.Upstream}, state.Balancing{})
+		if err != nil {
+			return jsonRoute{}, fmt.Errorf("host %s route %s upstream: %w", h.Name, r.Path, err)
+		}
+		handlers := []jsonHandler{routeProxy}
+		if r.StripPrefix {
+			handlers = append([]jsonHandler{{Handler: "rewrite", StripPathPrefix: strings.TrimSuffix(r.Path, "/")}}, handlers...)
+		}
+		sub = append(sub, jsonRoute{
+			Match:    []jsonMatch{{Path: []string{pathMatcher(r.Path)}}},
+			Handle:   handlers,
+			Terminal: true,
+		})
+	}
+	sub = append(sub, jsonRoute{Handle: []jsonHandler{proxy}})
+	route.Handle = []jsonHandler{{Handler: "subroute", Routes: sub}}
+	return route, nil
+}
+
+func jsonProxy(pool []string, b state.Balancing) (jsonHandler, error) {
+	handler := jsonHandler{Handler: "reverse_proxy"}
+	for _, raw := range pool {
+		u, err := state.ParseUpstream(raw)
+		if err != nil {
+			return jsonHandler{}, err
+		}
+		handler.Upstreams = append(handler.Upstreams, jsonUpstream{Dial: u.Dial()})
+		if u.HTTPS() {
+			handler.Transport = &jsonTransport{Protocol: "http", TLS: &jsonTransportTLS{InsecureSkipVerify: true}}
+		}
+	}
+	if b.LBPolicy != "" {
+		handler.LoadBalancing = &jsonLoadBalancing{SelectionPolicy: jsonSelectionPolicy{Policy: b.LBPolicy}}
+	}
+	if hc := b.HealthCheck; hc != nil {
+		handler.HealthChecks = &jsonHealthChecks{Active: jsonActiveHealthCheck{URI: hc.Path, Interval: hc.Interval}}
+	}
+	return handler, nil
+}
+
+// childMap returns parent[key] as an object, creating it when absent.
+func childMap(parent map[string]any, key string) map[string]any {
+	if child, ok := parent[key].(map[string]any); ok {
+		return child
+	}
+	child := map[string]any{}
+	parent[key] = child
+	return child
+}
+
+// serverFor finds the server bound to listener or creates a devhosts-owned one.
+func serverFor(servers map[string]any, listener, name string) map[string]any {
+	names := make([]string, 0, len(servers))
+	for n := range servers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		srv, ok := servers[n].(map[string]any)
+		if !ok {
+			continue
+		}
+		listen, _ := srv["listen"].([]any)
+		for _, addr := range listen {
+			if s, ok := addr.(string); ok && strings.HasSuffix(s, listener) {
+				return srv
+			}
+		}
+	}
+	srv := map[string]any{"listen": []any{listener}}
+	if listener == jsonHTTPListener {
+		srv["automatic_https"] = map[string]any{"disable": true}
+	}
+	servers[name] = srv
+	return srv
+}
+
+func prependRoutes(server map[string]any, routes []jsonRoute) {
+	existing, _ := server["routes"].([]any)
+	merged := make([]any, 0, len(routes)+len(existing))
+	for _, r := range routes {
+		merged = append(merged, r)
+	}
+	server["routes"] = append(merged, existing...)
+}
+
+func stripManagedRoutes(servers map[string]any) {
+	for name, raw := range servers {
+		srv, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		routes, _ := srv["routes"].([]any)
+		kept := routes[:0]
+		for _, r := range routes {
+			if !isManaged(r) {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == 0 && (name == jsonHTTPSServer || name == jsonHTTPServer) {
+			delete(servers, name)
+			continue
+		}
+		if routes != nil {
+			srv["routes"] = kept
+		}
+	}
+}
+
+func setTLSPolicy(tls map[string]any, subjects []string) {
+	automation := childMap(tls, "automation")
+	policies, _ := automation["policies"].([]any)
+	kept := make([]any, 0, len(policies)+1)
+	for _, p := range policies {
+		if !isManaged(p) {
+			kept = append(kept, p)
+		}
+	}
+	if len(subjects) > 0 {
+		sort.Strings(subjects)
+		kept = append([]any{jsonTLSPolicy{
+			ID:       jsonTLSPolicyID,
+			Subjects: subjects,
+			Issuers:  []jsonIssuer{{Module: "internal"}},
+		}}, kept...)
+	}
+	if len(kept) == 0 {
+		delete(automation, "policies")
+	} else {
+		automation["policies"] = kept
+	}
+	if len(automation) == 0 {
+		delete(tls, "automation")
+	}
+}
+
+func isManaged(v any) bool {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return false
+	}
+	id, _ := obj["@id"].(string)
+	return strings.HasPrefix(id, jsonIDPrefix)
+}
+
+func detectJSONConflicts(servers map[string]any, hosts []state.Host) []string {
+	lookup := make(map[string]struct{}, len(hosts))
+	for _, h := range hosts {
+		lookup[h.Name] = struct{}{}
+	}
+	var conflicts []string
+	for _, raw := range servers {
+		srv, _ := raw.(map[string]any)
+		routes, _ := srv["routes"].([]any)
+		for _, r := range routes {
+			route, _ := r.(map[string]any)
+			matchers, _ := route["match"].([]any)
+			for _, m := range matchers {
+				matcher, _ := m.(map[string]any)
+				names, _ := matcher["host"].([]any)
+				for _, n := range names {
+					if s, ok := n.(string); ok {
+						if _, managed := lookup[s]; managed {
+							conflicts = append(conflicts, s)
+						}
+					}
+				}
+			}
+		}
+	}
+	return unique(conflicts)
+}
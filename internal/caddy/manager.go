@@ -5,22 +5,31 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
-	"time"
 
+	"github.com/cdfuller/devhosts/internal/caddyfile"
 	"github.com/cdfuller/devhosts/internal/cmdutil"
 	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
 	"github.com/cdfuller/devhosts/internal/state"
 	"github.com/cdfuller/devhosts/internal/system"
 )
 
+// Problems in the base Caddyfile that FixBase can repair.
+var (
+	ErrImportMissing = errors.New("missing required import")
+	ErrTildeImport   = errors.New("caddy does not expand ~ in imports")
+)
+
 // Manager orchestrates Caddy include generation and reloads.
 type Manager struct {
-	FS     filesystem.FS
-	Runner cmdutil.Runner
+	FS         filesystem.FS
+	Runner     cmdutil.Runner
+	HTTPClient *http.Client
 }
 
 // NewManager constructs a Manager with sensible defaults.
@@ -34,90 +43,145 @@ func NewManager(fs filesystem.FS, runner cmdutil.Runner) Manager {
 	return Manager{FS: fs, Runner: runner}
 }
 
-// GenerateInclude renders the managed include file content.
+// GenerateInclude renders the managed include file content, skipping disabled hosts. Hosts
+// in a group follow a "# group:" comment naming it.
 func (m Manager) GenerateInclude(hosts []state.Host) string {
+	hosts = state.EnabledHosts(hosts)
 	if len(hosts) == 0 {
 		return ""
 	}
 	blocks := make([]string, 0, len(hosts))
-	for _, h := range hosts {
-		lines := []string{
-			fmt.Sprintf("%s {", h.Name),
+	var ordered []state.Host
+	for _, g := range state.GroupHosts(hosts) {
+		ordered = append(ordered, g.Hosts...)
+	}
+	for i, h := range ordered {
+		var lines []string
+		if h.Group != "" && (i == 0 || ordered[i-1].Group != h.Group) {
+			lines = append(lines, "# group: "+h.Group)
 		}
+		lines = append(lines, fmt.Sprintf("%s {", h.Name))
 		if h.TLS {
 			lines = append(lines, "  tls internal", "")
 		}
-		lines = append(lines, fmt.Sprintf("  reverse_proxy %s", h.Upstream), "}")
+		if len(h.Routes) == 0 {
+			lines = append(lines, reverseProxy("  ", h.Pool(), h.Balancing)...)
+			lines = append(lines, "}")
+			blocks = append(blocks, strings.Join(lines, "\n"))
+			continue
+		}
+		for _, r := range h.Routes {
+			directive := "handle"
+			if r.StripPrefix {
+				directive = "handle_path"
+			}
+			lines = append(lines, fmt.Sprintf("  %s %s {", directive, pathMatcher(r.Path)))
+			lines = append(lines, reverseProxy("    ", []string{r.Upstream}, state.Balancing{})...)
+			lines = append(lines, "  }", "")
+		}
+		lines = append(lines, "  handle {")
+		lines = append(lines, reverseProxy("    ", h.Pool(), h.Balancing)...)
+		lines = append(lines, "  }", "}")
 		blocks = append(blocks, strings.Join(lines, "\n"))
 	}
 	return strings.Join(blocks, "\n\n") + "\n"
 }
 
-// UpdateInclude writes the include file atomically and returns the previous contents for rollback.
-func (m Manager) UpdateInclude(path string, content string) (UpdateResult, error) {
-	resolved, err := filesystem.ExpandUser(path)
-	if err != nil {
-		return UpdateResult{}, err
+// reverseProxy renders a reverse_proxy directive for pool at indent, with load-balancing and
+// health-check subdirectives when configured. HTTPS upstreams are local dev servers with
+// self-signed certificates, so their certificates are not verified.
+func reverseProxy(indent string, pool []string, b state.Balancing) []string {
+	head := fmt.Sprintf("%sreverse_proxy %s", indent, strings.Join(pool, " "))
+	var body []string
+	if b.LBPolicy != "" {
+		body = append(body, "lb_policy "+b.LBPolicy)
 	}
-
-	previous, readErr := m.FS.ReadFile(resolved)
-	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
-		return UpdateResult{}, system.WrapPermission("read", resolved, readErr)
+	if hc := b.HealthCheck; hc != nil {
+		body = append(body, "health_uri "+hc.Path)
+		if hc.Interval != "" {
+			body = append(body, "health_interval "+hc.Interval)
+		}
 	}
-
-	existed := readErr == nil
-
-	if existed && string(previous) == content {
-		return UpdateResult{Changed: false, Path: resolved, Previous: previous, Existed: true}, nil
+	if u, err := state.ParseUpstream(pool[0]); err == nil && u.HTTPS() {
+		body = append(body, "transport http {", "  tls_insecure_skip_verify", "}")
 	}
-
-	dir := filepath.Dir(resolved)
-	if err := m.FS.MkdirAll(dir, 0o755); err != nil {
-		return UpdateResult{}, system.WrapPermission("mkdir", dir, err)
+	if len(body) == 0 {
+		return []string{head}
 	}
-
-	tempPath := fmt.Sprintf("%s.devhosts.tmp-%d", resolved, time.Now().UnixNano())
-	if err := m.FS.WriteFile(tempPath, []byte(content), 0o644); err != nil {
-		_ = m.FS.Remove(tempPath)
-		return UpdateResult{}, system.WrapPermission("write", tempPath, err)
+	lines := []string{head + " {"}
+	for _, line := range body {
+		lines = append(lines, indent+"  "+line)
 	}
+	return append(lines, indent+"}")
+}
 
-	if err := m.FS.Rename(tempPath, resolved); err != nil {
-		_ = m.FS.Remove(tempPath)
-		return UpdateResult{}, system.WrapPermission("replace", resolved, err)
+// pathMatcher turns a route prefix into the Caddy path matcher covering it and everything below.
+func pathMatcher(prefix string) string {
+	return strings.TrimSuffix(prefix, "/") + "*"
+}
+
+// UpdateInclude writes the include file atomically and returns the previous contents for rollback.
+func (m Manager) UpdateInclude(path string, content string) (UpdateResult, error) {
+	plan, err := m.PlanInclude(path, content)
+	if err != nil {
+		return UpdateResult{}, err
 	}
+	return m.WriteInclude(plan)
+}
+
+// PlanInclude computes what UpdateInclude would write without touching the include file.
+func (m Manager) PlanInclude(path string, content string) (managedfile.Plan, error) {
+	return managedfile.Prepare(m.FS, path, content)
+}
 
-	return UpdateResult{Changed: true, Path: resolved, Previous: previous, Existed: existed}, nil
+// WriteInclude writes a plan from PlanInclude atomically.
+func (m Manager) WriteInclude(plan managedfile.Plan) (UpdateResult, error) {
+	return managedfile.Write(m.FS, plan)
 }
 
 // RestoreInclude attempts to put the include file back to its previous bytes.
 func (m Manager) RestoreInclude(res UpdateResult) error {
-	if res.Path == "" {
-		return nil
-	}
-	if !res.Existed {
-		// File did not exist previously; remove it if present.
-		if err := m.FS.Remove(res.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
-			return system.WrapPermission("remove", res.Path, err)
-		}
-		return nil
-	}
-	if err := m.FS.WriteFile(res.Path, res.Previous, 0o644); err != nil {
-		return system.WrapPermission("restore", res.Path, err)
-	}
-	return nil
+	return managedfile.Restore(m.FS, res)
+}
+
+// Reload pushes the base Caddyfile through the admin API at adminAddress, falling back to
+// `caddy reload` when nothing is listening there.
+func (m Manager) Reload(ctx context.Context, baseCaddyfile, adminAddress string) (cmdutil.Result, error) {
+	return m.reload(ctx, baseCaddyfile, "caddyfile", adminAddress)
+}
+
+// ReloadJSON loads a complete JSON config the same way Reload loads a Caddyfile.
+func (m Manager) ReloadJSON(ctx context.Context, configPath, adminAddress string) (cmdutil.Result, error) {
+	return m.reload(ctx, configPath, "json", adminAddress)
 }
 
-// Reload invokes `caddy reload` with the provided base Caddyfile.
-func (m Manager) Reload(ctx context.Context, baseCaddyfile string) (cmdutil.Result, error) {
-	resolved, err := filesystem.ExpandUser(baseCaddyfile)
+func (m Manager) reload(ctx context.Context, configPath, adapter, adminAddress string) (cmdutil.Result, error) {
+	resolved, err := filesystem.ExpandUser(configPath)
 	if err != nil {
 		return cmdutil.Result{}, err
 	}
+	data, err := m.FS.ReadFile(resolved)
+	if err != nil {
+		return cmdutil.Result{}, system.WrapPermission("read", resolved, err)
+	}
+
+	admin := NewAdminClient(adminAddress, m.HTTPClient)
+	err = admin.Load(ctx, data, adapter)
+	if err == nil {
+		return cmdutil.Result{}, nil
+	}
+	if !errors.Is(err, ErrAdminUnreachable) {
+		return cmdutil.Result{}, err
+	}
+
 	if m.Runner == nil {
 		m.Runner = cmdutil.ExecRunner{}
 	}
-	return m.Runner.Run(ctx, "caddy", "reload", "--config", resolved, "--adapter", "caddyfile")
+	args := []string{"reload", "--config", resolved}
+	if adapter != "json" {
+		args = append(args, "--adapter", adapter)
+	}
+	return m.Runner.Run(ctx, "caddy", args...)
 }
 
 // EnsureBaseReady validates the base Caddyfile contains the include and no conflicting site blocks.
@@ -131,32 +195,125 @@ func (m Manager) EnsureBaseReady(basePath, includePath string, hosts []state.Hos
 		return system.WrapPermission("read", resolvedBase, err)
 	}
 
-	baseContent := string(data)
-	if err := ensureImportPresent(baseContent, includePath); err != nil {
+	cfg, err := caddyfile.Parse(data)
+	if err != nil {
 		return fmt.Errorf("base caddyfile %s invalid: %w", resolvedBase, err)
 	}
-	if usesTildeImport(baseContent, includePath) {
+	targets := topLevelImports(cfg)
+	if err := ensureImportPresent(targets, includePath); err != nil {
+		return fmt.Errorf("base caddyfile %s invalid: %w", resolvedBase, err)
+	}
+	if usesTildeImport(targets, includePath) {
 		alt := altHomeToken(includePath)
-		return fmt.Errorf("base caddyfile %s imports %s using ~; replace with absolute path %s", resolvedBase, alt, includePath)
+		return fmt.Errorf("base caddyfile %s imports %s: %w; replace with absolute path %s", resolvedBase, alt, ErrTildeImport, includePath)
 	}
-	if conflicts := detectConflicts(string(data), hosts); len(conflicts) > 0 {
+	if conflicts := detectConflicts(cfg, hosts); len(conflicts) > 0 {
 		sort.Strings(conflicts)
-		return fmt.Errorf("base caddyfile %s already defines hosts: %s", resolvedBase, strings.Join(conflicts, ", "))
+		return fmt.Errorf("base caddyfile %s already defines hosts: %s; `devhosts import` can adopt simple reverse_proxy sites", resolvedBase, strings.Join(conflicts, ", "))
 	}
 	return nil
 }
 
-func ensureImportPresent(content, includePath string) error {
-	tokens := []string{includePath}
+// FixBase makes the base Caddyfile import includePath by absolute path: it creates a missing
+// base, rewrites ~ imports of the include, and appends the import when there is none. It
+// reports whether the base changed.
+func (m Manager) FixBase(basePath, includePath string) (bool, error) {
+	resolvedBase, err := filesystem.ExpandUser(basePath)
+	if err != nil {
+		return false, err
+	}
+	data, err := m.FS.ReadFile(resolvedBase)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, system.WrapPermission("read", resolvedBase, err)
+	}
+	cfg, err := caddyfile.Parse(data)
+	if err != nil {
+		return false, fmt.Errorf("base caddyfile %s invalid: %w", resolvedBase, err)
+	}
+
+	content := string(data)
+	targets := topLevelImports(cfg)
+	switch {
+	case usesTildeImport(targets, includePath):
+		content = expandTildeImports(content, altHomeToken(includePath), includePath)
+	case ensureImportPresent(targets, includePath) != nil:
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += "import " + includePath + "\n"
+	default:
+		return false, nil
+	}
+	res, err := managedfile.Update(m.FS, resolvedBase, content)
+	return res.Changed, err
+}
+
+// expandTildeImports rewrites import lines whose target covers alt, the ~ form of includePath,
+// to use the absolute home directory instead.
+func expandTildeImports(content, alt, includePath string) string {
+	home := strings.TrimSuffix(includePath, strings.TrimPrefix(alt, "~"))
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "import" {
+			continue
+		}
+		target := strings.Trim(fields[1], `"`)
+		if strings.HasPrefix(target, "~/") && importMatches(target, alt) {
+			lines[i] = strings.Replace(line, target, home+strings.TrimPrefix(target, "~"), 1)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// topLevelImports returns the targets of imports that can pull site blocks into the base,
+// following imports of snippets that are themselves imported at the top level.
+func topLevelImports(cfg caddyfile.Config) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	var walk func(directives []caddyfile.Directive)
+	walk = func(directives []caddyfile.Directive) {
+		for _, d := range directives {
+			if d.Name != "import" || len(d.Args) == 0 {
+				continue
+			}
+			target := d.Args[0]
+			if snippet, ok := cfg.Snippet(target); ok {
+				if !seen[target] {
+					seen[target] = true
+					walk(snippet.Directives)
+				}
+				continue
+			}
+			targets = append(targets, target)
+		}
+	}
+	walk(cfg.Imports)
+	return targets
+}
+
+// importMatches reports whether an import target (a path or glob) covers path.
+func importMatches(target, path string) bool {
+	if target == path {
+		return true
+	}
+	matched, err := filepath.Match(target, path)
+	return err == nil && matched
+}
+
+func ensureImportPresent(targets []string, includePath string) error {
+	candidates := []string{includePath}
 	if alt := altHomeToken(includePath); alt != "" {
-		tokens = append(tokens, alt)
+		candidates = append(candidates, alt)
 	}
-	for _, token := range tokens {
-		if strings.Contains(content, "import \""+token+"\"") || strings.Contains(content, "import "+token) {
-			return nil
+	for _, target := range targets {
+		for _, candidate := range candidates {
+			if importMatches(target, candidate) {
+				return nil
+			}
 		}
 	}
-	return fmt.Errorf("missing required import for %s", includePath)
+	return fmt.Errorf("%w for %s", ErrImportMissing, includePath)
 }
 
 func altHomeToken(path string) string {
@@ -170,35 +327,23 @@ func altHomeToken(path string) string {
 	return ""
 }
 
-func usesTildeImport(content, includePath string) bool {
+func usesTildeImport(targets []string, includePath string) bool {
 	alt := altHomeToken(includePath)
 	if alt == "" {
 		return false
 	}
-	quoted := fmt.Sprintf("\"%s\"", alt)
-	lines := strings.Split(content, "\n")
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if !strings.HasPrefix(trimmed, "import") {
-			continue
-		}
-		fields := strings.Fields(trimmed)
-		if len(fields) < 2 {
-			continue
-		}
-		target := fields[1]
-		if target == alt || target == quoted {
+	for _, target := range targets {
+		if importMatches(target, alt) {
 			return true
 		}
 	}
 	return false
 }
 
-func detectConflicts(content string, hosts []state.Host) []string {
+func detectConflicts(cfg caddyfile.Config, hosts []state.Host) []string {
 	if len(hosts) == 0 {
 		return nil
 	}
-	lines := strings.Split(content, "\n")
 	lookup := make(map[string]struct{}, len(hosts))
 	for _, h := range hosts {
 		if h.Name != "" {
@@ -206,11 +351,12 @@ func detectConflicts(content string, hosts []state.Host) []string {
 		}
 	}
 	conflicts := make([]string, 0)
-	for _, raw := range lines {
-		trimmed := strings.TrimSpace(raw)
-		for name := range lookup {
-			if trimmed == name+"{" || strings.HasPrefix(trimmed, name+" ") || strings.HasPrefix(trimmed, name+"{") {
-				conflicts = append(conflicts, name)
+	for _, site := range cfg.Sites() {
+		for _, key := range site.Keys {
+			if name := caddyfile.SiteHost(key); name != "" {
+				if _, ok := lookup[name]; ok {
+					conflicts = append(conflicts, name)
+				}
 			}
 		}
 	}
@@ -234,9 +380,4 @@ func unique(values []string) []string {
 }
 
 // UpdateResult captures include file update metadata.
-type UpdateResult struct {
-	Changed  bool
-	Path     string
-	Previous []byte
-	Existed  bool
-}
+type UpdateResult = managedfile.UpdateResult
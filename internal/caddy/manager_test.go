@@ -2,6 +2,12 @@ package caddy
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +26,15 @@ func (f fakeRunner) Run(ctx context.Context, name string, args ...string) (cmdut
 	return cmdutil.Result{}, f.err
 }
 
+type recordingRunner struct {
+	calls [][]string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, name string, args ...string) (cmdutil.Result, error) {
+	r.calls = append(r.calls, append([]string{name}, args...))
+	return cmdutil.Result{}, nil
+}
+
 func TestGenerateInclude(t *testing.T) {
 	mgr := NewManager(filesystem.OS{}, fakeRunner{})
 	content := mgr.GenerateInclude([]state.Host{{Name: "user", Upstream: "http://localhost:8000", TLS: true}, {Name: "staff", Upstream: "http://127.0.0.1:9000"}})
@@ -30,6 +45,79 @@ func TestGenerateInclude(t *testing.T) {
 	}
 }
 
+func TestGenerateIncludeSkipsDisabled(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	disabled := state.Host{Name: "staff", Upstream: "http://127.0.0.1:9000"}
+	disabled.SetEnabled(false)
+	content := mgr.GenerateInclude([]state.Host{{Name: "user", Upstream: "http://localhost:8000"}, disabled})
+	if content != "user {\n  reverse_proxy http://localhost:8000\n}\n" {
+		t.Fatalf("unexpected include content:\n%s", content)
+	}
+}
+
+func TestGenerateIncludeGroups(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content := mgr.GenerateInclude([]state.Host{
+		{Name: "api", Upstream: "http://localhost:8001", Group: "billing"},
+		{Name: "invoices", Upstream: "http://localhost:8002", Group: "billing"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	})
+	expected := "user {\n  reverse_proxy http://localhost:8000\n}\n\n" +
+		"# group: billing\napi {\n  reverse_proxy http://localhost:8001\n}\n\n" +
+		"invoices {\n  reverse_proxy http://localhost:8002\n}\n"
+	if content != expected {
+		t.Fatalf("unexpected include content:\n%s", content)
+	}
+}
+
+func TestGenerateIncludeRoutes(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content := mgr.GenerateInclude([]state.Host{{
+		Name:     "app",
+		Upstream: "http://localhost:3000",
+		Routes: []state.Route{
+			{Path: "/api", Upstream: "http://localhost:5000", StripPrefix: true},
+			{Path: "/ws/", Upstream: "http://localhost:6000"},
+		},
+	}})
+	expected := "app {\n" +
+		"  handle_path /api* {\n    reverse_proxy http://localhost:5000\n  }\n\n" +
+		"  handle /ws* {\n    reverse_proxy http://localhost:6000\n  }\n\n" +
+		"  handle {\n    reverse_proxy http://localhost:3000\n  }\n}\n"
+	if content != expected {
+		t.Fatalf("unexpected include content:\n%s", content)
+	}
+}
+
+func TestGenerateIncludeUpstreamTransports(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content := mgr.GenerateInclude([]state.Host{
+		{Name: "secure", Upstream: "https://localhost:8443"},
+		{Name: "rails", Upstream: "unix//tmp/rails.sock"},
+		{Name: "v6", Upstream: "http://[::1]:8000"},
+	})
+	expected := "secure {\n  reverse_proxy https://localhost:8443 {\n    transport http {\n      tls_insecure_skip_verify\n    }\n  }\n}\n\n" +
+		"rails {\n  reverse_proxy unix//tmp/rails.sock\n}\n\n" +
+		"v6 {\n  reverse_proxy http://[::1]:8000\n}\n"
+	if content != expected {
+		t.Fatalf("unexpected include content:\n%s", content)
+	}
+}
+
+func TestGenerateIncludePool(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content := mgr.GenerateInclude([]state.Host{{
+		Name:      "api",
+		Upstreams: []string{"http://localhost:8000", "http://localhost:8001"},
+		Balancing: state.Balancing{LBPolicy: state.LBRoundRobin, HealthCheck: &state.HealthCheck{Path: "/health", Interval: "10s"}},
+	}})
+	expected := "api {\n  reverse_proxy http://localhost:8000 http://localhost:8001 {\n" +
+		"    lb_policy round_robin\n    health_uri /health\n    health_interval 10s\n  }\n}\n"
+	if content != expected {
+		t.Fatalf("unexpected include content:\n%s", content)
+	}
+}
+
 func TestEnsureBaseReadyDetectsImport(t *testing.T) {
 	dir := t.TempDir()
 	base := filepath.Join(dir, "Caddyfile")
@@ -119,3 +207,317 @@ func TestUpdateAndRestoreInclude(t *testing.T) {
 		t.Fatalf("expected include to be restored, got %s", string(restored))
 	}
 }
+
+func writeBase(t *testing.T, content string) string {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "Caddyfile")
+	if err := os.WriteFile(base, []byte(content), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	return base
+}
+
+func TestReloadUsesAdminAPI(t *testing.T) {
+	var adapted string
+	var loaded map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/adapt":
+			if r.Header.Get("Content-Type") != "text/caddyfile" {
+				t.Errorf("unexpected adapt content type %q", r.Header.Get("Content-Type"))
+			}
+			adapted = string(body)
+			_, _ = w.Write([]byte(`{"result":{"apps":{"http":{}}}}`))
+		case "/load":
+			if err := json.Unmarshal(body, &loaded); err != nil {
+				t.Errorf("load body not JSON: %v", err)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	base := writeBase(t, "user {\n}\n")
+	runner := &recordingRunner{}
+	mgr := NewManager(filesystem.OS{}, runner)
+	if _, err := mgr.Reload(context.Background(), base, strings.TrimPrefix(srv.URL, "http://")); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if adapted != "user {\n}\n" {
+		t.Fatalf("expected base Caddyfile to be adapted, got %q", adapted)
+	}
+	if _, ok := loaded["apps"]; !ok {
+		t.Fatalf("expected adapted config to be loaded, got %v", loaded)
+	}
+	if len(runner.calls) != 0 {
+		t.Fatalf("expected CLI not to run, got %v", runner.calls)
+	}
+}
+
+func TestReloadSurfacesAdminError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"adapting config using caddyfile: unrecognized directive: bogus"}`))
+	}))
+	defer srv.Close()
+
+	base := writeBase(t, "user {\n  bogus\n}\n")
+	mgr := NewManager(filesystem.OS{}, &recordingRunner{})
+	_, err := mgr.Reload(context.Background(), base, strings.TrimPrefix(srv.URL, "http://"))
+	var adminErr *AdminError
+	if !errors.As(err, &adminErr) {
+		t.Fatalf("expected AdminError, got %v", err)
+	}
+	if adminErr.Status != http.StatusBadRequest || !strings.Contains(adminErr.Message, "unrecognized directive") {
+		t.Fatalf("unexpected admin error: %+v", adminErr)
+	}
+}
+
+func TestAdminClientDefaultsToATimeout(t *testing.T) {
+	if c := NewAdminClient("", nil); c.HTTP.Timeout != AdminTimeout {
+		t.Fatalf("default admin client timeout = %s, want %s", c.HTTP.Timeout, AdminTimeout)
+	}
+}
+
+func TestReloadFallsBackToCLI(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	base := writeBase(t, "user {\n}\n")
+	runner := &recordingRunner{}
+	mgr := NewManager(filesystem.OS{}, runner)
+	if _, err := mgr.Reload(context.Background(), base, addr); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if len(runner.calls) != 1 || runner.calls[0][0] != "caddy" || runner.calls[0][1] != "reload" {
+		t.Fatalf("expected caddy reload fallback, got %v", runner.calls)
+	}
+}
+
+func TestGenerateJSONStandalone(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "missing.json")
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content, err := mgr.GenerateJSON(base, []state.Host{
+		{Name: "user", Upstream: "http://localhost:8000", TLS: true},
+		{Name: "staff", Upstream: "http://127.0.0.1:9000"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateJSON returned error: %v", err)
+	}
+	var cfg struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]struct {
+					Listen []string    `json:"listen"`
+					Routes []jsonRoute `json:"routes"`
+				} `json:"servers"`
+			} `json:"http"`
+			TLS struct {
+				Automation struct {
+					Policies []jsonTLSPolicy `json:"policies"`
+				} `json:"automation"`
+			} `json:"tls"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal([]byte(content), &cfg); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, content)
+	}
+	secure := cfg.Apps.HTTP.Servers[jsonHTTPSServer]
+	if len(secure.Routes) != 1 || secure.Routes[0].Match[0].Host[0] != "user" || secure.Routes[0].Handle[0].Upstreams[0].Dial != "localhost:8000" {
+		t.Fatalf("unexpected https server: %+v", secure)
+	}
+	plain := cfg.Apps.HTTP.Servers[jsonHTTPServer]
+	if len(plain.Listen) != 1 || plain.Listen[0] != ":80" || plain.Routes[0].Match[0].Host[0] != "staff" {
+		t.Fatalf("unexpected http server: %+v", plain)
+	}
+	policies := cfg.Apps.TLS.Automation.Policies
+	if len(policies) != 1 || policies[0].Subjects[0] != "user" || policies[0].Issuers[0].Module != "internal" {
+		t.Fatalf("unexpected tls policies: %+v", policies)
+	}
+}
+
+func TestGenerateJSONMergesIntoBase(t *testing.T) {
+	base := writeBase(t, `{"apps":{"http":{"servers":{"main":{"listen":[":443"],"routes":[{"match":[{"host":["example.localhost"]}],"handle":[{"handler":"static_response"}]}]}}}}}`)
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	content, err := mgr.GenerateJSON(base, []state.Host{{Name: "user", Upstream: "http://localhost:8000", TLS: true}})
+	if err != nil {
+		t.Fatalf("GenerateJSON returned error: %v", err)
+	}
+	if strings.Contains(content, `"`+jsonHTTPSServer+`"`) {
+		t.Fatalf("expected routes to join the existing :443 server:\n%s", content)
+	}
+	if strings.Index(content, "devhosts-user") > strings.Index(content, "example.localhost") {
+		t.Fatalf("expected managed route ahead of existing routes:\n%s", content)
+	}
+
+	if _, err := mgr.GenerateJSON(base, []state.Host{{Name: "example.localhost", Upstream: "http://localhost:8000"}}); err == nil {
+		t.Fatalf("expected conflict with existing host matcher")
+	}
+
+	notJSON := writeBase(t, "user {\n}\n")
+	if _, err := mgr.GenerateJSON(notJSON, nil); err == nil {
+		t.Fatalf("expected Caddyfile base to be rejected in JSON mode")
+	}
+}
+
+func TestEnsureBaseReadyCases(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("UserHomeDir: %v", err)
+	}
+	include := filepath.Join(home, ".devhosts.test.caddy")
+	hosts := []state.Host{{Name: "user"}, {Name: "admin"}}
+	cases := []struct {
+		name    string
+		base    string
+		wantErr string
+	}{
+		{"commented import", "# import " + include + "\n", "missing required import"},
+		{"quoted import", "import \"" + include + "\"\n", ""},
+		{"glob import", "import " + filepath.Join(home, ".devhosts.*.caddy") + "\n", ""},
+		{"snippet import", "(dev) {\n\timport " + include + "\n}\nimport dev\n", ""},
+		{"import inside site", "other {\n\timport " + include + "\n}\n", "missing required import"},
+		{"address with port", "import " + include + "\nuser:80 {\n}\n", "already defines hosts: user"},
+		{"address with scheme", "import " + include + "\nhttp://user {\n}\n", "already defines hosts: user"},
+		{"address list", "import " + include + "\nuser, admin {\n}\n", "already defines hosts: admin, user"},
+		{"upstream named like host", "import " + include + "\nother {\n\treverse_proxy user:8000\n}\n", ""},
+		{"commented site", "import " + include + "\n# user {\n# }\n", ""},
+	}
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := writeBase(t, tc.base)
+			err := mgr.EnsureBaseReady(base, include, hosts)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected base to be accepted: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFixBase(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatalf("UserHomeDir: %v", err)
+	}
+	include := filepath.Join(home, ".devhosts.test.caddy")
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	cases := []struct {
+		name     string
+		base     string
+		expected string
+		changed  bool
+	}{
+		{"tilde import", "{\n\tadmin off\n}\nimport ~/.devhosts.test.caddy\n", "{\n\tadmin off\n}\nimport " + include + "\n", true},
+		{"quoted tilde glob", "import \"~/.devhosts.*.caddy\"\n", "import \"" + filepath.Join(home, ".devhosts.*.caddy") + "\"\n", true},
+		{"missing import", "other {\n}", "other {\n}\nimport " + include + "\n", true},
+		{"already imported", "import " + include + "\n", "import " + include + "\n", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			base := writeBase(t, tc.base)
+			changed, err := mgr.FixBase(base, include)
+			if err != nil {
+				t.Fatalf("FixBase returned error: %v", err)
+			}
+			data, _ := os.ReadFile(base)
+			if changed != tc.changed || string(data) != tc.expected {
+				t.Fatalf("changed=%v, base:\n%s", changed, data)
+			}
+			if err := mgr.EnsureBaseReady(base, include, nil); err != nil {
+				t.Fatalf("fixed base still rejected: %v", err)
+			}
+		})
+	}
+
+	missing := filepath.Join(t.TempDir(), "conf", "Caddyfile")
+	if err := mgr.EnsureBaseReady(missing, include, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing base to be reported, got %v", err)
+	}
+	if changed, err := mgr.FixBase(missing, include); err != nil || !changed {
+		t.Fatalf("expected missing base to be created, got %v, %v", changed, err)
+	}
+	if err := mgr.EnsureBaseReady(missing, include, nil); err != nil {
+		t.Fatalf("created base rejected: %v", err)
+	}
+}
+
+func TestSimpleSitesAndCommentOut(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "Caddyfile")
+	content := "{\n\tadmin localhost:2019\n}\n\nshop {\n\treverse_proxy :3000\n}\n\nhttp://api {\n\treverse_proxy 127.0.0.1:5000\n}\n\n" +
+		"docs {\n\ttls internal\n\treverse_proxy localhost:4000\n}\n\nblog:8443 {\n\treverse_proxy localhost:4001\n}\n\n" +
+		"wiki {\n\treverse_proxy localhost:4002\n\tencode gzip\n}\n\nremote {\n\treverse_proxy 10.0.0.5:80\n}\n"
+	if err := os.WriteFile(base, []byte(content), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+
+	sites, err := mgr.SimpleSites(base)
+	if err != nil {
+		t.Fatalf("SimpleSites returned error: %v", err)
+	}
+	want := []Site{
+		{Name: "shop", Upstream: "http://localhost:3000", TLS: true, Line: 5, End: 7},
+		{Name: "api", Upstream: "http://127.0.0.1:5000", Line: 9, End: 11},
+		{Name: "docs", Upstream: "http://localhost:4000", TLS: true, Line: 13, End: 16},
+	}
+	if len(sites) != len(want) {
+		t.Fatalf("SimpleSites = %+v, want %+v", sites, want)
+	}
+	for i := range want {
+		if sites[i] != want[i] {
+			t.Fatalf("site %d = %+v, want %+v", i, sites[i], want[i])
+		}
+	}
+
+	plan, err := mgr.PlanCommentSites(base, sites[:1])
+	if err != nil {
+		t.Fatalf("PlanCommentSites returned error: %v", err)
+	}
+	if !strings.Contains(plan.Content, "\n# shop {\n# \treverse_proxy :3000\n# }\n\nhttp://api {") {
+		t.Fatalf("unexpected content:\n%s", plan.Content)
+	}
+	if _, backup, err := mgr.WriteBase(plan); err != nil || backup == "" {
+		t.Fatalf("WriteBase = %q, %v; want a backup", backup, err)
+	} else if data, _ := os.ReadFile(backup); string(data) != content {
+		t.Fatalf("backup does not hold the original base")
+	}
+}
+
+func TestSiteSharingALineIsNotCommentedOut(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "Caddyfile")
+	content := "shop {\n\treverse_proxy :3000\n} docs {\n\treverse_proxy :4000\n}\n\nblog {\n\treverse_proxy :5000\n}\n"
+	if err := os.WriteFile(base, []byte(content), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	mgr := NewManager(filesystem.OS{}, fakeRunner{})
+	sites, err := mgr.SimpleSites(base)
+	if err != nil {
+		t.Fatalf("SimpleSites returned error: %v", err)
+	}
+	if len(sites) != 3 || !sites[0].Shared || !sites[1].Shared || sites[2].Shared {
+		t.Fatalf("expected shop and docs to be marked shared, got %+v", sites)
+	}
+	if _, err := mgr.PlanCommentSites(base, sites[:1]); err == nil || !strings.Contains(err.Error(), "shares a line") {
+		t.Fatalf("expected a shared site to be refused, got %v", err)
+	}
+	plan, err := mgr.PlanCommentSites(base, sites[2:])
+	if err != nil {
+		t.Fatalf("PlanCommentSites returned error: %v", err)
+	}
+	if !strings.HasSuffix(plan.Content, "\n# blog {\n# \treverse_proxy :5000\n# }\n") {
+		t.Fatalf("unexpected content:\n%s", plan.Content)
+	}
+}
@@ -0,0 +1,36 @@
+package caddy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// LocalCARoot returns where Caddy stores the root certificate of its local CA, the issuer
+// behind `tls internal`. It mirrors Caddy's AppDataDir lookup for the current user.
+func LocalCARoot() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pki", "authorities", "local", "root.crt"), nil
+}
+
+func dataDir() (string, error) {
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "caddy"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return filepath.Join(home, "Library", "Application Support", "Caddy"), nil
+	case "windows":
+		if appData := os.Getenv("AppData"); appData != "" {
+			return filepath.Join(appData, "Caddy"), nil
+		}
+	}
+	return filepath.Join(home, ".local", "share", "caddy"), nil
+}
@@ -0,0 +1,119 @@
+package caddyfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"comments", "# import /x\nuser { # trailing\n}\n", []string{"user", "{", "}"}},
+		{"quotes", "respond \"hello world\" `raw \\\"`\n", []string{"respond", "hello world", "raw \\\""}},
+		{"escapes", `header X "a \"b\""`, []string{"header", "X", `a "b"`}},
+		{"glued brace", "user{\n}\n", []string{"user", "{", "}"}},
+		{"placeholder", "respond {host}\n", []string{"respond", "{host}"}},
+		{"hash inside word", "respond a#b\n", []string{"respond", "a#b"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := Tokenize([]byte(tc.input))
+			if err != nil {
+				t.Fatalf("Tokenize returned error: %v", err)
+			}
+			var got []string
+			for _, tok := range tokens {
+				got = append(got, tok.Text)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeUnterminatedQuote(t *testing.T) {
+	if _, err := Tokenize([]byte(`respond "oops`)); err == nil {
+		t.Fatalf("expected unterminated quote to error")
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `{
+	admin localhost:2019
+}
+
+(common) {
+	import /etc/caddy/shared/*.caddy
+}
+
+import common
+import "/home/me/.devhosts.caddy"
+
+user, http://admin:8080 {
+	reverse_proxy localhost:8000 {
+		header_up Host {host}
+	}
+}
+
+"multi
+line" {
+	respond ok
+}
+`
+	cfg, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if len(cfg.Blocks) != 4 || !cfg.Blocks[0].Global {
+		t.Fatalf("unexpected blocks: %+v", cfg.Blocks)
+	}
+	if name, ok := cfg.Blocks[1].SnippetName(); !ok || name != "common" {
+		t.Fatalf("expected snippet, got %+v", cfg.Blocks[1])
+	}
+	if len(cfg.Imports) != 2 || cfg.Imports[1].Args[0] != "/home/me/.devhosts.caddy" {
+		t.Fatalf("unexpected imports: %+v", cfg.Imports)
+	}
+	sites := cfg.Sites()
+	if len(sites) != 2 || !reflect.DeepEqual(sites[0].Keys, []string{"user", "http://admin:8080"}) {
+		t.Fatalf("unexpected sites: %+v", sites)
+	}
+	if sites[0].Line != 12 || sites[0].End != 16 || sites[1].Line != 18 || sites[1].End != 21 {
+		t.Fatalf("unexpected site lines: %d-%d, %d-%d", sites[0].Line, sites[0].End, sites[1].Line, sites[1].End)
+	}
+	proxy := sites[0].Directives[0]
+	if proxy.Name != "reverse_proxy" || proxy.Args[0] != "localhost:8000" || proxy.Block[0].Name != "header_up" {
+		t.Fatalf("unexpected directive: %+v", proxy)
+	}
+	if sites[1].Directives[0].Name != "respond" {
+		t.Fatalf("quoted multi-line key should not swallow the next line: %+v", sites[1])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, input := range []string{"user {\n", "}\n", "user {\n  handle {\n}\n"} {
+		if _, err := Parse([]byte(input)); err == nil {
+			t.Fatalf("expected error for %q", input)
+		}
+	}
+}
+
+func TestSiteHost(t *testing.T) {
+	cases := map[string]string{
+		"user":                "user",
+		"user:80":             "user",
+		"http://user":         "user",
+		"https://User:8443/x": "user",
+		"[::1]:80":            "::1",
+		":443":                "",
+		"*.user":              "*.user",
+	}
+	for in, want := range cases {
+		if got := SiteHost(in); got != want {
+			t.Errorf("SiteHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
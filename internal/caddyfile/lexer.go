@@ -0,0 +1,95 @@
+// Package caddyfile tokenizes and parses the subset of the Caddyfile format devhosts inspects.
+package caddyfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Token is a single Caddyfile word with the line it starts on.
+type Token struct {
+	Text   string
+	Line   int
+	Quoted bool
+
+	// newlines counts line breaks inside a quoted token so the parser can find the next line.
+	newlines int
+}
+
+func (t Token) endLine() int { return t.Line + t.newlines }
+
+// isBrace reports whether the token is an unquoted block delimiter.
+func (t Token) isBrace(brace string) bool { return !t.Quoted && t.Text == brace }
+
+// Tokenize splits Caddyfile input into tokens, dropping comments.
+//
+// Words are separated by whitespace; "double quoted" strings honor backslash escapes and
+// `backtick` strings are taken literally. A # begins a comment only at the start of a word.
+// A trailing { glued to a word (site{) is split off so it still opens a block.
+func Tokenize(input []byte) ([]Token, error) {
+	var tokens []Token
+	src := []rune(string(input))
+	line := 1
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			i++
+		case ch == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case ch == '"' || ch == '`':
+			start := line
+			text, newlines, next, err := readQuoted(src, i)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", start, err)
+			}
+			tokens = append(tokens, Token{Text: text, Line: start, Quoted: true, newlines: newlines})
+			line += newlines
+			i = next
+		default:
+			start := i
+			for i < len(src) && !isSpace(src[i]) {
+				i++
+			}
+			word := string(src[start:i])
+			if len(word) > 1 && strings.HasSuffix(word, "{") && strings.Count(word, "{") == 1 {
+				tokens = append(tokens, Token{Text: strings.TrimSuffix(word, "{"), Line: line}, Token{Text: "{", Line: line})
+				continue
+			}
+			tokens = append(tokens, Token{Text: word, Line: line})
+		}
+	}
+	return tokens, nil
+}
+
+func readQuoted(src []rune, i int) (string, int, int, error) {
+	quote := src[i]
+	i++
+	var b strings.Builder
+	newlines := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == quote:
+			return b.String(), newlines, i + 1, nil
+		case ch == '\\' && quote == '"' && i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\\'):
+			b.WriteRune(src[i+1])
+			i += 2
+			continue
+		case ch == '\n':
+			newlines++
+		}
+		b.WriteRune(ch)
+		i++
+	}
+	return "", 0, 0, fmt.Errorf("unterminated %c quote", quote)
+}
+
+func isSpace(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n'
+}
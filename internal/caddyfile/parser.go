@@ -0,0 +1,227 @@
+package caddyfile
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Directive is one line of a block: a name, its arguments, and an optional nested block.
+type Directive struct {
+	Name  string
+	Args  []string
+	Line  int
+	Block []Directive
+}
+
+// ServerBlock is a top-level block: a site with its addresses, a (snippet), or global options.
+type ServerBlock struct {
+	Keys []string
+	Line int
+	// End is the line the block finishes on: its closing brace, or its last token without braces.
+	End        int
+	Directives []Directive
+	Global     bool
+}
+
+// SnippetName returns the snippet name when the block is defined as (name).
+func (b ServerBlock) SnippetName() (string, bool) {
+	if len(b.Keys) != 1 {
+		return "", false
+	}
+	key := b.Keys[0]
+	if len(key) > 2 && strings.HasPrefix(key, "(") && strings.HasSuffix(key, ")") {
+		return key[1 : len(key)-1], true
+	}
+	return "", false
+}
+
+// Config is a parsed Caddyfile.
+type Config struct {
+	// Imports holds import directives that appear outside any block.
+	Imports []Directive
+	Blocks  []ServerBlock
+}
+
+// Snippet looks up a snippet definition by name.
+func (c Config) Snippet(name string) (ServerBlock, bool) {
+	for _, b := range c.Blocks {
+		if n, ok := b.SnippetName(); ok && n == name {
+			return b, true
+		}
+	}
+	return ServerBlock{}, false
+}
+
+// Sites returns the site blocks, skipping global options and snippets.
+func (c Config) Sites() []ServerBlock {
+	var sites []ServerBlock
+	for _, b := range c.Blocks {
+		if _, snippet := b.SnippetName(); b.Global || snippet {
+			continue
+		}
+		sites = append(sites, b)
+	}
+	return sites
+}
+
+// Parse tokenizes and parses Caddyfile input.
+func Parse(input []byte) (Config, error) {
+	tokens, err := Tokenize(input)
+	if err != nil {
+		return Config{}, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parse()
+}
+
+// SiteHost extracts the hostname from a site address such as https://user:8443/path.
+func SiteHost(address string) string {
+	addr := address
+	if idx := strings.Index(addr, "://"); idx >= 0 {
+		addr = addr[idx+3:]
+	}
+	if idx := strings.Index(addr, "/"); idx >= 0 {
+		addr = addr[:idx]
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return strings.ToLower(strings.Trim(addr, "[]"))
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+func (p *parser) eof() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() Token { return p.tokens[p.pos] }
+
+// sameLine reports whether the next token continues the line of the previous one.
+func (p *parser) sameLine() bool {
+	if p.eof() || p.pos == 0 {
+		return false
+	}
+	return p.tokens[p.pos].Line == p.tokens[p.pos-1].endLine()
+}
+
+func (p *parser) parse() (Config, error) {
+	var cfg Config
+	for !p.eof() {
+		tok := p.peek()
+		switch {
+		case tok.isBrace("{") && len(cfg.Blocks) == 0:
+			p.pos++
+			body, err := p.block(tok.Line)
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Blocks = append(cfg.Blocks, ServerBlock{Line: tok.Line, End: p.tokens[p.pos-1].endLine(), Directives: body, Global: true})
+		case tok.isBrace("}"):
+			return Config{}, fmt.Errorf("line %d: unexpected '}'", tok.Line)
+		case tok.Text == "import" && !tok.Quoted:
+			d, err := p.directive()
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Imports = append(cfg.Imports, d)
+		default:
+			block, err := p.serverBlock()
+			if err != nil {
+				return Config{}, err
+			}
+			cfg.Blocks = append(cfg.Blocks, block)
+		}
+	}
+	return cfg, nil
+}
+
+func (p *parser) serverBlock() (ServerBlock, error) {
+	block := ServerBlock{Line: p.peek().Line}
+	for {
+		tok := p.peek()
+		p.pos++
+		if tok.isBrace("{") {
+			body, err := p.block(tok.Line)
+			if err != nil {
+				return ServerBlock{}, err
+			}
+			block.Directives = body
+			block.End = p.tokens[p.pos-1].endLine()
+			return block, nil
+		}
+		for _, key := range strings.Split(tok.Text, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				block.Keys = append(block.Keys, key)
+			}
+		}
+		if p.eof() {
+			block.End = tok.endLine()
+			return block, nil
+		}
+		if !p.sameLine() && !strings.HasSuffix(tok.Text, ",") {
+			// A lone site may omit braces; everything after its address belongs to it.
+			body, err := p.directives()
+			if err != nil {
+				return ServerBlock{}, err
+			}
+			if !p.eof() {
+				return ServerBlock{}, fmt.Errorf("line %d: unexpected '}'", p.peek().Line)
+			}
+			block.Directives = body
+			block.End = p.tokens[p.pos-1].endLine()
+			return block, nil
+		}
+	}
+}
+
+// block parses directives up to and including the closing brace of a block opened on line.
+func (p *parser) block(line int) ([]Directive, error) {
+	body, err := p.directives()
+	if err != nil {
+		return nil, err
+	}
+	if p.eof() {
+		return nil, fmt.Errorf("line %d: unclosed block", line)
+	}
+	p.pos++
+	return body, nil
+}
+
+// directives parses lines until an unquoted } or the end of input, leaving the brace unconsumed.
+func (p *parser) directives() ([]Directive, error) {
+	var out []Directive
+	for !p.eof() && !p.peek().isBrace("}") {
+		d, err := p.directive()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (p *parser) directive() (Directive, error) {
+	tok := p.peek()
+	p.pos++
+	d := Directive{Name: tok.Text, Line: tok.Line}
+	for p.sameLine() {
+		next := p.peek()
+		if next.isBrace("}") {
+			break
+		}
+		p.pos++
+		if next.isBrace("{") {
+			body, err := p.block(next.Line)
+			if err != nil {
+				return Directive{}, err
+			}
+			d.Block = body
+			break
+		}
+		d.Args = append(d.Args, next.Text)
+	}
+	return d, nil
+}
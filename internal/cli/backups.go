@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/diff"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+)
+
+// backupOutput describes one hosts file backup.
+type backupOutput struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	HasBlock bool      `json:"has_block"`
+	Names    []string  `json:"names"`
+}
+
+func newBackupOutput(b hostsfile.Backup) backupOutput {
+	names := b.Names
+	if names == nil {
+		names = []string{}
+	}
+	return backupOutput{ID: b.ID, Path: b.Path, Time: b.Time, Size: b.Size, HasBlock: b.HasBlock, Names: names}
+}
+
+// backupsWrite reports whether the backups subcommand in args changes files.
+func backupsWrite(args []string) bool {
+	return len(args) > 0 && (args[0] == "restore" || args[0] == "prune")
+}
+
+func (a *App) handleBackups(args []string) error {
+	backupFlags := flag.NewFlagSet("backups", flag.ContinueOnError)
+	backupFlags.SetOutput(a.Stderr)
+	var keep int
+	var olderThan string
+	backupFlags.IntVar(&keep, "keep", 0, "prune: keep this many of the newest backups")
+	backupFlags.StringVar(&olderThan, "older-than", "", "prune: keep backups newer than this age, e.g. 72h or 14d")
+	backupFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts backups [list|show|restore|prune] [flags] [backup]\n\n")
+		fmt.Fprintln(a.Stderr, "  list              List hosts file backups, newest first (default)")
+		fmt.Fprintln(a.Stderr, "  show <backup>     Diff the current hosts file against a backup")
+		fmt.Fprintln(a.Stderr, "  restore <backup>  Replace the hosts file with a backup, backing up the current one")
+		fmt.Fprintln(a.Stderr, "  prune             Delete backups outside --keep and --older-than")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "A backup is named by its ID from list, its file name, or its path.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		backupFlags.PrintDefaults()
+	}
+	sub := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+	if err := backupFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	rest := backupFlags.Args()
+
+	switch sub {
+	case "list":
+		if len(rest) > 0 {
+			return usageError("backups list takes no arguments")
+		}
+		return a.listBackups()
+	case "show", "restore":
+		if len(rest) != 1 {
+			return usageError("backups %s requires exactly one backup", sub)
+		}
+		b, err := a.Hosts.FindBackup(a.HostsPath, rest[0])
+		if err != nil {
+			return err
+		}
+		if sub == "show" {
+			return a.showBackup(b)
+		}
+		return a.restoreBackup(b)
+	case "prune":
+		if len(rest) > 0 {
+			return usageError("backups prune takes no arguments")
+		}
+		policy := hostsfile.Retention{Keep: keep}
+		if olderThan != "" {
+			age, err := parseAge(olderThan)
+			if err != nil {
+				return usageError("--older-than %q invalid: %v", olderThan, err)
+			}
+			policy.MaxAge = age
+		}
+		if policy.Keep <= 0 && policy.MaxAge <= 0 {
+			return usageError("backups prune requires --keep or --older-than")
+		}
+		return a.pruneBackups(policy)
+	default:
+		backupFlags.Usage()
+		return usageError("unknown backups command %q", sub)
+	}
+}
+
+func (a *App) listBackups() error {
+	backups, err := a.Hosts.Backups(a.HostsPath)
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		out := make([]backupOutput, 0, len(backups))
+		for _, b := range backups {
+			out = append(out, newBackupOutput(b))
+		}
+		return a.emit(struct {
+			Backups []backupOutput `json:"backups"`
+		}{out})
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(a.Stdout, "No backups of %s.\n", a.HostsPath)
+		return nil
+	}
+	tw := tabwriter.NewWriter(a.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tSIZE\tMANAGED")
+	for _, b := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", b.ID, b.Time.Format(time.DateTime), b.Size, blockSummary(b))
+	}
+	return tw.Flush()
+}
+
+// blockSummary describes a backup's managed block in a few words.
+func blockSummary(b hostsfile.Backup) string {
+	switch {
+	case !b.HasBlock:
+		return "no block"
+	case len(b.Names) <= 3:
+		return fmt.Sprintf("%d host(s): %s", len(b.Names), strings.Join(b.Names, " "))
+	default:
+		return fmt.Sprintf("%d host(s): %s ...", len(b.Names), strings.Join(b.Names[:3], " "))
+	}
+}
+
+func (a *App) showBackup(b hostsfile.Backup) error {
+	plan, err := a.Hosts.PlanRestore(a.HostsPath, b)
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		return a.emit(struct {
+			Backup backupOutput `json:"backup"`
+			Diff   string       `json:"diff"`
+		}{newBackupOutput(b), fileDiff(plan)})
+	}
+	if !plan.Changed {
+		fmt.Fprintf(a.Stdout, "%s matches %s.\n", b.ID, plan.Path)
+		return nil
+	}
+	fmt.Fprint(a.Stdout, diff.Unified(plan.Path, b.Path, string(plan.Previous), plan.Content))
+	return nil
+}
+
+func (a *App) restoreBackup(b hostsfile.Backup) error {
+	if a.DryRun {
+		return a.showBackup(b)
+	}
+	res, err := a.Hosts.RestoreBackup(a.HostsPath, b)
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		files := []fileChange{{Path: a.HostsPath, Changed: res.Changed, Backup: res.BackupPath}}
+		return a.emit(changeOutput{Hosts: newBackupOutput(b).Names, Files: files})
+	}
+	if !res.Changed {
+		fmt.Fprintf(a.Stdout, "%s already matches %s.\n", a.HostsPath, b.ID)
+		return nil
+	}
+	fmt.Fprintf(a.Stdout, "Restored %s from %s.\n", res.Path, b.ID)
+	if res.BackupPath != "" {
+		fmt.Fprintf(a.Stdout, "Previous contents saved to %s.\n", res.BackupPath)
+	}
+	fmt.Fprintln(a.Stdout, "Run `devhosts apply` to bring the hosts file back in line with devhosts.json.")
+	return nil
+}
+
+func (a *App) pruneBackups(policy hostsfile.Retention) error {
+	pruned, err := a.Hosts.Prune(a.HostsPath, policy, a.DryRun)
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		out := make([]backupOutput, 0, len(pruned))
+		for _, b := range pruned {
+			out = append(out, newBackupOutput(b))
+		}
+		return a.emit(struct {
+			Pruned []backupOutput `json:"pruned"`
+		}{out})
+	}
+	verb := "Removed"
+	if a.DryRun {
+		verb = "Would remove"
+	}
+	for _, b := range pruned {
+		fmt.Fprintf(a.Stdout, "%s %s\n", verb, b.Path)
+	}
+	fmt.Fprintf(a.Stdout, "%s %d backup(s).\n", verb, len(pruned))
+	return nil
+}
+
+// parseAge accepts a time.Duration or a whole number of days such as "14d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, errors.New("days must be a positive whole number")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, errors.New("must be positive")
+	}
+	return d, nil
+}
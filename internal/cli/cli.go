@@ -7,14 +7,20 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/cdfuller/devhosts/internal/backend"
 	"github.com/cdfuller/devhosts/internal/caddy"
 	"github.com/cdfuller/devhosts/internal/cmdutil"
 	"github.com/cdfuller/devhosts/internal/config"
 	"github.com/cdfuller/devhosts/internal/filesystem"
 	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/journal"
+	"github.com/cdfuller/devhosts/internal/lockfile"
+	"github.com/cdfuller/devhosts/internal/managedfile"
 	"github.com/cdfuller/devhosts/internal/state"
 )
 
@@ -25,17 +31,38 @@ type App struct {
 	Loader    config.Loader
 	Hosts     hostsfile.Manager
 	Caddy     caddy.Manager
+	Stdin     io.Reader
 	Stdout    io.Writer
 	Stderr    io.Writer
 	HostsPath string
+	// StateDir is filled from the loaded config when left empty.
+	StateDir string
+	// DryRun makes add, remove, and apply print their plan instead of applying it.
+	DryRun bool
+	// Output selects text or a structured json/yaml document on stdout.
+	Output string
+	// WorkDir is where up and down start looking for a project manifest; empty means the cwd.
+	WorkDir string
+	// LockTimeout is how long a command that writes state waits for another devhosts to finish.
+	LockTimeout time.Duration
+
+	command string
+	// commandLine is the command and its arguments, recorded in the config history.
+	commandLine []string
+	emitted     bool
+	lock        *lockfile.Lock
 }
 
+// defaultLockTimeout bounds the wait for a concurrent devhosts before giving up.
+const defaultLockTimeout = 10 * time.Second
+
 // Execute is the entrypoint invoked by main.
 func Execute(ctx context.Context, args []string) error {
 	app := &App{
 		Loader:    config.NewLoader(filesystem.OS{}),
 		Hosts:     hostsfile.NewManager(filesystem.OS{}),
 		Caddy:     caddy.NewManager(filesystem.OS{}, cmdutil.ExecRunner{}),
+		Stdin:     os.Stdin,
 		Stdout:    os.Stdout,
 		Stderr:    os.Stderr,
 		HostsPath: defaultHostsPath,
@@ -43,8 +70,20 @@ func Execute(ctx context.Context, args []string) error {
 	return app.Run(ctx, args)
 }
 
-// Run parses CLI arguments and dispatches subcommands.
+// Run parses CLI arguments and dispatches subcommands. With structured output, errors are
+// written to stdout as well and come back marked so main does not print them again.
 func (a *App) Run(ctx context.Context, args []string) error {
+	err := a.run(ctx, args)
+	if err != nil && a.structured() {
+		return a.reportError(err)
+	}
+	return err
+}
+
+func (a *App) run(ctx context.Context, args []string) error {
+	if a.Stdin == nil {
+		a.Stdin = os.Stdin
+	}
 	if a.Stdout == nil {
 		a.Stdout = os.Stdout
 	}
@@ -63,82 +102,235 @@ func (a *App) Run(ctx context.Context, args []string) error {
 	root.StringVar(&configPath, "config", "", "path to devhosts.json")
 	root.StringVar(&baseOverride, "caddyfile", "", "path to base Caddyfile")
 	root.StringVar(&includeOverride, "include", "", "path to managed include Caddyfile")
+	root.BoolVar(&a.DryRun, "dry-run", a.DryRun, "show what add, remove, and apply would change without changing it")
+	output := a.Output
+	if output == "" {
+		output = OutputText
+	}
+	root.StringVar(&output, "output", output, "output format: text, json, or yaml")
+	root.StringVar(&output, "o", output, "shorthand for --output")
+	if a.LockTimeout == 0 {
+		a.LockTimeout = defaultLockTimeout
+	}
+	root.DurationVar(&a.LockTimeout, "lock-timeout", a.LockTimeout, "how long to wait for another devhosts to finish")
 	root.Usage = func() {
 		fmt.Fprintf(a.Stderr, "Usage: devhosts [global flags] <command> [args]\n\n")
 		fmt.Fprintln(a.Stderr, "Commands:")
-		fmt.Fprintln(a.Stderr, "  list                 Show managed hostnames, upstreams, and TLS state")
+		fmt.Fprintln(a.Stderr, "  list [--group NAME]  Show managed hostnames, upstreams, and TLS state")
 		fmt.Fprintln(a.Stderr, "  add [flags] <spec>   Create/update hosts; TLS on by default (spec = host:port or host=upstream)")
 		fmt.Fprintln(a.Stderr, "  remove <host> [...]   Delete one or more managed hosts")
+		fmt.Fprintln(a.Stderr, "  disable <host> [...]  Stop routing hosts but keep them in devhosts.json")
+		fmt.Fprintln(a.Stderr, "  enable <host> [...]   Route previously disabled hosts again")
+		fmt.Fprintln(a.Stderr, "  status [--json]      Check hosts file, DNS, upstream, proxy, and TLS for every host")
+		fmt.Fprintln(a.Stderr, "  doctor [--fix]       Diagnose the proxy, base config, hosts file, CA, and ports")
+		fmt.Fprintln(a.Stderr, "  up [dir]             Merge the hosts in the nearest .devhosts.json into the config")
+		fmt.Fprintln(a.Stderr, "  down [dir]           Remove the hosts the nearest .devhosts.json added")
+		fmt.Fprintln(a.Stderr, "  run <host> -- <cmd>  Run cmd on a free port with PORT set, routed as host until it exits")
+		fmt.Fprintln(a.Stderr, "  compose [file]       Route a host to each service a compose file publishes (--watch)")
+		fmt.Fprintln(a.Stderr, "  procfile [Procfile]  Route <app>-<process> to each Procfile entry; --run starts them too")
+		fmt.Fprintln(a.Stderr, "  import [--yes]       Adopt hand-written loopback names and reverse_proxy sites as hosts")
+		fmt.Fprintln(a.Stderr, "  history              List recent config changes with the hosts each one touched")
+		fmt.Fprintln(a.Stderr, "  undo [N]             Restore the config from before the last N changes (default 1)")
 		fmt.Fprintln(a.Stderr, "  apply                Regenerate files from devhosts.json and reload Caddy")
+		fmt.Fprintln(a.Stderr, "  recover [flags]      Finish (--forward) or undo (--back) an interrupted apply")
+		fmt.Fprintln(a.Stderr, "  backups [sub]        List, show, restore, or prune hosts file backups")
+		fmt.Fprintln(a.Stderr, "  plan                 Show diffs of what apply would change; exits 2 when changes are pending")
 		fmt.Fprintln(a.Stderr, "  path                 Print resolved configuration and Caddyfile paths")
+		fmt.Fprintln(a.Stderr, "  serve [flags]        Run the built-in reverse proxy instead of Caddy")
+		fmt.Fprintln(a.Stderr, "  dns [sub] [flags]    Resolve managed names and subdomains on loopback (serve|install|uninstall)")
 		fmt.Fprintln(a.Stderr)
 		fmt.Fprintln(a.Stderr, "Global flags:")
 		root.PrintDefaults()
 		fmt.Fprintln(a.Stderr)
 		fmt.Fprintln(a.Stderr, "Examples:")
 		fmt.Fprintln(a.Stderr, "  devhosts add staff:8080 admin=127.0.0.1:9090 --tls")
+		fmt.Fprintln(a.Stderr, "  devhosts add app:3000 app/api=5000 --strip-prefix")
+		fmt.Fprintln(a.Stderr, "  devhosts add api=8000,8001 --lb-policy round_robin")
 		fmt.Fprintln(a.Stderr, "  devhosts remove staff admin")
+		fmt.Fprintln(a.Stderr, "  devhosts disable --group billing")
+		fmt.Fprintln(a.Stderr, "  devhosts --output json list")
 	}
 
 	if err := root.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
-		return err
+		return withCode(CodeUsage, err)
+	}
+	switch output {
+	case OutputText, OutputJSON, OutputYAML:
+		a.Output = output
+	default:
+		return usageError("--output %q invalid: must be text, json, or yaml", output)
 	}
 
 	remaining := root.Args()
 	if len(remaining) == 0 {
 		root.Usage()
-		return fmt.Errorf("command required")
+		return usageError("command required")
 	}
+	a.command = remaining[0]
+	a.commandLine = remaining
 
-	loaded, err := a.Loader.Load(config.LoadOptions{
+	loadOpts := config.LoadOptions{
 		ConfigPath:               configPath,
 		BaseCaddyfileOverride:    baseOverride,
 		IncludeCaddyfileOverride: includeOverride,
-	})
+	}
+	cmd := remaining[0]
+	cmdArgs := remaining[1:]
+
+	// Commands that write hold the lock from Load through Save so concurrent runs cannot
+	// drop each other's hosts.
+	if !a.DryRun && writesState(cmd, cmdArgs) {
+		if err := a.acquireLock(loadOpts); err != nil {
+			return err
+		}
+		defer a.releaseLock()
+	}
+	loaded, err := a.Loader.Load(loadOpts)
 	if err != nil {
 		return err
 	}
 
-	cmd := remaining[0]
-	cmdArgs := remaining[1:]
+	if a.StateDir == "" {
+		a.StateDir = loaded.StateDir
+	}
+	if cmd != "recover" {
+		if err := a.checkJournal(writesState(cmd, cmdArgs) && !a.DryRun); err != nil {
+			return err
+		}
+	}
 
 	switch cmd {
 	case "list":
-		return a.handleList(loaded.Snapshot)
+		return a.handleList(loaded.Snapshot, cmdArgs)
 	case "add":
 		return a.handleAdd(ctx, loaded, cmdArgs)
 	case "remove":
 		return a.handleRemove(ctx, loaded, cmdArgs)
+	case "enable":
+		return a.handleSetEnabled(ctx, loaded, cmdArgs, true)
+	case "disable":
+		return a.handleSetEnabled(ctx, loaded, cmdArgs, false)
+	case "up":
+		return a.handleUp(ctx, loaded, cmdArgs)
+	case "down":
+		return a.handleDown(ctx, loaded, cmdArgs)
+	case "run":
+		return a.handleRun(ctx, loaded, loadOpts, cmdArgs)
+	case "recover":
+		return a.handleRecover(ctx, loadOpts, cmdArgs)
+	case "compose":
+		return a.handleCompose(ctx, loaded, loadOpts, cmdArgs)
+	case "procfile":
+		return a.handleProcfile(ctx, loaded, loadOpts, cmdArgs)
+	case "import":
+		return a.handleImport(ctx, loaded, cmdArgs)
+	case "history":
+		return a.handleHistory(cmdArgs)
+	case "undo":
+		return a.handleUndo(ctx, loaded, cmdArgs)
 	case "apply":
-		return a.handleApply(ctx, loaded.Snapshot)
+		return a.handleApply(ctx, loaded)
+	case "backups":
+		return a.handleBackups(cmdArgs)
+	case "plan":
+		return a.handlePlan(loaded.Snapshot)
+	case "status":
+		return a.handleStatus(ctx, loaded.Snapshot, cmdArgs)
+	case "doctor":
+		return a.handleDoctor(ctx, loaded.Snapshot, cmdArgs)
 	case "path":
-		a.printPaths(loaded)
-		return nil
+		return a.printPaths(loaded)
+	case "serve":
+		return a.handleServe(ctx, loaded, loadOpts, cmdArgs)
+	case "dns":
+		return a.handleDNS(ctx, loaded, loadOpts, cmdArgs)
 	case "help", "--help", "-h":
 		root.Usage()
 		return nil
 	default:
 		root.Usage()
-		return fmt.Errorf("unknown command %q", cmd)
+		return usageError("unknown command %q", cmd)
+	}
+}
+
+// writesState reports whether cmd changes the config or the files applied from it.
+func writesState(cmd string, args []string) bool {
+	switch cmd {
+	case "add", "remove", "enable", "disable", "up", "down", "run", "compose", "procfile", "import", "undo", "apply", "recover":
+		return true
+	case "backups":
+		return backupsWrite(args)
+	case "doctor":
+		return doctorFixes(args)
+	}
+	return false
+}
+
+func (a *App) acquireLock(opts config.LoadOptions) error {
+	lock, err := a.Loader.Lock(opts, a.LockTimeout)
+	if err != nil {
+		return err
+	}
+	if lock.StalePID != 0 {
+		fmt.Fprintf(a.Stderr, "Warning: pid %d exited without releasing the devhosts lock; taking it over.\n", lock.StalePID)
 	}
+	a.lock = lock
+	return nil
 }
 
-func (a *App) handleList(snapshot state.Snapshot) error {
+func (a *App) releaseLock() {
+	if err := a.lock.Release(); err != nil {
+		fmt.Fprintf(a.Stderr, "Warning: release lock: %v\n", err)
+	}
+	a.lock = nil
+}
+
+func (a *App) handleList(snapshot state.Snapshot, args []string) error {
+	listFlags := flag.NewFlagSet("list", flag.ContinueOnError)
+	listFlags.SetOutput(a.Stderr)
+	var group string
+	listFlags.StringVar(&group, "group", "", "only list hosts in this group")
+	if err := listFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if group != "" {
+		snapshot.Hosts = hostsInGroup(snapshot.Hosts, group)
+	}
+	if a.structured() {
+		hosts := make([]hostOutput, 0, len(snapshot.Hosts))
+		for _, h := range snapshot.Hosts {
+			out := hostOutput{Name: h.Name, Upstreams: h.Pool(), TLS: h.TLS, URL: snapshot.URL(h), Enabled: h.IsEnabled(), Group: h.Group, Project: h.Project, LBPolicy: h.LBPolicy}
+			for _, r := range h.Routes {
+				out.Routes = append(out.Routes, routeOutput{Path: r.Path, Upstream: r.Upstream, StripPrefix: r.StripPrefix})
+			}
+			hosts = append(hosts, out)
+		}
+		return a.emit(struct {
+			Hosts []hostOutput `json:"hosts"`
+		}{hosts})
+	}
 	if len(snapshot.Hosts) == 0 {
 		fmt.Fprintln(a.Stdout, "No hosts managed.")
 		return nil
 	}
 	tw := tabwriter.NewWriter(a.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(tw, "HOST\tUPSTREAM\tTLS")
+	fmt.Fprintln(tw, "HOST\tUPSTREAM\tTLS\tURL\tSTATE\tGROUP")
 	for _, h := range snapshot.Hosts {
 		tlsState := "disabled"
 		if h.TLS {
 			tlsState = "internal"
 		}
-		fmt.Fprintf(tw, "%s\t%s\t%s\n", h.Name, h.Upstream, tlsState)
+		hostState := "enabled"
+		if !h.IsEnabled() {
+			hostState = "disabled"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", h.Name, strings.Join(h.Pool(), ","), tlsState, snapshot.URL(h), hostState, h.Group)
 	}
 	return tw.Flush()
 }
@@ -148,30 +340,47 @@ func (a *App) handleAdd(ctx context.Context, loaded config.Loaded, args []string
 	addFlags.SetOutput(a.Stderr)
 	var enableTLS bool
 	var disableTLS bool
+	var stripPrefix bool
+	var lbPolicy, healthPath, healthInterval, group string
 	addFlags.BoolVar(&enableTLS, "tls", false, "enable tls internal for all provided hosts")
 	addFlags.BoolVar(&disableTLS, "no-tls", false, "disable tls internal for all provided hosts")
+	addFlags.BoolVar(&stripPrefix, "strip-prefix", false, "strip the route path before proxying route specs")
+	addFlags.StringVar(&lbPolicy, "lb-policy", "", "load-balancing policy for upstream pools")
+	addFlags.StringVar(&healthPath, "health-path", "", "path probed by active health checks")
+	addFlags.StringVar(&healthInterval, "health-interval", "", "interval between health checks, e.g. 10s")
+	addFlags.StringVar(&group, "group", "", "put the provided hosts in this group")
 	addFlags.Usage = func() {
 		fmt.Fprintf(a.Stderr, "Usage: devhosts add [flags] <host spec> [...]\n\n")
 		fmt.Fprintln(a.Stderr, "Host specs:")
 		fmt.Fprintln(a.Stderr, "  host:port            short form; upstream becomes http://localhost:port")
-		fmt.Fprintln(a.Stderr, "  host=UPSTREAM        explicit URL; adds http:// prefix if missing")
+		fmt.Fprintln(a.Stderr, "  host=UPSTREAM        explicit URL (http, https, [::1], or unix//path.sock); adds http:// if missing")
+		fmt.Fprintln(a.Stderr, "  host/path=UPSTREAM   route requests under /path to another upstream (port or URL)")
+		fmt.Fprintln(a.Stderr, "  host=8000,8001       comma-separate upstreams to balance across a pool")
 		fmt.Fprintln(a.Stderr)
 		fmt.Fprintln(a.Stderr, "Flags:")
 		fmt.Fprintln(a.Stderr, "      --tls               ensure tls internal stays enabled for provided hosts")
 		fmt.Fprintln(a.Stderr, "      --no-tls            disable tls internal for provided hosts")
+		fmt.Fprintln(a.Stderr, "      --strip-prefix      remove the route path before proxying route specs")
+		fmt.Fprintln(a.Stderr, "      --lb-policy NAME    balance pools with random, round_robin, least_conn, first, ip_hash, uri_hash, or cookie")
+		fmt.Fprintln(a.Stderr, "      --health-path PATH  actively health-check each upstream at PATH")
+		fmt.Fprintln(a.Stderr, "      --health-interval D time between health checks (e.g. 10s)")
+		fmt.Fprintln(a.Stderr, "      --group NAME        put the provided hosts in group NAME")
 	}
 	if err := addFlags.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
-		return err
+		return withCode(CodeUsage, err)
 	}
 	if enableTLS && disableTLS {
-		return fmt.Errorf("cannot use --tls and --no-tls together")
+		return usageError("cannot use --tls and --no-tls together")
+	}
+	if healthInterval != "" && healthPath == "" {
+		return usageError("--health-interval requires --health-path")
 	}
 	hostArgs := addFlags.Args()
 	if len(hostArgs) == 0 {
-		return fmt.Errorf("at least one host spec is required")
+		return usageError("at least one host spec is required")
 	}
 
 	desired := cloneSnapshot(loaded.Snapshot)
@@ -189,18 +398,45 @@ func (a *App) handleAdd(ctx context.Context, loaded config.Loaded, args []string
 		forcedTLS = &v
 	}
 
+	var configured []string
 	for _, spec := range hostArgs {
-		name, upstream, err := parseHostSpec(spec)
+		name, upstreams, err := parseHostSpec(spec)
 		if err != nil {
-			return err
+			return withCode(CodeUsage, err)
+		}
+		name, path := splitRoute(name)
+		name = desired.BareName(name)
+		idx, ok := existing[name]
+		if path != "" {
+			if !ok {
+				return fmt.Errorf("host %s is not managed; add it before its routes", name)
+			}
+			if len(upstreams) > 1 {
+				return fmt.Errorf("route %s%s takes a single upstream", name, path)
+			}
+			desired.Hosts[idx] = withRoute(desired.Hosts[idx], state.Route{Path: path, Upstream: upstreams[0], StripPrefix: stripPrefix})
+			configured = append(configured, name+path)
+			continue
+		}
+		configured = append(configured, name)
+		host := state.Host{Name: name, TLS: true}
+		if ok {
+			host = desired.Hosts[idx]
+		}
+		host.SetPool(upstreams)
+		if lbPolicy != "" {
+			host.LBPolicy = lbPolicy
+		}
+		if healthPath != "" {
+			host.HealthCheck = &state.HealthCheck{Path: healthPath, Interval: healthInterval}
 		}
-		host := state.Host{Name: name, Upstream: upstream, TLS: true}
 		if forcedTLS != nil {
 			host.TLS = *forcedTLS
-		} else if idx, ok := existing[name]; ok {
-			host.TLS = desired.Hosts[idx].TLS
 		}
-		if idx, ok := existing[name]; ok {
+		if group != "" {
+			host.Group = group
+		}
+		if ok {
 			desired.Hosts[idx] = host
 		} else {
 			desired.Hosts = append(desired.Hosts, host)
@@ -209,128 +445,382 @@ func (a *App) handleAdd(ctx context.Context, loaded config.Loaded, args []string
 	}
 
 	if err := state.ValidateSnapshot(desired); err != nil {
-		return err
+		return withCode(CodeInvalidConfig, err)
+	}
+	if a.DryRun {
+		return a.planOnly(desired)
 	}
 
-	outcome, err := a.applyState(ctx, desired)
+	outcome, err := a.saveState(ctx, loaded.Path, desired)
 	if err != nil {
 		return err
 	}
-	if err := a.Loader.Save(loaded.Path, desired); err != nil {
-		if rbErr := a.rollbackOutcome(outcome); rbErr != nil {
-			return fmt.Errorf("save config: %w (rollback failed: %v)", err, rbErr)
-		}
-		return fmt.Errorf("save config: %w", err)
+	if a.structured() {
+		return a.emit(outcome.changes(configured, fileChange{Path: loaded.Path, Changed: true}))
 	}
 	fmt.Fprintf(a.Stdout, "Configured %d host(s).\n", len(hostArgs))
 	return nil
 }
 
 func (a *App) handleRemove(ctx context.Context, loaded config.Loaded, args []string) error {
-	if len(args) == 0 {
-		fmt.Fprintln(a.Stderr, "Usage: devhosts remove <host> [<host> ...]")
-		return fmt.Errorf("at least one host name is required")
+	targets, err := a.selectTargets("remove", "<host|host/path> [...]", loaded.Snapshot, args)
+	if err != nil {
+		return err
 	}
 	desired := cloneSnapshot(loaded.Snapshot)
-	removed := 0
-	var missing []string
-	for _, raw := range args {
-		name := state.NormalizeHostName(raw)
+	var removedNames, missing []string
+	for _, raw := range targets {
+		name, path := splitRoute(normalizeSpecName(raw))
+		name = desired.BareName(name)
 		idx := findHostIndex(desired.Hosts, name)
 		if idx == -1 {
 			missing = append(missing, name)
 			continue
 		}
+		if path != "" {
+			host, ok := withoutRoute(desired.Hosts[idx], path)
+			if !ok {
+				missing = append(missing, name+path)
+				continue
+			}
+			desired.Hosts[idx] = host
+			removedNames = append(removedNames, name+path)
+			continue
+		}
 		desired.Hosts = append(desired.Hosts[:idx], desired.Hosts[idx+1:]...)
-		removed++
+		removedNames = append(removedNames, name)
 	}
 
 	if len(missing) > 0 {
 		for _, name := range missing {
-			fmt.Fprintf(a.Stderr, "Warning: host %s not managed.\n", name)
+			fmt.Fprintf(a.Stderr, "Warning: %s not managed.\n", name)
 		}
 	}
-	if removed == 0 {
+	if len(removedNames) == 0 {
+		if a.structured() {
+			return a.emit(changeOutput{Hosts: []string{}, Files: []fileChange{}})
+		}
 		return nil
 	}
 
 	if err := state.ValidateSnapshot(desired); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	if a.DryRun {
+		return a.planOnly(desired)
+	}
+
+	outcome, err := a.saveState(ctx, loaded.Path, desired)
+	if err != nil {
 		return err
 	}
+	if a.structured() {
+		return a.emit(outcome.changes(removedNames, fileChange{Path: loaded.Path, Changed: true}))
+	}
+	fmt.Fprintf(a.Stdout, "Removed %d host(s).\n", len(removedNames))
+	return nil
+}
 
-	outcome, err := a.applyState(ctx, desired)
+// handleSetEnabled enables or disables hosts in place, keeping their upstreams and settings.
+func (a *App) handleSetEnabled(ctx context.Context, loaded config.Loaded, args []string, enabled bool) error {
+	verb, done := "disable", "Disabled"
+	if enabled {
+		verb, done = "enable", "Enabled"
+	}
+	targets, err := a.selectTargets(verb, "<host> [...]", loaded.Snapshot, args)
 	if err != nil {
 		return err
 	}
-	if err := a.Loader.Save(loaded.Path, desired); err != nil {
-		if rbErr := a.rollbackOutcome(outcome); rbErr != nil {
-			return fmt.Errorf("save config: %w (rollback failed: %v)", err, rbErr)
+	desired := cloneSnapshot(loaded.Snapshot)
+	var changed []string
+	for _, raw := range targets {
+		name := desired.BareName(normalizeSpecName(raw))
+		idx := findHostIndex(desired.Hosts, name)
+		if idx == -1 {
+			fmt.Fprintf(a.Stderr, "Warning: %s not managed.\n", name)
+			continue
+		}
+		if desired.Hosts[idx].IsEnabled() == enabled {
+			continue
+		}
+		desired.Hosts[idx].SetEnabled(enabled)
+		changed = append(changed, name)
+	}
+	return a.commitState(ctx, loaded, desired, changed, done)
+}
+
+// commitState applies desired and saves it to the config, rolling the system files back if the
+// save fails. names lists what changed; with none it does nothing, and under --dry-run it only
+// prints the plan. On success it reports "<done> N host(s).".
+func (a *App) commitState(ctx context.Context, loaded config.Loaded, desired state.Snapshot, names []string, done string) error {
+	if len(names) == 0 {
+		if a.structured() {
+			return a.emit(changeOutput{Hosts: []string{}, Files: []fileChange{}})
 		}
-		return fmt.Errorf("save config: %w", err)
+		return nil
 	}
-	fmt.Fprintf(a.Stdout, "Removed %d host(s).\n", removed)
+	return a.applyState(ctx, loaded, desired, names, done)
+}
+
+// applyState is commitState for a change already known to be needed, even when no host
+// name captures it.
+func (a *App) applyState(ctx context.Context, loaded config.Loaded, desired state.Snapshot, names []string, done string) error {
+	if err := state.ValidateSnapshot(desired); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	if a.DryRun {
+		return a.planOnly(desired)
+	}
+
+	outcome, err := a.saveState(ctx, loaded.Path, desired)
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		return a.emit(outcome.changes(names, fileChange{Path: loaded.Path, Changed: true}))
+	}
+	fmt.Fprintf(a.Stdout, "%s %d host(s).\n", done, len(names))
 	return nil
 }
 
-func (a *App) handleApply(ctx context.Context, snapshot state.Snapshot) error {
+// saveState applies desired and writes it to the config at path, rolling the applied files
+// back when the config cannot be saved.
+func (a *App) saveState(ctx context.Context, path string, desired state.Snapshot) (applyOutcome, error) {
+	plan, err := a.planState(desired, false)
+	if err != nil {
+		return applyOutcome{}, err
+	}
+	return a.commitPlan(ctx, path, plan, true)
+}
+
+// commitPlan applies plan inside a journaled transaction and, when save is set, writes the
+// plan's snapshot to the config at path. The journal records every file's previous content
+// before anything changes and is removed once the transaction completes or is rolled back,
+// so a journal left behind means the process died part way and `devhosts recover` can finish
+// or undo it.
+func (a *App) commitPlan(ctx context.Context, path string, plan statePlan, save bool) (applyOutcome, error) {
+	var files []managedfile.Plan
+	for _, file := range []managedfile.Plan{plan.include, plan.hosts} {
+		if file.Changed {
+			files = append(files, file)
+		}
+	}
+	journalPath, previous, err := a.beginJournal(path, plan.desired, files, save)
+	if err != nil {
+		return applyOutcome{}, err
+	}
+	return a.finishPlan(ctx, journalPath, path, plan, previous, save, nil)
+}
+
+// prewrite is a change a transaction makes before its state can be planned, the way import
+// comments out the originals the backend would otherwise reject. files are the plans write
+// applies; undo puts them back and must be safe to call after write failed part way.
+type prewrite struct {
+	files []managedfile.Plan
+	write func() error
+	undo  func() error
+}
+
+// savePrewritten applies pre and then saves desired in a single journaled transaction, so
+// `devhosts recover --back` restores the files pre rewrote along with the rest.
+func (a *App) savePrewritten(ctx context.Context, path string, desired state.Snapshot, pre prewrite) (applyOutcome, error) {
+	// The include and hosts file are planned only after pre.write, so record them as they
+	// are now.
+	snapshot := desired.Qualified()
+	var files []managedfile.Plan
+	for _, file := range pre.files {
+		if file.Changed {
+			files = append(files, file)
+		}
+	}
+	for _, p := range []string{a.backendFor(snapshot).IncludePath(snapshot), a.HostsPath} {
+		if p == "" {
+			continue
+		}
+		current, err := managedfile.Prepare(a.Loader.FS, p, "")
+		if err != nil {
+			return applyOutcome{}, err
+		}
+		files = append(files, current)
+	}
+	journalPath, previous, err := a.beginJournal(path, desired, files, true)
+	if err != nil {
+		return applyOutcome{}, err
+	}
+	if err := pre.write(); err != nil {
+		return applyOutcome{}, a.abortJournal(journalPath, err, pre.undo)
+	}
+	plan, err := a.planState(desired, false)
+	if err != nil {
+		return applyOutcome{}, a.abortJournal(journalPath, err, pre.undo)
+	}
+	return a.finishPlan(ctx, journalPath, path, plan, previous, true, pre.undo)
+}
+
+// beginJournal records the previous content of files and, when save is set, of the config at
+// path, returning where the journal went and the config's plan for recordHistory. The first
+// plan for a path wins: it holds what the path contained before the transaction.
+func (a *App) beginJournal(path string, desired state.Snapshot, files []managedfile.Plan, save bool) (string, managedfile.Plan, error) {
+	journalPath := journal.Path(a.StateDir)
+	j := journal.Journal{PID: os.Getpid(), Started: time.Now().UTC(), ConfigPath: path, Desired: desired}
+	recorded := make(map[string]bool, len(files)+1)
+	record := func(file managedfile.Plan) {
+		if !recorded[file.Path] {
+			recorded[file.Path] = true
+			j.Files = append(j.Files, journal.FromPlan(file))
+		}
+	}
+	for _, file := range files {
+		record(file)
+	}
+	var previous managedfile.Plan
+	if save {
+		var err error
+		previous, err = managedfile.Prepare(a.Loader.FS, path, "")
+		if err != nil {
+			return "", managedfile.Plan{}, err
+		}
+		record(previous)
+	}
+	if err := journal.Begin(a.Loader.FS, journalPath, j); err != nil {
+		return "", managedfile.Plan{}, err
+	}
+	return journalPath, previous, nil
+}
+
+// finishPlan applies plan and saves its snapshot under the journal at journalPath, running
+// undo after its own rollback when the transaction fails.
+func (a *App) finishPlan(ctx context.Context, journalPath, path string, plan statePlan, previous managedfile.Plan, save bool, undo func() error) (applyOutcome, error) {
+	outcome, err := a.applyPlan(ctx, plan)
+	if err != nil {
+		// applyPlan restores what it wrote before returning.
+		return applyOutcome{}, a.abortJournal(journalPath, err, undo)
+	}
+	if save {
+		if err := a.Loader.Save(path, plan.desired); err != nil {
+			rollback := func() error {
+				if err := a.rollbackOutcome(outcome); err != nil {
+					return err
+				}
+				if undo != nil {
+					return undo()
+				}
+				return nil
+			}
+			return applyOutcome{}, a.abortJournal(journalPath, fmt.Errorf("save config: %w", err), rollback)
+		}
+		a.recordHistory(previous, plan.desired)
+	}
+	a.commitJournal(journalPath)
+	return outcome, nil
+}
+
+// abortJournal runs rollback after a failed transaction and removes the journal only once
+// everything is back, so a failed rollback leaves `devhosts recover --back` to finish it.
+func (a *App) abortJournal(journalPath string, err error, rollback func() error) error {
+	if rollback != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v; run `devhosts recover --back`)", err, rbErr)
+		}
+	}
+	a.commitJournal(journalPath)
+	return err
+}
+
+func (a *App) commitJournal(path string) {
+	if err := journal.Commit(a.Loader.FS, path); err != nil {
+		fmt.Fprintf(a.Stderr, "Warning: %v\n", err)
+	}
+}
+
+func (a *App) handleApply(ctx context.Context, loaded config.Loaded) error {
+	snapshot := loaded.Snapshot
 	if err := state.ValidateSnapshot(snapshot); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	plan, err := a.planState(snapshot, true)
+	if err != nil {
 		return err
 	}
-	if _, err := a.applyState(ctx, snapshot); err != nil {
+	if a.DryRun {
+		return a.reportPlan(plan)
+	}
+	outcome, err := a.commitPlan(ctx, loaded.Path, plan, false)
+	if err != nil {
 		return err
 	}
+	if a.structured() {
+		return a.emit(outcome.changes(nil))
+	}
 	fmt.Fprintln(a.Stdout, "State applied.")
 	return nil
 }
 
-func (a *App) printPaths(loaded config.Loaded) {
+func (a *App) printPaths(loaded config.Loaded) error {
+	if a.structured() {
+		return a.emit(pathsOutput{
+			Config:           loaded.Path,
+			BaseCaddyfile:    loaded.Snapshot.BaseCaddyfile,
+			IncludeCaddyfile: loaded.Snapshot.IncludeCaddyfile,
+			HostsFile:        a.HostsPath,
+		})
+	}
 	fmt.Fprintf(a.Stdout, "Config: %s\n", loaded.Path)
 	fmt.Fprintf(a.Stdout, "Base Caddyfile: %s\n", loaded.Snapshot.BaseCaddyfile)
 	fmt.Fprintf(a.Stdout, "Include Caddyfile: %s\n", loaded.Snapshot.IncludeCaddyfile)
+	return nil
 }
 
 type applyOutcome struct {
-	include caddy.UpdateResult
-	hosts   hostsfile.ApplyResult
+	backend   backend.Backend
+	include   managedfile.UpdateResult
+	hosts     hostsfile.ApplyResult
+	hostsPath string
+	reloaded  bool
 }
 
-func (a *App) applyState(ctx context.Context, snapshot state.Snapshot) (applyOutcome, error) {
-	if err := a.Caddy.EnsureBaseReady(snapshot.BaseCaddyfile, snapshot.IncludeCaddyfile, snapshot.Hosts); err != nil {
+func (a *App) backendFor(snapshot state.Snapshot) backend.Backend {
+	return backend.For(snapshot, backend.Options{
+		FS:       a.Caddy.FS,
+		Runner:   a.Caddy.Runner,
+		Caddy:    a.Caddy,
+		StateDir: a.StateDir,
+	})
+}
+
+func (a *App) applyPlan(ctx context.Context, plan statePlan) (applyOutcome, error) {
+	backend, snapshot := plan.backend, plan.snapshot
+	if err := backend.Provision(snapshot); err != nil {
 		return applyOutcome{}, err
 	}
 
-	includeRes, err := a.Caddy.UpdateInclude(snapshot.IncludeCaddyfile, a.Caddy.GenerateInclude(snapshot.Hosts))
+	includeRes, err := backend.WriteInclude(plan.include)
 	if err != nil {
 		return applyOutcome{}, err
 	}
 
-	hostsRes, err := a.Hosts.Apply(a.HostsPath, snapshot.Hosts)
+	hostsRes, err := a.Hosts.Write(plan.hosts)
 	if err != nil {
-		_ = a.Caddy.RestoreInclude(includeRes)
+		_ = backend.RestoreInclude(includeRes)
 		return applyOutcome{}, err
 	}
 
-	reloadOut, err := a.Caddy.Reload(ctx, snapshot.BaseCaddyfile)
-	if err != nil {
-		_ = a.Caddy.RestoreInclude(includeRes)
-		_ = a.Hosts.Restore(hostsRes)
-		details := strings.TrimSpace(string(reloadOut.Stderr))
-		if details == "" {
-			details = strings.TrimSpace(string(reloadOut.Stdout))
-		}
-		if details != "" {
-			return applyOutcome{}, fmt.Errorf("caddy reload failed: %w: %s", err, details)
+	if plan.reload {
+		reloadOut, err := backend.Reload(ctx, snapshot)
+		if err != nil {
+			_ = backend.RestoreInclude(includeRes)
+			_ = a.Hosts.Restore(hostsRes)
+			return applyOutcome{}, commandError(backend.Name()+" reload", reloadOut, err)
 		}
-		return applyOutcome{}, fmt.Errorf("caddy reload failed: %w", err)
 	}
 
-	return applyOutcome{include: includeRes, hosts: hostsRes}, nil
+	return applyOutcome{backend: backend, include: includeRes, hosts: hostsRes, hostsPath: plan.hosts.Path, reloaded: plan.reload && plan.include.Path != ""}, nil
 }
 
 func (a *App) rollbackOutcome(outcome applyOutcome) error {
 	var errs []string
-	if outcome.include.Changed {
-		if err := a.Caddy.RestoreInclude(outcome.include); err != nil {
+	if outcome.include.Changed && outcome.backend != nil {
+		if err := outcome.backend.RestoreInclude(outcome.include); err != nil {
 			errs = append(errs, err.Error())
 		}
 	}
@@ -345,36 +835,135 @@ func (a *App) rollbackOutcome(outcome applyOutcome) error {
 	return nil
 }
 
-func parseHostSpec(spec string) (string, string, error) {
+func parseHostSpec(spec string) (string, []string, error) {
 	spec = strings.TrimSpace(spec)
 	if spec == "" {
-		return "", "", fmt.Errorf("empty host spec")
+		return "", nil, fmt.Errorf("empty host spec")
 	}
 	if strings.Contains(spec, "=") {
 		parts := strings.SplitN(spec, "=", 2)
-		name := state.NormalizeHostName(parts[0])
-		upstream := strings.TrimSpace(parts[1])
+		name := normalizeSpecName(parts[0])
 		if name == "" {
-			return "", "", fmt.Errorf("host name missing before '='")
-		}
-		if upstream == "" {
-			return "", "", fmt.Errorf("upstream missing after '='")
+			return "", nil, fmt.Errorf("host name missing before '='")
 		}
-		if !strings.Contains(upstream, "://") {
-			upstream = "http://" + upstream
+		var upstreams []string
+		for _, upstream := range strings.Split(parts[1], ",") {
+			upstream = strings.TrimSpace(upstream)
+			if upstream == "" {
+				return "", nil, fmt.Errorf("upstream missing after '='")
+			}
+			if _, err := strconv.Atoi(upstream); err == nil {
+				upstream = "localhost:" + upstream
+			}
+			if !strings.Contains(upstream, "://") && !strings.HasPrefix(upstream, "unix/") {
+				upstream = "http://" + upstream
+			}
+			upstreams = append(upstreams, upstream)
 		}
-		return name, upstream, nil
+		return name, upstreams, nil
 	}
 	idx := strings.LastIndex(spec, ":")
 	if idx <= 0 || idx == len(spec)-1 {
-		return "", "", fmt.Errorf("invalid host spec %q; use host:port or host=upstream", spec)
+		return "", nil, fmt.Errorf("invalid host spec %q; use host:port or host=upstream", spec)
 	}
-	name := state.NormalizeHostName(spec[:idx])
-	port := spec[idx+1:]
+	name := normalizeSpecName(spec[:idx])
 	if name == "" {
-		return "", "", fmt.Errorf("host name missing in %q", spec)
+		return "", nil, fmt.Errorf("host name missing in %q", spec)
+	}
+	var upstreams []string
+	for _, port := range strings.Split(spec[idx+1:], ",") {
+		upstreams = append(upstreams, fmt.Sprintf("http://localhost:%s", strings.TrimSpace(port)))
+	}
+	return name, upstreams, nil
+}
+
+// normalizeSpecName lowercases the host part of "host" or "host/path", leaving the path as typed.
+func normalizeSpecName(raw string) string {
+	name, path := splitRoute(strings.TrimSpace(raw))
+	return state.NormalizeHostName(name) + path
+}
+
+// splitRoute separates "host/path" into the host name and its route path.
+func splitRoute(name string) (string, string) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// withRoute returns a copy of h with route added, replacing any route on the same path.
+func withRoute(h state.Host, route state.Route) state.Host {
+	routes := make([]state.Route, 0, len(h.Routes)+1)
+	replaced := false
+	for _, r := range h.Routes {
+		if r.Path == route.Path {
+			r, replaced = route, true
+		}
+		routes = append(routes, r)
+	}
+	if !replaced {
+		routes = append(routes, route)
+	}
+	h.Routes = routes
+	return h
+}
+
+// withoutRoute returns a copy of h without the route on path and whether it existed.
+func withoutRoute(h state.Host, path string) (state.Host, bool) {
+	var routes []state.Route
+	found := false
+	for _, r := range h.Routes {
+		if r.Path == path {
+			found = true
+			continue
+		}
+		routes = append(routes, r)
+	}
+	h.Routes = routes
+	return h, found
+}
+
+// selectTargets parses the host arguments of a bulk command, expanding --group into the names
+// of every host in that group.
+func (a *App) selectTargets(cmd, usage string, snapshot state.Snapshot, args []string) ([]string, error) {
+	selectFlags := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	selectFlags.SetOutput(a.Stderr)
+	var group string
+	selectFlags.StringVar(&group, "group", "", "act on every host in this group")
+	selectFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts %s [--group NAME] %s\n", cmd, usage)
+	}
+	if err := selectFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil
+		}
+		return nil, withCode(CodeUsage, err)
+	}
+	targets := selectFlags.Args()
+	if group != "" {
+		members := hostsInGroup(snapshot.Hosts, group)
+		if len(members) == 0 {
+			return nil, fmt.Errorf("no hosts in group %q", group)
+		}
+		for _, h := range members {
+			targets = append(targets, h.Name)
+		}
+	}
+	if len(targets) == 0 {
+		selectFlags.Usage()
+		return nil, usageError("at least one host name or --group is required")
+	}
+	return targets, nil
+}
+
+func hostsInGroup(hosts []state.Host, group string) []state.Host {
+	var out []state.Host
+	for _, h := range hosts {
+		if h.Group == group {
+			out = append(out, h)
+		}
 	}
-	return name, fmt.Sprintf("http://localhost:%s", port), nil
+	return out
 }
 
 func findHostIndex(hosts []state.Host, name string) int {
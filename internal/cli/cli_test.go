@@ -1,24 +1,47 @@
 package cli
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/history"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/journal"
+	"github.com/cdfuller/devhosts/internal/lockfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
 
 func TestParseHostSpecPort(t *testing.T) {
-	name, upstream, err := parseHostSpec("User:8080")
+	name, upstreams, err := parseHostSpec("User:8080")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if name != "user" || upstream != "http://localhost:8080" {
-		t.Fatalf("unexpected result: %s %s", name, upstream)
+	if name != "user" || len(upstreams) != 1 || upstreams[0] != "http://localhost:8080" {
+		t.Fatalf("unexpected result: %s %v", name, upstreams)
 	}
 }
 
 func TestParseHostSpecExplicitURL(t *testing.T) {
-	name, upstream, err := parseHostSpec("api=http://127.0.0.1:9000")
+	name, upstreams, err := parseHostSpec("api=http://127.0.0.1:9000")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if name != "api" || upstream != "http://127.0.0.1:9000" {
-		t.Fatalf("unexpected result: %s %s", name, upstream)
+	if name != "api" || len(upstreams) != 1 || upstreams[0] != "http://127.0.0.1:9000" {
+		t.Fatalf("unexpected result: %s %v", name, upstreams)
 	}
 }
 
@@ -27,3 +50,673 @@ func TestParseHostSpecInvalid(t *testing.T) {
 		t.Fatalf("expected error for malformed spec")
 	}
 }
+
+func TestParseHostSpecRoute(t *testing.T) {
+	name, upstreams, err := parseHostSpec("App/api=5000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "app/api" || upstreams[0] != "http://localhost:5000" {
+		t.Fatalf("unexpected result: %s %v", name, upstreams)
+	}
+	host, path := splitRoute(name)
+	if host != "app" || path != "/api" {
+		t.Fatalf("unexpected split: %s %s", host, path)
+	}
+}
+
+func TestParseHostSpecUnixSocket(t *testing.T) {
+	_, upstreams, err := parseHostSpec("rails=unix//tmp/rails.sock")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if upstreams[0] != "unix//tmp/rails.sock" {
+		t.Fatalf("unexpected upstream: %v", upstreams)
+	}
+}
+
+func TestParseHostSpecPool(t *testing.T) {
+	for _, spec := range []string{"api=8000,8001", "api:8000,8001"} {
+		_, upstreams, err := parseHostSpec(spec)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", spec, err)
+		}
+		if strings.Join(upstreams, " ") != "http://localhost:8000 http://localhost:8001" {
+			t.Fatalf("%s: unexpected upstreams: %v", spec, upstreams)
+		}
+	}
+	if _, _, err := parseHostSpec("api=8000,"); err == nil {
+		t.Fatalf("expected error for empty pool member")
+	}
+}
+
+// newTestApp wires an App to a config, Caddyfile, and hosts file inside a temp dir.
+func newTestApp(t *testing.T) (*App, string, *bytes.Buffer) {
+	t.Helper()
+	dir := t.TempDir()
+	include := filepath.Join(dir, "devhosts.caddy")
+	base := filepath.Join(dir, "Caddyfile")
+	if err := os.WriteFile(base, []byte("import "+include+"\n"), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	configPath := filepath.Join(dir, "devhosts.json")
+	cfg := fmt.Sprintf(`{"version":1,"hosts":[],"base_caddyfile":%q,"include_caddyfile":%q}`, base, include)
+	if err := os.WriteFile(configPath, []byte(cfg), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	stdout := &bytes.Buffer{}
+	app := &App{
+		Loader:    config.NewLoader(filesystem.OS{}),
+		Hosts:     hostsfile.NewManager(filesystem.OS{}),
+		Caddy:     caddy.NewManager(filesystem.OS{}, nil),
+		Stdout:    stdout,
+		Stderr:    io.Discard,
+		HostsPath: filepath.Join(dir, "hosts"),
+	}
+	return app, configPath, stdout
+}
+
+// seedConfig replaces the config newTestApp wrote at path with snapshot, keeping its version
+// and Caddyfile paths.
+func seedConfig(t *testing.T, path string, snapshot state.Snapshot) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var written state.Snapshot
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	snapshot.Version = written.Version
+	snapshot.BaseCaddyfile = written.BaseCaddyfile
+	snapshot.IncludeCaddyfile = written.IncludeCaddyfile
+	if snapshot.Hosts == nil {
+		snapshot.Hosts = []state.Host{}
+	}
+	data, err = json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+}
+
+func TestDryRunPrintsPlanWithoutWriting(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	err := app.Run(context.Background(), []string{"--config", configPath, "--dry-run", "add", "user:8000"})
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("expected ErrChangesPending, got %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{"+user {", "+127.0.0.1    user", "Would reload caddy."} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("plan missing %q:\n%s", want, out)
+		}
+	}
+	if _, err := os.Stat(app.HostsPath); !os.IsNotExist(err) {
+		t.Fatalf("expected hosts file to be untouched, got %v", err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil || strings.Contains(string(data), "user") {
+		t.Fatalf("expected config to be untouched: %s (%v)", data, err)
+	}
+}
+
+func TestOutputJSONList(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Hosts: []state.Host{{Name: "user", Upstream: "http://localhost:8000", TLS: true}}})
+	if err := app.Run(context.Background(), []string{"--config", configPath, "-o", "json", "list"}); err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+	var doc struct {
+		Version int    `json:"version"`
+		Command string `json:"command"`
+		Result  struct {
+			Hosts []hostOutput `json:"hosts"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatalf("decode list output: %v\n%s", err, stdout)
+	}
+	if doc.Version != outputVersion || doc.Command != "list" {
+		t.Fatalf("unexpected envelope: %s", stdout)
+	}
+	hosts := doc.Result.Hosts
+	if len(hosts) != 1 || hosts[0].Name != "user" || hosts[0].URL != "https://user/" || !hosts[0].TLS || hosts[0].Upstreams[0] != "http://localhost:8000" {
+		t.Fatalf("unexpected hosts: %+v", hosts)
+	}
+}
+
+func TestOutputJSONError(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	err := app.Run(context.Background(), []string{"--config", configPath, "--output", "json", "add"})
+	if !Reported(err) {
+		t.Fatalf("expected a reported error, got %v", err)
+	}
+	var doc struct {
+		Command string       `json:"command"`
+		Error   *errorObject `json:"error"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatalf("decode error output: %v\n%s", err, stdout)
+	}
+	if doc.Command != "add" || doc.Error == nil || doc.Error.Code != CodeUsage {
+		t.Fatalf("unexpected error document: %s", stdout)
+	}
+}
+
+func TestOutputInvalidFormat(t *testing.T) {
+	app, configPath, _ := newTestApp(t)
+	err := app.Run(context.Background(), []string{"--config", configPath, "--output", "xml", "list"})
+	if err == nil || !strings.Contains(err.Error(), "--output") {
+		t.Fatalf("expected --output error, got %v", err)
+	}
+}
+
+func TestDisableKeepsHostInConfig(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Hosts: []state.Host{
+		{Name: "admin", Upstream: "http://localhost:9000"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	}})
+	err := app.Run(context.Background(), []string{"--config", configPath, "--dry-run", "disable", "admin"})
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("expected ErrChangesPending, got %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "+user {") || strings.Contains(out, "admin") {
+		t.Fatalf("plan should only route user:\n%s", out)
+	}
+
+	stdout.Reset()
+	if err := app.Run(context.Background(), []string{"--config", configPath, "list"}); err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "enabled") {
+		t.Fatalf("list should show the host state:\n%s", out)
+	}
+}
+
+func TestRemoveGroup(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Hosts: []state.Host{
+		{Name: "api", Upstream: "http://localhost:8001", Group: "legacy"},
+		{Name: "old", Upstream: "http://localhost:8002", Group: "legacy"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	}})
+	err := app.Run(context.Background(), []string{"--config", configPath, "--dry-run", "remove", "--group", "legacy"})
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("expected ErrChangesPending, got %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "+user {") || strings.Contains(out, "old") || strings.Contains(out, "api") {
+		t.Fatalf("plan should only keep user:\n%s", out)
+	}
+
+	stdout.Reset()
+	if err := app.Run(context.Background(), []string{"--config", configPath, "list", "--group", "legacy"}); err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "old") || strings.Contains(out, "user") {
+		t.Fatalf("list should only show the legacy group:\n%s", out)
+	}
+
+	err = app.Run(context.Background(), []string{"--config", configPath, "disable", "--group", "missing"})
+	if err == nil || !strings.Contains(err.Error(), "no hosts in group") {
+		t.Fatalf("expected empty group error, got %v", err)
+	}
+}
+
+func TestUpMergesProjectManifest(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	project := t.TempDir()
+	manifest := `{"hosts":[{"name":"billing","upstream":"http://localhost:7000"}]}`
+	if err := os.WriteFile(filepath.Join(project, config.ProjectFile), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	app.WorkDir = project
+	err := app.Run(context.Background(), []string{"--config", configPath, "--dry-run", "up"})
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("expected ErrChangesPending, got %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "+billing {") {
+		t.Fatalf("plan should add the project host:\n%s", out)
+	}
+}
+
+func TestUpReplacesProjectHosts(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin, DomainSuffix: "test"})
+	app.WorkDir = t.TempDir()
+	writeManifest := func(manifest string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(app.WorkDir, config.ProjectFile), []byte(manifest), 0o644); err != nil {
+			t.Fatalf("write manifest: %v", err)
+		}
+	}
+	ctx := context.Background()
+	writeManifest(`{"hosts":[{"name":"api.test","upstream":"http://localhost:7000"}]}`)
+	if err := app.Run(ctx, []string{"--config", configPath, "up"}); err != nil {
+		t.Fatalf("up returned error: %v", err)
+	}
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if hosts := loaded.Snapshot.Hosts; len(hosts) != 1 || hosts[0].Name != "api" {
+		t.Fatalf("expected the suffix stripped from the manifest name, got %+v", hosts)
+	}
+
+	stdout.Reset()
+	if err := app.Run(ctx, []string{"--config", configPath, "up"}); err != nil {
+		t.Fatalf("up returned error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "already match") {
+		t.Fatalf("expected an unchanged manifest to be a no-op, got %q", out)
+	}
+
+	writeManifest(`{"hosts":[]}`)
+	if err := app.Run(ctx, []string{"--config", configPath, "up"}); err != nil {
+		t.Fatalf("up returned error: %v", err)
+	}
+	loaded, err = app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(loaded.Snapshot.Hosts) != 0 {
+		t.Fatalf("expected an emptied manifest to remove its hosts, got %+v", loaded.Snapshot.Hosts)
+	}
+}
+
+func TestRunRegistersHostWhileChildRuns(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	// The builtin backend only touches the hosts file, so the run needs no proxy.
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin, Hosts: []state.Host{{Name: "old", Upstream: "http://localhost:8000", Ephemeral: true}}})
+	script := `test -n "$PORT" && grep -q api "$HOSTS" && ! grep -q old "$HOSTS" && echo child-ran`
+	t.Setenv("HOSTS", app.HostsPath)
+	if err := app.Run(context.Background(), []string{"--config", configPath, "run", "api", "--", "sh", "-c", script}); err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "child-ran") {
+		t.Fatalf("child did not see its host registered:\n%s", stdout)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil || strings.Contains(string(data), `"api"`) || strings.Contains(string(data), `"old"`) {
+		t.Fatalf("expected ephemeral hosts to be gone: %s (%v)", data, err)
+	}
+	if _, err := os.Stat(app.runMarker("api")); !os.IsNotExist(err) {
+		t.Fatalf("expected run marker to be removed, got %v", err)
+	}
+}
+
+func TestDoctorFixTakesTheLock(t *testing.T) {
+	for args, want := range map[string]bool{
+		"--fix":                 true,
+		"-fix":                  true,
+		"--fix=true":            true,
+		"-fix=1":                true,
+		"--http :80 --fix=TRUE": true,
+		"--fix=false":           false,
+		"--fix --fix=false":     false,
+		"--json":                false,
+		"-- --fix":              false,
+	} {
+		if got := writesState("doctor", strings.Fields(args)); got != want {
+			t.Errorf("writesState(doctor %s) = %v, want %v", args, got, want)
+		}
+	}
+}
+
+func TestWriteCommandsWaitForLock(t *testing.T) {
+	app, configPath, _ := newTestApp(t)
+	held, err := app.Loader.Lock(config.LoadOptions{ConfigPath: configPath}, 0)
+	if err != nil {
+		t.Fatalf("Lock returned error: %v", err)
+	}
+	defer held.Release()
+
+	app.LockTimeout = time.Millisecond
+	err = app.Run(context.Background(), []string{"--config", configPath, "add", "user:8000"})
+	var heldErr *lockfile.HeldError
+	if !errors.As(err, &heldErr) || !strings.Contains(err.Error(), "another devhosts is running") {
+		t.Fatalf("expected lock error, got %v", err)
+	}
+	if err := app.Run(context.Background(), []string{"--config", configPath, "list"}); err != nil {
+		t.Fatalf("read-only commands should not need the lock: %v", err)
+	}
+}
+
+func TestRecoverBackAfterInterruptedApply(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin})
+	original := "127.0.0.1 localhost\n"
+	if err := os.WriteFile(app.HostsPath, []byte(original+"127.0.0.1    user\n"), 0o644); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+	// A process that died after writing the hosts file but before saving the config.
+	j := journal.Journal{
+		PID:        99999999,
+		ConfigPath: configPath,
+		Files:      []journal.File{{Path: app.HostsPath, Existed: true, Previous: []byte(original)}},
+	}
+	stateDir := config.StateDir(configPath)
+	if err := journal.Begin(filesystem.OS{}, journal.Path(stateDir), j); err != nil {
+		t.Fatalf("Begin returned error: %v", err)
+	}
+
+	err := app.Run(context.Background(), []string{"--config", configPath, "add", "api:9000"})
+	if err == nil || errorCode(err) != CodeRecoveryNeeded {
+		t.Fatalf("expected recovery to be required, got %v", err)
+	}
+	if err := app.Run(context.Background(), []string{"--config", configPath, "recover", "--back"}); err != nil {
+		t.Fatalf("recover returned error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "Rolled back") {
+		t.Fatalf("unexpected output:\n%s", stdout)
+	}
+	if data, _ := os.ReadFile(app.HostsPath); string(data) != original {
+		t.Fatalf("hosts file not restored: %q", data)
+	}
+	if _, err := os.Stat(journal.Path(stateDir)); !os.IsNotExist(err) {
+		t.Fatalf("expected journal to be removed, got %v", err)
+	}
+}
+
+func TestBackupsListAndPrune(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	for _, id := range []string{"20240101-000000", "20240102-000000", "20240102-000000-2"} {
+		if err := os.WriteFile(app.HostsPath+".devhosts.bak-"+id, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+	}
+
+	if err := app.Run(context.Background(), []string{"--config", configPath, "--output", "json", "backups", "list"}); err != nil {
+		t.Fatalf("backups list returned error: %v", err)
+	}
+	var doc struct {
+		Result struct {
+			Backups []backupOutput `json:"backups"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatalf("parse output: %v\n%s", err, stdout)
+	}
+	if got := doc.Result.Backups; len(got) != 3 || got[0].ID != "20240102-000000-2" {
+		t.Fatalf("unexpected backups: %+v", got)
+	}
+
+	app.Output = ""
+	if err := app.Run(context.Background(), []string{"--config", configPath, "backups", "prune", "--keep", "1"}); err != nil {
+		t.Fatalf("backups prune returned error: %v", err)
+	}
+	backups, err := app.Hosts.Backups(app.HostsPath)
+	if err != nil {
+		t.Fatalf("Backups returned error: %v", err)
+	}
+	if len(backups) != 1 || backups[0].ID != "20240102-000000-2" {
+		t.Fatalf("expected only the newest backup to survive, got %+v", backups)
+	}
+}
+
+func TestUndoRestoresRemovedHosts(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin})
+	ctx := context.Background()
+	for _, args := range [][]string{{"add", "user:8000", "admin:9000"}, {"remove", "user", "admin"}} {
+		if err := app.Run(ctx, append([]string{"--config", configPath}, args...)); err != nil {
+			t.Fatalf("%s returned error: %v", args[0], err)
+		}
+	}
+
+	stdout.Reset()
+	if err := app.Run(ctx, []string{"--config", configPath, "history"}); err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	out := stdout.String()
+	if !strings.HasPrefix(out, "1  ") || !strings.Contains(out, "devhosts remove user admin") || !strings.Contains(out, "- admin") || !strings.Contains(out, "+ user") {
+		t.Fatalf("unexpected history:\n%s", out)
+	}
+
+	if err := app.Run(ctx, []string{"--config", configPath, "undo"}); err != nil {
+		t.Fatalf("undo returned error: %v", err)
+	}
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(loaded.Snapshot.Hosts) != 2 {
+		t.Fatalf("expected undo to restore both hosts, got %+v", loaded.Snapshot.Hosts)
+	}
+	if err := app.Run(ctx, []string{"--config", configPath, "undo", "4"}); errorCode(err) != CodeUsage {
+		t.Fatalf("expected undo past the history to be a usage error, got %v", err)
+	}
+}
+
+func TestComposeSyncsPublishedServices(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin})
+	app.WorkDir = t.TempDir()
+	composePath := filepath.Join(app.WorkDir, "compose.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(composePath, []byte(content), 0o644); err != nil {
+			t.Fatalf("write compose file: %v", err)
+		}
+	}
+	write("services:\n  web:\n    ports: ['8080:80']\n  api:\n    ports: ['4000:3000']\n    x-devhosts: {name: backend}\n")
+	ctx := context.Background()
+	if err := app.Run(ctx, []string{"--config", configPath, "compose"}); err != nil {
+		t.Fatalf("compose returned error: %v", err)
+	}
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(loaded.Snapshot.Hosts) != 2 {
+		t.Fatalf("expected two hosts, got %+v", loaded.Snapshot.Hosts)
+	}
+	for _, h := range loaded.Snapshot.Hosts {
+		if h.Project != composePath || !h.TLS {
+			t.Fatalf("expected TLS host tagged with %s, got %+v", composePath, h)
+		}
+	}
+
+	write("services:\n  web:\n    ports: ['8081:80']\n")
+	stdout.Reset()
+	if err := app.Run(ctx, []string{"--config", configPath, "compose", composePath}); err != nil {
+		t.Fatalf("compose returned error: %v", err)
+	}
+	if out := stdout.String(); !strings.Contains(out, "Synced 2 host(s).") {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	loaded, err = app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if hosts := loaded.Snapshot.Hosts; len(hosts) != 1 || hosts[0].Name != "web" || hosts[0].Upstream != "http://localhost:8081" {
+		t.Fatalf("expected only web on the new port, got %+v", hosts)
+	}
+
+	if err := app.Run(ctx, []string{"--config", configPath, "--dry-run", "compose", "--watch"}); errorCode(err) != CodeUsage {
+		t.Fatalf("expected --watch with --dry-run to be a usage error, got %v", err)
+	}
+}
+
+func TestProcfileRegistersEachProcess(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin})
+	app.WorkDir = t.TempDir()
+	// api outlives web; stopping it must reach the sleep its shell started, not just the shell.
+	procfile := "web: test \"$PORT\" = 5000 && grep -q shop-api \"$HOSTS\" && echo web-ran\napi: sleep 30; echo api-done\n"
+	if err := os.WriteFile(filepath.Join(app.WorkDir, "Procfile"), []byte(procfile), 0o644); err != nil {
+		t.Fatalf("write Procfile: %v", err)
+	}
+	t.Setenv("HOSTS", app.HostsPath)
+	ctx := context.Background()
+
+	if err := app.Run(ctx, []string{"--config", configPath, "procfile", "--app", "shop"}); err != nil {
+		t.Fatalf("procfile returned error: %v", err)
+	}
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	hosts := loaded.Snapshot.Hosts
+	if len(hosts) != 2 || hosts[0].Name != "shop-api" || hosts[0].Upstream != "http://localhost:5100" || hosts[1].Name != "shop-web" || hosts[1].Upstream != "http://localhost:5000" {
+		t.Fatalf("unexpected hosts: %+v", hosts)
+	}
+
+	err = app.Run(ctx, []string{"--config", configPath, "procfile", "--app", "shop", "--run"})
+	if err == nil || !strings.Contains(err.Error(), "devhosts remove shop-api shop-web") {
+		t.Fatalf("expected --run to refuse to replace the synced hosts, got %v", err)
+	}
+	if err := app.Run(ctx, []string{"--config", configPath, "remove", "shop-api", "shop-web"}); err != nil {
+		t.Fatalf("remove returned error: %v", err)
+	}
+
+	stdout.Reset()
+	start := time.Now()
+	if err := app.Run(ctx, []string{"--config", configPath, "procfile", "--app", "shop", "--run"}); err != nil {
+		t.Fatalf("procfile --run returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= runStopTimeout {
+		t.Fatalf("api took %s to stop; its sleep was not interrupted", elapsed)
+	}
+	if out := stdout.String(); !strings.Contains(out, "web | web-ran") || strings.Contains(out, "api-done") {
+		t.Fatalf("expected prefixed output from web only:\n%s", out)
+	}
+	loaded, err = app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if len(loaded.Snapshot.Hosts) != 0 {
+		t.Fatalf("expected the hosts to be torn down, got %+v", loaded.Snapshot.Hosts)
+	}
+}
+
+func TestUndoRestoresSnapshotSettings(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	seedConfig(t, configPath, state.Snapshot{Backend: state.BackendBuiltin})
+	ctx := context.Background()
+	if err := app.Run(ctx, []string{"--config", configPath, "add", "user:8000"}); err != nil {
+		t.Fatalf("add returned error: %v", err)
+	}
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	before := cloneSnapshot(loaded.Snapshot)
+	before.DomainSuffix = "test"
+	entry := history.Entry{Time: time.Now().Add(time.Minute), Command: []string{"edit"}, Before: before, After: loaded.Snapshot}
+	if err := history.Record(app.Loader.FS, history.Dir(loaded.StateDir), entry); err != nil {
+		t.Fatalf("record history: %v", err)
+	}
+
+	stdout.Reset()
+	if err := app.Run(ctx, []string{"--config", configPath, "undo"}); err != nil {
+		t.Fatalf("undo returned error: %v", err)
+	}
+	if out := stdout.String(); strings.Contains(out, "already match") {
+		t.Fatalf("undo treated a settings change as a no-op: %s", out)
+	}
+	loaded, err = app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if loaded.Snapshot.DomainSuffix != "test" {
+		t.Fatalf("expected undo to restore domain_suffix, got %q", loaded.Snapshot.DomainSuffix)
+	}
+}
+
+func TestImportProposesHandWrittenHosts(t *testing.T) {
+	app, configPath, stdout := newTestApp(t)
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	base := loaded.Snapshot.BaseCaddyfile
+	caddyfile := "import " + loaded.Snapshot.IncludeCaddyfile + "\n\nshop {\n\treverse_proxy :3000\n}\n\nhttp://blog {\n\treverse_proxy localhost:4000\n\tencode gzip\n}\n"
+	if err := os.WriteFile(base, []byte(caddyfile), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	hosts := "127.0.0.1 localhost\n127.0.0.1 shop blog\n127.0.0.1 wiki\n"
+	if err := os.WriteFile(app.HostsPath, []byte(hosts), 0o644); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+
+	err = app.Run(context.Background(), []string{"--config", configPath, "--dry-run", "import"})
+	if !errors.Is(err, ErrChangesPending) {
+		t.Fatalf("expected ErrChangesPending, got %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"shop  http://localhost:3000  internal",
+		"Skipped wiki",
+		"-127.0.0.1 shop blog\n+127.0.0.1    blog\n",
+		"+# shop {\n+# \treverse_proxy :3000\n+# }\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "blog  ") || strings.Contains(out, "localhost  ") {
+		t.Fatalf("only simple sites should be proposed:\n%s", out)
+	}
+	if data, _ := os.ReadFile(base); string(data) != caddyfile {
+		t.Fatalf("dry run changed the base Caddyfile:\n%s", data)
+	}
+}
+
+func TestImportJournalsTheOriginals(t *testing.T) {
+	app, configPath, _ := newTestApp(t)
+	stateDir := config.StateDir(configPath)
+	var journaled *journal.Journal
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The reload runs after import rewrote the originals; fail it so everything rolls back.
+		j, err := journal.Load(filesystem.OS{}, journal.Path(stateDir))
+		if err != nil {
+			t.Errorf("load journal: %v", err)
+		}
+		journaled = j
+		http.Error(w, "caddy is down", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	seedConfig(t, configPath, state.Snapshot{AdminAddress: strings.TrimPrefix(srv.URL, "http://")})
+	loaded, err := app.Loader.Load(config.LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	base := loaded.Snapshot.BaseCaddyfile
+	caddyfile := "import " + loaded.Snapshot.IncludeCaddyfile + "\n\nshop {\n\treverse_proxy :3000\n}\n"
+	if err := os.WriteFile(base, []byte(caddyfile), 0o644); err != nil {
+		t.Fatalf("write base: %v", err)
+	}
+	hosts := "127.0.0.1 localhost\n127.0.0.1 shop\n"
+	if err := os.WriteFile(app.HostsPath, []byte(hosts), 0o644); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+
+	if err := app.Run(context.Background(), []string{"--config", configPath, "import", "--yes"}); err == nil {
+		t.Fatalf("expected import to fail when the reload does")
+	}
+	if journaled == nil {
+		t.Fatalf("expected a journal while the reload ran")
+	}
+	previous := make(map[string]string)
+	for _, f := range journaled.Files {
+		previous[f.Path] = string(f.Previous)
+	}
+	if previous[app.HostsPath] != hosts || previous[base] != caddyfile {
+		t.Fatalf("journal does not hold the originals: %+v", previous)
+	}
+	if data, _ := os.ReadFile(app.HostsPath); string(data) != hosts {
+		t.Fatalf("hosts file not restored: %q", data)
+	}
+	if data, _ := os.ReadFile(base); string(data) != caddyfile {
+		t.Fatalf("base Caddyfile not restored: %q", data)
+	}
+	if _, err := os.Stat(journal.Path(stateDir)); !os.IsNotExist(err) {
+		t.Fatalf("expected journal to be removed, got %v", err)
+	}
+}
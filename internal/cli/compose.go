@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cdfuller/devhosts/internal/compose"
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/history"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func (a *App) handleCompose(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, args []string) error {
+	composeFlags := flag.NewFlagSet("compose", flag.ContinueOnError)
+	composeFlags.SetOutput(a.Stderr)
+	var noTLS, watch bool
+	composeFlags.BoolVar(&noTLS, "no-tls", false, "route the hosts over plain HTTP unless a service asks for TLS")
+	composeFlags.BoolVar(&watch, "watch", false, "keep running and re-sync whenever the compose file changes")
+	composeFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts compose [flags] [file]\n\n")
+		fmt.Fprintln(a.Stderr, "Routes a host to every service the compose file publishes on a TCP port, named after")
+		fmt.Fprintln(a.Stderr, "the service. Set x-devhosts: {name, port, tls, enable} on a service, or the matching")
+		fmt.Fprintln(a.Stderr, "devhosts.* labels, to override. Hosts from services no longer published are removed.")
+		fmt.Fprintln(a.Stderr, "Without a file, compose.yaml or docker-compose.yml in the current directory is used.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		composeFlags.PrintDefaults()
+	}
+	if err := composeFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if composeFlags.NArg() > 1 {
+		return usageError("usage: devhosts compose [flags] [file]")
+	}
+	if watch && (a.DryRun || a.structured()) {
+		return usageError("--watch cannot be combined with --dry-run or --output")
+	}
+	path, err := a.findComposeFile(composeFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err := a.syncCompose(ctx, loaded, path, noTLS); err != nil || !watch {
+		return err
+	}
+
+	// Other commands may change the config while watching; each sync takes the lock afresh.
+	a.releaseLock()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(a.Stdout, "Watching %s for changes.\n", path)
+	filesystem.Watch(ctx, a.Loader.FS, path, serveWatchInterval, func() {
+		if err := a.resyncCompose(ctx, opts, path, noTLS); err != nil {
+			fmt.Fprintf(a.Stderr, "Warning: keeping previous hosts: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// findComposeFile resolves the file argument, or the first of compose.FileNames found in
+// WorkDir or the current directory.
+func (a *App) findComposeFile(arg string) (string, error) {
+	if arg != "" {
+		path, err := filesystem.ExpandUser(arg)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(path)
+	}
+	dir, err := a.workDir()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range compose.FileNames {
+		path := filepath.Join(dir, name)
+		if _, err := a.Loader.FS.Stat(path); err == nil {
+			return filepath.Abs(path)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no compose file found in %s", dir)
+}
+
+// syncCompose routes the services the compose file at path publishes, replacing the hosts an
+// earlier sync of the same file added. The file's path tags the hosts the way a project's
+// directory tags those from .devhosts.json.
+func (a *App) syncCompose(ctx context.Context, loaded config.Loaded, path string, noTLS bool) error {
+	data, err := a.Loader.FS.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	services, err := compose.Parse(data)
+	if err != nil {
+		return withCode(CodeInvalidConfig, fmt.Errorf("%s: %w", path, err))
+	}
+	project := config.Project{Dir: path, Path: path, Hosts: []state.Host{}}
+	for _, svc := range services {
+		name, upstreams, err := parseHostSpec(fmt.Sprintf("%s:%d", svc.Name, svc.Port))
+		if err != nil {
+			return withCode(CodeInvalidConfig, fmt.Errorf("%s: service %s: %w", path, svc.Service, err))
+		}
+		host := state.Host{Name: loaded.Snapshot.BareName(name), TLS: !noTLS, Project: path}
+		host.SetPool(upstreams)
+		if svc.TLS != nil {
+			host.TLS = *svc.TLS
+		}
+		project.Hosts = append(project.Hosts, host)
+	}
+	if len(services) == 0 {
+		fmt.Fprintf(a.Stderr, "Warning: %s publishes no TCP ports.\n", path)
+	}
+	return a.syncProject(ctx, loaded, project, "Synced")
+}
+
+// syncProject merges the hosts a file declares in place of those it declared before and
+// applies whatever changed, reporting the changed hosts after done.
+func (a *App) syncProject(ctx context.Context, loaded config.Loaded, project config.Project, done string) error {
+	desired, err := config.MergeProject(cloneSnapshot(loaded.Snapshot), project)
+	if err != nil {
+		return withCode(CodeInvalidConfig, fmt.Errorf("%s: %w", project.Path, err))
+	}
+	names := history.Diff(loaded.Snapshot, desired).Names()
+	if len(names) == 0 && !a.structured() {
+		fmt.Fprintf(a.Stdout, "Hosts already match %s.\n", project.Path)
+	}
+	return a.commitState(ctx, loaded, desired, names, done)
+}
+
+// workDir is WorkDir, or the current directory when unset.
+func (a *App) workDir() (string, error) {
+	if a.WorkDir != "" {
+		return a.WorkDir, nil
+	}
+	return os.Getwd()
+}
+
+// resyncCompose syncs again under the lock against the config as it is now.
+func (a *App) resyncCompose(ctx context.Context, opts config.LoadOptions, path string, noTLS bool) error {
+	if err := a.acquireLock(opts); err != nil {
+		return err
+	}
+	defer a.releaseLock()
+	loaded, err := a.Loader.Load(opts)
+	if err != nil {
+		return err
+	}
+	return a.syncCompose(ctx, loaded, path, noTLS)
+}
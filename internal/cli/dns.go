@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/dns"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+)
+
+func (a *App) handleDNS(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, args []string) error {
+	sub := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+
+	listen, tld := dns.DefaultListen, dns.DefaultTLD
+	if loaded.Snapshot.DomainSuffix != "" {
+		tld = loaded.Snapshot.DomainSuffix
+	}
+	if settings := loaded.Snapshot.DNS; settings != nil {
+		if settings.Listen != "" {
+			listen = settings.Listen
+		}
+		if settings.TLD != "" {
+			tld = settings.TLD
+		}
+	}
+
+	dnsFlags := flag.NewFlagSet("dns", flag.ContinueOnError)
+	dnsFlags.SetOutput(a.Stderr)
+	var dropInDir string
+	dnsFlags.StringVar(&listen, "listen", listen, "UDP address the responder listens on")
+	dnsFlags.StringVar(&tld, "tld", tld, "pseudo-TLD routed to the responder")
+	dnsFlags.StringVar(&dropInDir, "resolved-dir", dns.DefaultResolvedDropInDir, "systemd-resolved drop-in directory")
+	dnsFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts dns [serve|install|uninstall] [flags]\n\n")
+		fmt.Fprintln(a.Stderr, "  serve       Answer A/AAAA for managed names and their subdomains (default)")
+		fmt.Fprintln(a.Stderr, "  install     Route the pseudo-TLD to the responder via systemd-resolved")
+		fmt.Fprintln(a.Stderr, "  uninstall   Remove the systemd-resolved drop-in")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		dnsFlags.PrintDefaults()
+	}
+	if err := dnsFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	installer := dns.NewResolvedInstaller(a.Caddy.FS, a.Caddy.Runner)
+	installer.DropInDir = dropInDir
+
+	switch sub {
+	case "serve":
+		return a.serveDNS(ctx, loaded, opts, listen, tld)
+	case "install":
+		out, err := installer.Install(ctx, listen, tld)
+		if err != nil {
+			return commandError("restart systemd-resolved", out, err)
+		}
+		fmt.Fprintf(a.Stdout, "Wrote %s; *.%s now resolves through %s.\n", installer.DropInPath(), tld, listen)
+		return nil
+	case "uninstall":
+		out, err := installer.Uninstall(ctx)
+		if err != nil {
+			return commandError("restart systemd-resolved", out, err)
+		}
+		fmt.Fprintf(a.Stdout, "Removed %s.\n", installer.DropInPath())
+		return nil
+	default:
+		dnsFlags.Usage()
+		return fmt.Errorf("unknown dns command %q", sub)
+	}
+}
+
+func (a *App) serveDNS(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, listen, tld string) error {
+	srv := dns.NewServer(listen, tld)
+	srv.Update(loaded.Snapshot.Hosts)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go filesystem.Watch(ctx, a.Loader.FS, loaded.Path, serveWatchInterval, func() {
+		reloaded, err := a.Loader.Load(opts)
+		if err != nil {
+			fmt.Fprintf(a.Stderr, "Warning: keeping previous hosts: %v\n", err)
+			return
+		}
+		srv.Update(reloaded.Snapshot.Hosts)
+		fmt.Fprintf(a.Stdout, "Reloaded %d host(s) from %s.\n", len(reloaded.Snapshot.Hosts), reloaded.Path)
+	})
+
+	fmt.Fprintf(a.Stdout, "Answering for %d host(s) and *.%s on %s.\n", len(loaded.Snapshot.Hosts), srv.TLD, srv.Addr)
+	return srv.ListenAndServe(ctx)
+}
+
+// commandError folds captured command output into err the same way applyPlan reports reloads.
+func commandError(action string, out cmdutil.Result, err error) error {
+	details := strings.TrimSpace(string(out.Stderr))
+	if details == "" {
+		details = strings.TrimSpace(string(out.Stdout))
+	}
+	if details != "" {
+		return fmt.Errorf("%s failed: %w: %s", action, err, details)
+	}
+	return fmt.Errorf("%s failed: %w", action, err)
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cdfuller/devhosts/internal/doctor"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// doctorFixes reports whether args turn on doctor's --fix in any form the flag package takes:
+// -fix or --fix, alone or with =value. The last one wins, and a value that is not a boolean
+// counts as on, since doctor rejects it only after the lock is taken.
+func doctorFixes(args []string) bool {
+	fix := false
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name != "-fix" && name != "--fix" {
+			continue
+		}
+		on, err := strconv.ParseBool(value)
+		fix = !hasValue || err != nil || on
+	}
+	return fix
+}
+
+func (a *App) handleDoctor(ctx context.Context, snapshot state.Snapshot, args []string) error {
+	doctorFlags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	doctorFlags.SetOutput(a.Stderr)
+	var fix, asJSON bool
+	var httpAddr, httpsAddr string
+	doctorFlags.BoolVar(&fix, "fix", false, "repair the problems that are safe to fix automatically")
+	doctorFlags.BoolVar(&asJSON, "json", false, "shorthand for the global --output json")
+	doctorFlags.StringVar(&httpAddr, "http", "127.0.0.1:80", "address the proxy should serve plain HTTP on")
+	doctorFlags.StringVar(&httpsAddr, "https", "127.0.0.1:443", "address the proxy should serve HTTPS on")
+	doctorFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts doctor [flags]\n\n")
+		fmt.Fprintln(a.Stderr, "Checks the proxy, base config, hosts file, local CA, and ports devhosts relies on.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		doctorFlags.PrintDefaults()
+	}
+	if err := doctorFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+
+	qualified := snapshot.Qualified()
+	d := doctor.Doctor{
+		FS:          a.Caddy.FS,
+		Runner:      a.Caddy.Runner,
+		Caddy:       a.Caddy,
+		Hosts:       a.Hosts,
+		HostsPath:   a.HostsPath,
+		IncludePath: a.backendFor(qualified).IncludePath(qualified),
+		HTTPAddr:    httpAddr,
+		HTTPSAddr:   httpsAddr,
+	}
+	d.CARoot, d.CARootErr = a.localCARoot(qualified)
+
+	findings := d.Run(ctx, qualified)
+	if fix {
+		findings = doctor.Fix(findings)
+	}
+	if asJSON {
+		a.Output = OutputJSON
+	}
+	if a.structured() {
+		if err := a.emit(struct {
+			Findings []doctor.Finding `json:"findings"`
+		}{findings}); err != nil {
+			return err
+		}
+	} else {
+		a.printFindings(findings, fix)
+	}
+
+	failing := 0
+	for _, f := range findings {
+		if f.Failed() {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return withCode(CodeChecksFailed, fmt.Errorf("%d check(s) failed", failing))
+	}
+	return nil
+}
+
+func (a *App) printFindings(findings []doctor.Finding, fixed bool) {
+	fixable := 0
+	for _, f := range findings {
+		label := f.Status
+		if f.Failed() {
+			label = "FAIL"
+		}
+		fmt.Fprintf(a.Stdout, "%-6s %-15s %s\n", label, f.Name, f.Detail)
+		if f.Hint != "" {
+			fmt.Fprintf(a.Stdout, "%-6s %-15s hint: %s\n", "", "", f.Hint)
+		}
+		if f.Fixable && f.Status != doctor.StatusFixed {
+			fixable++
+		}
+	}
+	if fixable > 0 && !fixed {
+		fmt.Fprintf(a.Stdout, "\nRun `devhosts doctor --fix` to repair %d of these automatically.\n", fixable)
+	}
+}
@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/history"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// historyOutput is one config change as history reports it; N counts back from the newest.
+type historyOutput struct {
+	N       int             `json:"n"`
+	Time    time.Time       `json:"time"`
+	Command []string        `json:"command"`
+	Changes history.Changes `json:"changes"`
+}
+
+// recordHistory keeps the config a save just replaced so undo can bring it back. previous is
+// the config file as it was read before the save. Failing to record only warns, since the
+// change itself has already been made.
+func (a *App) recordHistory(previous managedfile.Plan, saved state.Snapshot) {
+	before := saved
+	before.Hosts = []state.Host{}
+	if previous.Existed {
+		var parsed state.Snapshot
+		if err := json.Unmarshal(previous.Previous, &parsed); err != nil {
+			fmt.Fprintf(a.Stderr, "Warning: not recorded in history: parse previous config: %v\n", err)
+			return
+		}
+		// Configs written before the paths were required pick them up on load, as here.
+		if parsed.BaseCaddyfile == "" {
+			parsed.BaseCaddyfile = saved.BaseCaddyfile
+		}
+		if parsed.IncludeCaddyfile == "" {
+			parsed.IncludeCaddyfile = saved.IncludeCaddyfile
+		}
+		if parsed.Hosts == nil {
+			parsed.Hosts = []state.Host{}
+		}
+		before = parsed
+	}
+	entry := history.Entry{Command: a.commandLine, Before: before, After: saved}
+	if err := history.Record(a.Loader.FS, history.Dir(a.StateDir), entry); err != nil {
+		fmt.Fprintf(a.Stderr, "Warning: not recorded in history: %v\n", err)
+	}
+}
+
+func (a *App) handleHistory(args []string) error {
+	historyFlags := flag.NewFlagSet("history", flag.ContinueOnError)
+	historyFlags.SetOutput(a.Stderr)
+	historyFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts history\n\n")
+		fmt.Fprintf(a.Stderr, "Lists the last %d config changes, newest first, with the hosts each added (+),\n", history.Limit)
+		fmt.Fprintln(a.Stderr, "removed (-), or changed (~). `devhosts undo N` restores the config from before change N.")
+	}
+	if err := historyFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if historyFlags.NArg() > 0 {
+		return usageError("history takes no arguments")
+	}
+
+	entries, err := history.List(a.Loader.FS, history.Dir(a.StateDir))
+	if err != nil {
+		return err
+	}
+	if a.structured() {
+		out := make([]historyOutput, 0, len(entries))
+		for i, e := range entries {
+			out = append(out, historyOutput{N: i + 1, Time: e.Time, Command: e.Command, Changes: e.Changes()})
+		}
+		return a.emit(struct {
+			Entries []historyOutput `json:"entries"`
+		}{out})
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(a.Stdout, "No config changes recorded.")
+		return nil
+	}
+	for i, e := range entries {
+		fmt.Fprintf(a.Stdout, "%d  %s  devhosts %s\n", i+1, e.Time.Local().Format(time.DateTime), strings.Join(e.Command, " "))
+		changes := e.Changes()
+		for _, name := range changes.Added {
+			fmt.Fprintf(a.Stdout, "     + %s\n", name)
+		}
+		for _, name := range changes.Removed {
+			fmt.Fprintf(a.Stdout, "     - %s\n", name)
+		}
+		for _, name := range changes.Changed {
+			fmt.Fprintf(a.Stdout, "     ~ %s\n", name)
+		}
+	}
+	return nil
+}
+
+func (a *App) handleUndo(ctx context.Context, loaded config.Loaded, args []string) error {
+	undoFlags := flag.NewFlagSet("undo", flag.ContinueOnError)
+	undoFlags.SetOutput(a.Stderr)
+	undoFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts undo [N]\n\n")
+		fmt.Fprintln(a.Stderr, "Restores the config from before the last N changes listed by `devhosts history`")
+		fmt.Fprintln(a.Stderr, "(default 1) and applies it. The undo is itself recorded, so it can be undone too.")
+	}
+	if err := undoFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	n := 1
+	switch undoFlags.NArg() {
+	case 0:
+	case 1:
+		parsed, err := strconv.Atoi(undoFlags.Arg(0))
+		if err != nil || parsed < 1 {
+			return usageError("undo %q invalid: must be a positive number", undoFlags.Arg(0))
+		}
+		n = parsed
+	default:
+		return usageError("undo takes at most one argument")
+	}
+
+	entries, err := history.List(a.Loader.FS, history.Dir(a.StateDir))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New("no config changes recorded to undo")
+	}
+	if n > len(entries) {
+		return usageError("only %d change(s) recorded", len(entries))
+	}
+	target := entries[n-1].Before
+	if sameSnapshot(loaded.Snapshot, target) {
+		if !a.structured() {
+			fmt.Fprintln(a.Stdout, "Config already matches that snapshot.")
+		}
+		return a.commitState(ctx, loaded, target, nil, "")
+	}
+	names := history.Diff(loaded.Snapshot, target).Names()
+	if len(names) == 0 {
+		// Only settings such as domain_suffix or backend differ, and they affect every host.
+		for _, h := range target.Hosts {
+			names = append(names, h.Name)
+		}
+	}
+	return a.applyState(ctx, loaded, target, names, "Undid changes to")
+}
+
+// sameSnapshot compares snapshots as they are saved, so a nil and an empty list are equal.
+func sameSnapshot(a, b state.Snapshot) bool {
+	left, errLeft := json.Marshal(a)
+	right, errRight := json.Marshal(b)
+	return errLeft == nil && errRight == nil && bytes.Equal(left, right)
+}
@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// systemNames are loopback names operating systems ship in the hosts file.
+var systemNames = map[string]bool{
+	"localhost":     true,
+	"ip6-localhost": true,
+	"ip6-loopback":  true,
+	"broadcasthost": true,
+}
+
+// importOutput reports the hosts import found and whether it adopted them.
+type importOutput struct {
+	Hosts    []importedHost `json:"hosts"`
+	Skipped  []skippedName  `json:"skipped"`
+	Imported bool           `json:"imported"`
+	Backups  []string       `json:"backups"`
+}
+
+type importedHost struct {
+	Name     string   `json:"name"`
+	Upstream string   `json:"upstream"`
+	TLS      bool     `json:"tls"`
+	Sources  []string `json:"sources"`
+}
+
+type skippedName struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// importScan is what import found: the hosts to adopt and the hand-written entries behind them.
+type importScan struct {
+	out          importOutput
+	sites        []caddy.Site
+	hostsEntries []string
+}
+
+func (a *App) handleImport(ctx context.Context, loaded config.Loaded, args []string) error {
+	importFlags := flag.NewFlagSet("import", flag.ContinueOnError)
+	importFlags.SetOutput(a.Stderr)
+	var yes bool
+	importFlags.BoolVar(&yes, "yes", false, "import without asking for confirmation")
+	importFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts import [--yes]\n\n")
+		fmt.Fprintln(a.Stderr, "Finds bare names the hosts file maps to loopback outside the managed block and sites in")
+		fmt.Fprintln(a.Stderr, "the base Caddyfile that only reverse_proxy to a local port, and proposes them as hosts.")
+		fmt.Fprintln(a.Stderr, "Once confirmed, the hosts are added to devhosts.json and the originals are commented")
+		fmt.Fprintln(a.Stderr, "out, after backing up both files.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		importFlags.PrintDefaults()
+	}
+	if err := importFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if importFlags.NArg() > 0 {
+		return usageError("import takes no arguments")
+	}
+
+	snapshot := loaded.Snapshot
+	scan, err := a.scanImports(snapshot)
+	if err != nil {
+		return err
+	}
+	if len(scan.out.Hosts) == 0 {
+		if a.structured() {
+			return a.emit(scan.out)
+		}
+		a.printSkipped(scan.out.Skipped)
+		fmt.Fprintln(a.Stdout, "Nothing to import.")
+		return nil
+	}
+
+	hostsPlan, basePlan, err := a.planImport(snapshot, scan)
+	if err != nil {
+		return err
+	}
+	if !a.structured() {
+		a.printImports(scan.out)
+	}
+	if a.DryRun || (a.structured() && !yes) {
+		if a.structured() {
+			if err := a.emit(scan.out); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprint(a.Stdout, fileDiff(hostsPlan)+fileDiff(basePlan))
+		}
+		return ErrChangesPending
+	}
+	if !yes && !a.confirm(fmt.Sprintf("Import %d host(s) and comment out the originals?", len(scan.out.Hosts))) {
+		fmt.Fprintln(a.Stdout, "Nothing imported.")
+		return nil
+	}
+
+	desired := snapshot
+	desired.Hosts = append([]state.Host{}, snapshot.Hosts...)
+	names := make([]string, 0, len(scan.out.Hosts))
+	for _, h := range scan.out.Hosts {
+		desired.Hosts = append(desired.Hosts, state.Host{Name: h.Name, Upstream: h.Upstream, TLS: h.TLS})
+		names = append(names, h.Name)
+	}
+	if err := state.ValidateSnapshot(desired); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+
+	// The originals go first: the base Caddyfile must stop defining the names before the
+	// backend will accept them, and the hosts file is planned from what is left.
+	var hostsRes hostsfile.ApplyResult
+	var baseRes managedfile.UpdateResult
+	var baseBackup string
+	pre := prewrite{
+		files: []managedfile.Plan{hostsPlan, basePlan},
+		write: func() error {
+			var err error
+			if hostsRes, err = a.Hosts.Write(hostsPlan); err != nil {
+				return err
+			}
+			baseRes, baseBackup, err = a.Caddy.WriteBase(basePlan)
+			return err
+		},
+		undo: func() error { return a.restoreImport(hostsRes, baseRes) },
+	}
+	if _, err := a.savePrewritten(ctx, loaded.Path, desired, pre); err != nil {
+		return err
+	}
+	for _, backup := range []string{hostsRes.BackupPath, baseBackup} {
+		if backup != "" {
+			scan.out.Backups = append(scan.out.Backups, backup)
+		}
+	}
+	scan.out.Imported = true
+	if a.structured() {
+		return a.emit(scan.out)
+	}
+	for _, backup := range scan.out.Backups {
+		fmt.Fprintf(a.Stdout, "Backed up %s.\n", backup)
+	}
+	fmt.Fprintf(a.Stdout, "Imported %d host(s).\n", len(names))
+	return nil
+}
+
+// scanImports collects the hand-written hosts snapshot does not already manage.
+func (a *App) scanImports(snapshot state.Snapshot) (importScan, error) {
+	scan := importScan{out: importOutput{Hosts: []importedHost{}, Skipped: []skippedName{}, Backups: []string{}}}
+	managed := make(map[string]bool, len(snapshot.Hosts))
+	for _, h := range snapshot.Hosts {
+		managed[h.Name] = true
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		short, _, _ := strings.Cut(strings.ToLower(hostname), ".")
+		managed[short] = true
+	}
+	candidate := func(raw string) (string, bool) {
+		name := snapshot.BareName(strings.ToLower(raw))
+		return name, state.ValidName(name) && !systemNames[name] && !managed[name]
+	}
+
+	index := make(map[string]int)
+	if snapshot.Backend == "" || snapshot.Backend == state.BackendCaddy {
+		sites, err := a.Caddy.SimpleSites(snapshot.BaseCaddyfile)
+		if err != nil {
+			return importScan{}, err
+		}
+		for _, site := range sites {
+			name, ok := candidate(site.Name)
+			if _, seen := index[name]; !ok || seen {
+				continue
+			}
+			if site.Shared {
+				source := fmt.Sprintf("%s:%d", snapshot.BaseCaddyfile, site.Line)
+				scan.out.Skipped = append(scan.out.Skipped, skippedName{Name: name, Source: source, Reason: "its site block shares a line with another block"})
+				index[name] = -1
+				continue
+			}
+			index[name] = len(scan.out.Hosts)
+			scan.out.Hosts = append(scan.out.Hosts, importedHost{
+				Name:     name,
+				Upstream: site.Upstream,
+				TLS:      site.TLS,
+				Sources:  []string{fmt.Sprintf("%s:%d", snapshot.BaseCaddyfile, site.Line)},
+			})
+			scan.sites = append(scan.sites, site)
+		}
+	}
+
+	entries, err := a.Hosts.LoopbackNames(a.HostsPath)
+	if err != nil {
+		return importScan{}, err
+	}
+	skipped := make(map[string]bool)
+	for _, entry := range entries {
+		name, ok := candidate(entry.Name)
+		if !ok {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", a.HostsPath, entry.Line)
+		i, found := index[name]
+		if found && i < 0 {
+			continue
+		}
+		if found {
+			scan.out.Hosts[i].Sources = append(scan.out.Hosts[i].Sources, source)
+			scan.hostsEntries = append(scan.hostsEntries, entry.Name)
+			continue
+		}
+		if !skipped[name] {
+			skipped[name] = true
+			scan.out.Skipped = append(scan.out.Skipped, skippedName{Name: name, Source: source, Reason: "no reverse_proxy site gives it an upstream"})
+		}
+	}
+	return scan, nil
+}
+
+// planImport computes the hosts file and base Caddyfile with the imported originals removed.
+func (a *App) planImport(snapshot state.Snapshot, scan importScan) (managedfile.Plan, managedfile.Plan, error) {
+	var hostsPlan, basePlan managedfile.Plan
+	var err error
+	if len(scan.hostsEntries) > 0 {
+		if hostsPlan, err = a.Hosts.PlanRelease(a.HostsPath, scan.hostsEntries); err != nil {
+			return hostsPlan, basePlan, err
+		}
+	}
+	if len(scan.sites) > 0 {
+		if basePlan, err = a.Caddy.PlanCommentSites(snapshot.BaseCaddyfile, scan.sites); err != nil {
+			return hostsPlan, basePlan, err
+		}
+	}
+	return hostsPlan, basePlan, nil
+}
+
+// restoreImport puts back the originals import commented out when adopting them failed.
+func (a *App) restoreImport(hostsRes hostsfile.ApplyResult, baseRes managedfile.UpdateResult) error {
+	var errs []error
+	if baseRes.Changed {
+		if err := a.Caddy.RestoreBase(baseRes); err != nil {
+			errs = append(errs, fmt.Errorf("restore base caddyfile: %w", err))
+		}
+	}
+	if hostsRes.Changed {
+		if err := a.Hosts.Restore(hostsRes); err != nil {
+			errs = append(errs, fmt.Errorf("restore hosts file: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *App) printImports(out importOutput) {
+	tw := tabwriter.NewWriter(a.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tUPSTREAM\tTLS\tFROM")
+	for _, h := range out.Hosts {
+		tlsState := "disabled"
+		if h.TLS {
+			tlsState = "internal"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", h.Name, h.Upstream, tlsState, strings.Join(h.Sources, ", "))
+	}
+	_ = tw.Flush()
+	a.printSkipped(out.Skipped)
+}
+
+func (a *App) printSkipped(skipped []skippedName) {
+	for _, s := range skipped {
+		fmt.Fprintf(a.Stdout, "Skipped %s (%s): %s; add it with `devhosts add %s:PORT`.\n", s.Name, s.Source, s.Reason, s.Name)
+	}
+}
+
+// confirm asks a yes/no question on stdin; anything but y or yes, including no input, is no.
+func (a *App) confirm(question string) bool {
+	fmt.Fprintf(a.Stdout, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(a.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/lockfile"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/system"
+	"github.com/cdfuller/devhosts/internal/yaml"
+)
+
+// Output formats accepted by --output.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// outputVersion is bumped whenever a structured document changes incompatibly.
+const outputVersion = 1
+
+// Error codes reported in structured output.
+const (
+	CodeUsage            = "usage"
+	CodeInvalidConfig    = "invalid_config"
+	CodeNeedsSudo        = "needs_sudo"
+	CodeProxyUnreachable = "proxy_unreachable"
+	CodeChangesPending   = "changes_pending"
+	CodeChecksFailed     = "checks_failed"
+	CodeLocked           = "locked"
+	CodeRecoveryNeeded   = "recovery_needed"
+	CodeError            = "error"
+)
+
+// document is the envelope every structured result and error is written in.
+type document struct {
+	Version int          `json:"version"`
+	Command string       `json:"command"`
+	Result  any          `json:"result,omitempty"`
+	Error   *errorObject `json:"error,omitempty"`
+}
+
+type errorObject struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// codedError attaches an output error code to err.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+
+func (e *codedError) Unwrap() error { return e.err }
+
+func withCode(code string, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func usageError(format string, args ...any) error {
+	return withCode(CodeUsage, fmt.Errorf(format, args...))
+}
+
+// errorCode classifies err for structured output.
+func errorCode(err error) string {
+	var coded *codedError
+	var held *lockfile.HeldError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.As(err, &held):
+		return CodeLocked
+	case system.IsErrNeedsSudo(err):
+		return CodeNeedsSudo
+	case errors.Is(err, caddy.ErrAdminUnreachable):
+		return CodeProxyUnreachable
+	case errors.Is(err, ErrChangesPending):
+		return CodeChangesPending
+	}
+	return CodeError
+}
+
+// reportedError marks an error that Run already wrote to stdout as structured output.
+type reportedError struct{ err error }
+
+func (e *reportedError) Error() string { return e.err.Error() }
+
+func (e *reportedError) Unwrap() error { return e.err }
+
+// Reported reports whether err was already written to stdout, so main only needs to exit.
+func Reported(err error) bool {
+	var reported *reportedError
+	return errors.As(err, &reported)
+}
+
+func (a *App) structured() bool {
+	return a.Output == OutputJSON || a.Output == OutputYAML
+}
+
+// emit writes result for the running command in the selected structured format.
+func (a *App) emit(result any) error {
+	a.emitted = true
+	return a.writeDocument(document{Version: outputVersion, Command: a.command, Result: result})
+}
+
+// reportError writes err as a structured error unless the command already emitted its
+// result, such as plan with pending changes or status with failing checks.
+func (a *App) reportError(err error) error {
+	if !a.emitted {
+		doc := document{Version: outputVersion, Command: a.command, Error: &errorObject{Code: errorCode(err), Message: err.Error()}}
+		if writeErr := a.writeDocument(doc); writeErr != nil {
+			return err
+		}
+	}
+	return &reportedError{err: err}
+}
+
+func (a *App) writeDocument(doc document) error {
+	if a.Output == OutputYAML {
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = a.Stdout.Write(data)
+		return err
+	}
+	enc := json.NewEncoder(a.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// hostOutput is a host as list reports it.
+type hostOutput struct {
+	Name      string        `json:"name"`
+	Upstreams []string      `json:"upstreams"`
+	TLS       bool          `json:"tls"`
+	URL       string        `json:"url"`
+	Enabled   bool          `json:"enabled"`
+	Group     string        `json:"group,omitempty"`
+	Project   string        `json:"project,omitempty"`
+	LBPolicy  string        `json:"lb_policy,omitempty"`
+	Routes    []routeOutput `json:"routes,omitempty"`
+}
+
+type routeOutput struct {
+	Path        string `json:"path"`
+	Upstream    string `json:"upstream"`
+	StripPrefix bool   `json:"strip_prefix"`
+}
+
+type pathsOutput struct {
+	Config           string `json:"config"`
+	BaseCaddyfile    string `json:"base_caddyfile"`
+	IncludeCaddyfile string `json:"include_caddyfile"`
+	HostsFile        string `json:"hosts_file"`
+}
+
+// changeOutput reports what add, remove, and apply changed.
+type changeOutput struct {
+	Hosts    []string     `json:"hosts"`
+	Files    []fileChange `json:"files"`
+	Reloaded bool         `json:"reloaded"`
+}
+
+type fileChange struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+	Backup  string `json:"backup,omitempty"`
+}
+
+// planOutput reports what plan and --dry-run would change.
+type planOutput struct {
+	Changed bool          `json:"changed"`
+	Files   []plannedFile `json:"files"`
+	Reload  bool          `json:"reload"`
+}
+
+type plannedFile struct {
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	Changed bool   `json:"changed"`
+	Diff    string `json:"diff,omitempty"`
+}
+
+// changes lists the files an apply touched, followed by any extra files such as the config.
+func (o applyOutcome) changes(names []string, extra ...fileChange) changeOutput {
+	out := changeOutput{Hosts: names, Reloaded: o.reloaded}
+	if out.Hosts == nil {
+		out.Hosts = []string{}
+	}
+	if o.include.Path != "" {
+		out.Files = append(out.Files, fileChange{Path: o.include.Path, Changed: o.include.Changed})
+	}
+	if o.hostsPath != "" {
+		out.Files = append(out.Files, fileChange{Path: o.hostsPath, Changed: o.hosts.Changed, Backup: o.hosts.BackupPath})
+	}
+	out.Files = append(out.Files, extra...)
+	if out.Files == nil {
+		out.Files = []fileChange{}
+	}
+	return out
+}
+
+func plannedFiles(files ...managedfile.Plan) []plannedFile {
+	out := []plannedFile{}
+	for _, f := range files {
+		if f.Path == "" {
+			continue
+		}
+		out = append(out, plannedFile{Path: f.Path, Exists: f.Existed, Changed: f.Changed, Diff: fileDiff(f)})
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cdfuller/devhosts/internal/backend"
+	"github.com/cdfuller/devhosts/internal/diff"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// ErrChangesPending is returned by plan and --dry-run when applying would change files.
+var ErrChangesPending = errors.New("changes pending")
+
+// statePlan is everything commitPlan would do, computed without side effects.
+type statePlan struct {
+	backend backend.Backend
+	// desired is the snapshot as saved; snapshot is its qualified form the files render.
+	desired  state.Snapshot
+	snapshot state.Snapshot
+	include  managedfile.Plan
+	hosts    managedfile.Plan
+	reload   bool
+}
+
+func (p statePlan) changed() bool {
+	return p.include.Changed || p.hosts.Changed
+}
+
+// planState renders desired into the include and hosts contents it would produce. The proxy
+// reloads when the include changes, or always when forceReload is set.
+func (a *App) planState(desired state.Snapshot, forceReload bool) (statePlan, error) {
+	snapshot := desired.Qualified()
+	backend := a.backendFor(snapshot)
+	if err := backend.EnsureBaseReady(snapshot); err != nil {
+		return statePlan{}, err
+	}
+	content, err := backend.GenerateInclude(snapshot)
+	if err != nil {
+		return statePlan{}, err
+	}
+	include, err := backend.PlanInclude(backend.IncludePath(snapshot), content)
+	if err != nil {
+		return statePlan{}, err
+	}
+	hosts, err := a.Hosts.Plan(a.HostsPath, snapshot.Hosts)
+	if err != nil {
+		return statePlan{}, err
+	}
+	return statePlan{
+		backend:  backend,
+		desired:  desired,
+		snapshot: snapshot,
+		include:  include,
+		hosts:    hosts,
+		reload:   forceReload || include.Changed,
+	}, nil
+}
+
+func (a *App) handlePlan(snapshot state.Snapshot) error {
+	if err := state.ValidateSnapshot(snapshot); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	plan, err := a.planState(snapshot, true)
+	if err != nil {
+		return err
+	}
+	return a.reportPlan(plan)
+}
+
+// planOnly reports the plan for desired the way add and remove would apply it.
+func (a *App) planOnly(desired state.Snapshot) error {
+	plan, err := a.planState(desired, false)
+	if err != nil {
+		return err
+	}
+	return a.reportPlan(plan)
+}
+
+// reportPlan prints a unified diff per changed file and whether the proxy would reload.
+func (a *App) reportPlan(plan statePlan) error {
+	if a.structured() {
+		err := a.emit(planOutput{
+			Changed: plan.changed(),
+			Files:   plannedFiles(plan.include, plan.hosts),
+			Reload:  plan.reload && plan.include.Path != "",
+		})
+		if err != nil || !plan.changed() {
+			return err
+		}
+		return ErrChangesPending
+	}
+	for _, file := range []managedfile.Plan{plan.include, plan.hosts} {
+		fmt.Fprint(a.Stdout, fileDiff(file))
+	}
+	if plan.reload && plan.include.Path != "" {
+		fmt.Fprintf(a.Stdout, "Would reload %s.\n", plan.backend.Name())
+	}
+	if !plan.changed() {
+		fmt.Fprintln(a.Stdout, "No changes.")
+		return nil
+	}
+	return ErrChangesPending
+}
+
+// fileDiff renders the unified diff for a planned file, or "" when it is unchanged.
+func fileDiff(file managedfile.Plan) string {
+	if !file.Changed {
+		return ""
+	}
+	oldName := file.Path
+	if !file.Existed {
+		oldName = "/dev/null"
+	}
+	out := diff.Unified(oldName, file.Path+" (planned)", string(file.Previous), file.Content)
+	if out == "" {
+		// A missing file planned as empty is still created.
+		out = fmt.Sprintf("--- %s\n+++ %s (planned)\n", oldName, file.Path)
+	}
+	return out
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/procfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// procfileProcess is a Procfile entry with the host and port devhosts gave it.
+type procfileProcess struct {
+	procfile.Process
+	Host string
+	Port int
+}
+
+func (a *App) handleProcfile(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, args []string) error {
+	procFlags := flag.NewFlagSet("procfile", flag.ContinueOnError)
+	procFlags.SetOutput(a.Stderr)
+	var app string
+	var basePort int
+	var noTLS, run bool
+	procFlags.StringVar(&app, "app", "", "app name prefixed to each host (default: the Procfile's directory)")
+	procFlags.IntVar(&basePort, "port", procfile.DefaultBasePort, "port of the first process; each next one gets 100 more")
+	procFlags.BoolVar(&noTLS, "no-tls", false, "route the hosts over plain HTTP")
+	procFlags.BoolVar(&run, "run", false, "start the processes and remove their hosts when they exit")
+	procFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts procfile [flags] [Procfile]\n\n")
+		fmt.Fprintln(a.Stderr, "Routes <app>-<process> to each Procfile entry on foreman's ports (5000, 5100, ...).")
+		fmt.Fprintln(a.Stderr, "With --run, starts every process with PORT set, prefixes their output with the process")
+		fmt.Fprintln(a.Stderr, "name, and removes the hosts once they exit or on Ctrl-C.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		procFlags.PrintDefaults()
+	}
+	if err := procFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if procFlags.NArg() > 1 {
+		return usageError("usage: devhosts procfile [flags] [Procfile]")
+	}
+	if basePort <= 0 || basePort > 65535 {
+		return usageError("--port %d invalid: must be between 1 and 65535", basePort)
+	}
+	if run && a.structured() {
+		return usageError("--run cannot be combined with --output")
+	}
+	path, err := a.findProcfile(procFlags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if app == "" {
+		app = filepath.Base(filepath.Dir(path))
+	}
+	procs, err := a.readProcfile(loaded.Snapshot, path, app, basePort)
+	if err != nil {
+		return err
+	}
+
+	project := config.Project{Dir: path, Path: path, Hosts: []state.Host{}}
+	for _, p := range procs {
+		host := state.Host{Name: p.Host, Upstream: fmt.Sprintf("http://localhost:%d", p.Port), TLS: !noTLS, Project: path, Ephemeral: run}
+		project.Hosts = append(project.Hosts, host)
+	}
+	if !run {
+		if err := a.syncProject(ctx, loaded, project, "Synced"); err != nil {
+			return err
+		}
+		a.printProcessRoutes(loaded.Snapshot, project, procs)
+		return nil
+	}
+	return a.runProcfile(ctx, loaded, opts, project, procs)
+}
+
+// printProcessRoutes tells which host and PORT each process was given.
+func (a *App) printProcessRoutes(snapshot state.Snapshot, project config.Project, procs []procfileProcess) {
+	for i, p := range procs {
+		fmt.Fprintf(a.Stderr, "Routing %s to %s (%s, PORT=%d).\n", snapshot.URL(project.Hosts[i]), project.Hosts[i].Upstream, p.Name, p.Port)
+	}
+}
+
+// findProcfile resolves the file argument, or the Procfile in WorkDir or the current directory.
+func (a *App) findProcfile(arg string) (string, error) {
+	path := arg
+	if path == "" {
+		dir, err := a.workDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(dir, procfile.FileName)
+	}
+	path, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+// readProcfile parses the Procfile at path and gives each process its host and port.
+func (a *App) readProcfile(snapshot state.Snapshot, path, app string, basePort int) ([]procfileProcess, error) {
+	data, err := a.Loader.FS.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	entries, err := procfile.Parse(data)
+	if err != nil {
+		return nil, withCode(CodeInvalidConfig, fmt.Errorf("%s: %w", path, err))
+	}
+	procs := make([]procfileProcess, 0, len(entries))
+	for i, entry := range entries {
+		port := procfile.Port(basePort, i)
+		if port > 65535 {
+			return nil, usageError("--port %d leaves no port for %s", basePort, entry.Name)
+		}
+		name := snapshot.BareName(normalizeSpecName(procfile.HostName(app, entry.Name)))
+		procs = append(procs, procfileProcess{Process: entry, Host: name, Port: port})
+	}
+	return procs, nil
+}
+
+// runProcfile registers the hosts as ephemeral, supervises the processes, and removes the
+// hosts again however the processes end.
+func (a *App) runProcfile(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, project config.Project, procs []procfileProcess) error {
+	desired, stale := a.collectStaleRuns(cloneSnapshot(loaded.Snapshot))
+	var synced []string
+	for _, h := range desired.Hosts {
+		if h.Project != project.Path {
+			continue
+		}
+		if h.Ephemeral {
+			return fmt.Errorf("%s is already running under another devhosts procfile --run", project.Path)
+		}
+		synced = append(synced, h.Name)
+	}
+	// The run replaces the file's hosts and removes them on exit, which would lose these.
+	if len(synced) > 0 {
+		return fmt.Errorf("%s hosts were synced without --run; run `devhosts remove %s` first", project.Path, strings.Join(synced, " "))
+	}
+	desired, err := config.MergeProject(desired, project)
+	if err != nil {
+		return withCode(CodeInvalidConfig, fmt.Errorf("%s: %w", project.Path, err))
+	}
+	if err := state.ValidateSnapshot(desired); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	if a.DryRun {
+		return a.planOnly(desired)
+	}
+
+	names := make([]string, 0, len(procs))
+	for _, p := range procs {
+		names = append(names, p.Host)
+	}
+	return a.holdRun(ctx, loaded.Path, opts, desired, names, stale,
+		func() { a.printProcessRoutes(desired, project, procs) },
+		func() error { return a.superviseProcesses(ctx, procs) })
+}
+
+// superviseProcesses runs every process through the shell with PORT set until one exits or
+// SIGINT or SIGTERM arrives, then interrupts the rest. Processes stopped that way count as
+// clean exits; the one that ended the run reports its own failure.
+func (a *App) superviseProcesses(ctx context.Context, procs []procfileProcess) error {
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	runCtx, cancel := context.WithCancel(sigCtx)
+	defer cancel()
+
+	width := 0
+	for _, p := range procs {
+		width = max(width, len(p.Name))
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make([]error, len(procs))
+	for i, p := range procs {
+		out := &prefixWriter{mu: &mu, w: a.Stdout, prefix: fmt.Sprintf("%-*s | ", width, p.Name)}
+		cmd := exec.CommandContext(runCtx, "sh", "-c", p.Command)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%d", p.Port))
+		cmd.Stdout = out
+		cmd.Stderr = out
+		interruptGroup(cmd)
+		cmd.WaitDelay = runStopTimeout
+		if err := cmd.Start(); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", p.Name, err)
+			cancel()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := cmd.Wait()
+			stopped := runCtx.Err() != nil
+			cancel()
+			out.Flush()
+			if err != nil && !stopped {
+				errs[i] = fmt.Errorf("%s: %w", p.Name, err)
+			}
+			fmt.Fprintf(out, "exited (%s)\n", cmd.ProcessState)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// prefixWriter writes each complete line under prefix, holding mu so lines from processes
+// writing at once never interleave.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes a final line left without a newline.
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) > 0 {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+		p.buf = nil
+	}
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cdfuller/devhosts/internal/config"
+)
+
+func (a *App) handleUp(ctx context.Context, loaded config.Loaded, args []string) error {
+	project, err := a.findProject("up", args)
+	if err != nil {
+		return err
+	}
+	for i := range project.Hosts {
+		project.Hosts[i].Name = loaded.Snapshot.BareName(project.Hosts[i].Name)
+	}
+	if len(project.Hosts) == 0 {
+		fmt.Fprintf(a.Stderr, "Warning: %s declares no hosts.\n", project.Path)
+	}
+	return a.syncProject(ctx, loaded, project, "Configured")
+}
+
+func (a *App) handleDown(ctx context.Context, loaded config.Loaded, args []string) error {
+	project, err := a.findProject("down", args)
+	if err != nil {
+		return err
+	}
+	desired, removed := config.RemoveProject(cloneSnapshot(loaded.Snapshot), project.Dir)
+	if len(removed) == 0 {
+		fmt.Fprintf(a.Stderr, "Warning: no hosts from %s are managed.\n", project.Dir)
+	}
+	return a.commitState(ctx, loaded, desired, removed, "Removed")
+}
+
+// findProject locates the manifest for up or down, starting from the optional directory
+// argument, then WorkDir, then the current directory.
+func (a *App) findProject(cmd string, args []string) (config.Project, error) {
+	if len(args) > 1 {
+		return config.Project{}, usageError("usage: devhosts %s [dir]", cmd)
+	}
+	start := a.WorkDir
+	if len(args) == 1 {
+		start = args[0]
+	}
+	if start == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return config.Project{}, err
+		}
+		start = wd
+	}
+	return a.Loader.FindProject(start)
+}
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/journal"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// recoverOutput reports a leftover journal and what recover did with it.
+type recoverOutput struct {
+	// Action is "none" without a journal, "pending" when only reporting it, or "forward"/"back".
+	Action  string    `json:"action"`
+	PID     int       `json:"pid,omitempty"`
+	Started time.Time `json:"started,omitzero"`
+	Files   []string  `json:"files"`
+}
+
+// checkJournal looks for a transaction an earlier process left unfinished. Commands that
+// change state refuse to run on top of it; others only warn.
+func (a *App) checkJournal(writes bool) error {
+	j, err := journal.Load(a.Loader.FS, journal.Path(a.StateDir))
+	if err != nil {
+		return err
+	}
+	if j == nil || system.ProcessAlive(j.PID) {
+		return nil
+	}
+	msg := fmt.Sprintf("an apply started by pid %d at %s was interrupted; run `devhosts recover --forward` to finish it or `devhosts recover --back` to undo it",
+		j.PID, j.Started.Local().Format(time.DateTime))
+	if writes {
+		return withCode(CodeRecoveryNeeded, errors.New(msg))
+	}
+	fmt.Fprintf(a.Stderr, "Warning: %s.\n", msg)
+	return nil
+}
+
+func (a *App) handleRecover(ctx context.Context, opts config.LoadOptions, args []string) error {
+	recoverFlags := flag.NewFlagSet("recover", flag.ContinueOnError)
+	recoverFlags.SetOutput(a.Stderr)
+	var forward, back bool
+	recoverFlags.BoolVar(&forward, "forward", false, "finish the interrupted apply and save its config")
+	recoverFlags.BoolVar(&back, "back", false, "restore every file the interrupted apply touched")
+	recoverFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts recover [--forward|--back]\n\n")
+		fmt.Fprintln(a.Stderr, "Without a flag, describes the apply that was interrupted.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		recoverFlags.PrintDefaults()
+	}
+	if err := recoverFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	if forward && back {
+		return usageError("cannot use --forward and --back together")
+	}
+
+	journalPath := journal.Path(a.StateDir)
+	j, err := journal.Load(a.Loader.FS, journalPath)
+	if err != nil {
+		return err
+	}
+	if j == nil {
+		if a.structured() {
+			return a.emit(recoverOutput{Action: "none", Files: []string{}})
+		}
+		fmt.Fprintln(a.Stdout, "Nothing to recover.")
+		return nil
+	}
+	if system.ProcessAlive(j.PID) {
+		return fmt.Errorf("pid %d is still applying; wait for it to finish", j.PID)
+	}
+	out := recoverOutput{Action: "pending", PID: j.PID, Started: j.Started, Files: []string{}}
+	for _, f := range j.Files {
+		out.Files = append(out.Files, f.Path)
+	}
+
+	switch {
+	case back:
+		out.Action = "back"
+		if err := j.Restore(a.Loader.FS); err != nil {
+			return fmt.Errorf("restore files: %w", err)
+		}
+		// The restored files are live again only once the proxy reloads them.
+		restored, err := a.Loader.Load(opts)
+		if err != nil {
+			return fmt.Errorf("reload restored config: %w", err)
+		}
+		snapshot := restored.Snapshot.Qualified()
+		backend := a.backendFor(snapshot)
+		if backend.IncludePath(snapshot) != "" {
+			if reloadOut, err := backend.Reload(ctx, snapshot); err != nil {
+				a.commitJournal(journalPath)
+				return fmt.Errorf("files restored, but %w; run `devhosts apply` once it is running", commandError(backend.Name()+" reload", reloadOut, err))
+			}
+		}
+	case forward:
+		out.Action = "forward"
+		plan, err := a.planState(j.Desired, true)
+		if err != nil {
+			return err
+		}
+		// The journal stays in place until the config is saved, so a second crash can
+		// still be rolled back to the original files.
+		if _, err := a.applyPlan(ctx, plan); err != nil {
+			return err
+		}
+		if err := a.Loader.Save(j.ConfigPath, j.Desired); err != nil {
+			return fmt.Errorf("save config: %w", err)
+		}
+	default:
+		if a.structured() {
+			return a.emit(out)
+		}
+		fmt.Fprintf(a.Stdout, "An apply started by pid %d at %s was interrupted and may have changed:\n", j.PID, j.Started.Local().Format(time.DateTime))
+		for _, path := range out.Files {
+			fmt.Fprintf(a.Stdout, "  %s\n", path)
+		}
+		fmt.Fprintf(a.Stdout, "It was applying %d host(s) to %s.\n", len(j.Desired.Hosts), j.ConfigPath)
+		fmt.Fprintln(a.Stdout, "Run `devhosts recover --forward` to finish it or `devhosts recover --back` to undo it.")
+		return nil
+	}
+
+	a.commitJournal(journalPath)
+	if a.structured() {
+		return a.emit(out)
+	}
+	if back {
+		fmt.Fprintln(a.Stdout, "Rolled back the interrupted apply.")
+	} else {
+		fmt.Fprintln(a.Stdout, "Finished the interrupted apply.")
+	}
+	return nil
+}
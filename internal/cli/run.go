@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// runStopTimeout is how long an interrupted child gets to exit before it is killed.
+const runStopTimeout = 10 * time.Second
+
+func (a *App) handleRun(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, args []string) error {
+	runFlags := flag.NewFlagSet("run", flag.ContinueOnError)
+	runFlags.SetOutput(a.Stderr)
+	var noTLS bool
+	var port int
+	var portEnv string
+	runFlags.BoolVar(&noTLS, "no-tls", false, "route the host over plain HTTP")
+	runFlags.IntVar(&port, "port", 0, "port to pass to the command instead of a free one")
+	runFlags.StringVar(&portEnv, "env", "PORT", "environment variable the port is passed in")
+	runFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts run [flags] <host> -- <command> [args]\n\n")
+		fmt.Fprintln(a.Stderr, "Runs command with PORT set to a free loopback port and routes host to it until the command exits.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		runFlags.PrintDefaults()
+	}
+	if err := runFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+	rest := runFlags.Args()
+	if len(rest) > 1 && rest[1] == "--" {
+		rest = append(rest[:1], rest[2:]...)
+	}
+	if len(rest) < 2 {
+		runFlags.Usage()
+		return usageError("a host name and a command are required")
+	}
+	name, command := loaded.Snapshot.BareName(normalizeSpecName(rest[0])), rest[1:]
+
+	desired, stale := a.collectStaleRuns(cloneSnapshot(loaded.Snapshot))
+	if findHostIndex(desired.Hosts, name) != -1 {
+		return fmt.Errorf("host %s is already managed; pick another name or remove it first", name)
+	}
+	if port == 0 {
+		var err error
+		if port, err = freePort(); err != nil {
+			return fmt.Errorf("allocate port: %w", err)
+		}
+	}
+	host := state.Host{Name: name, Upstream: fmt.Sprintf("http://127.0.0.1:%d", port), TLS: !noTLS, Ephemeral: true}
+	desired.Hosts = append(desired.Hosts, host)
+	if err := state.ValidateSnapshot(desired); err != nil {
+		return withCode(CodeInvalidConfig, err)
+	}
+	if a.DryRun {
+		return a.planOnly(desired)
+	}
+	return a.holdRun(ctx, loaded.Path, opts, desired, []string{name}, stale,
+		func() { fmt.Fprintf(a.Stderr, "Routing %s to %s.\n", desired.URL(host), host.Upstream) },
+		func() error { return a.runChild(ctx, command, portEnv, port) })
+}
+
+// holdRun saves desired with the ephemeral hosts names and run markers for them, then calls
+// announce and, with the lock released, run. However run ends, the hosts are removed again.
+// stale are the hosts collectStaleRuns dropped from desired.
+func (a *App) holdRun(ctx context.Context, path string, opts config.LoadOptions, desired state.Snapshot, names, stale []string, announce func(), run func() error) error {
+	// The markers outlive a crash, so the next run can tell these hosts were abandoned.
+	var marked []string
+	removeMarkers := func() {
+		for _, name := range marked {
+			_ = a.Loader.FS.Remove(a.runMarker(name))
+		}
+	}
+	for _, name := range names {
+		marker := a.runMarker(name)
+		if err := a.Loader.FS.MkdirAll(filepath.Dir(marker), 0o755); err != nil {
+			removeMarkers()
+			return fmt.Errorf("create run marker: %w", err)
+		}
+		if err := a.Loader.FS.WriteFile(marker, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			removeMarkers()
+			return fmt.Errorf("create run marker: %w", err)
+		}
+		marked = append(marked, name)
+	}
+	if _, err := a.saveState(ctx, path, desired); err != nil {
+		removeMarkers()
+		return err
+	}
+	for _, old := range stale {
+		_ = a.Loader.FS.Remove(a.runMarker(old))
+		fmt.Fprintf(a.Stderr, "Removed stale host %s left by an earlier run.\n", old)
+	}
+	announce()
+	// Other commands may change the config while the command runs.
+	a.releaseLock()
+
+	runErr := run()
+	// Clean up even when interrupted; the reload must not inherit the cancelled context.
+	if err := a.releaseRun(context.WithoutCancel(ctx), opts, names...); err != nil {
+		return errors.Join(runErr, fmt.Errorf("remove %s: %w", strings.Join(names, ", "), err))
+	}
+	removeMarkers()
+	return runErr
+}
+
+// runChild runs command until it exits, forwarding SIGINT and SIGTERM to it. A child stopped
+// by one of those signals counts as a clean exit.
+func (a *App) runChild(ctx context.Context, command []string, portEnv string, port int) error {
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cmd := exec.CommandContext(sigCtx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", portEnv, port))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = a.Stdout
+	cmd.Stderr = a.Stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = runStopTimeout
+	err := cmd.Run()
+	if err != nil && sigCtx.Err() != nil && ctx.Err() == nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(command, " "), err)
+	}
+	return nil
+}
+
+// releaseRun removes the ephemeral hosts from the config as it is now, keeping any changes
+// made while the command ran.
+func (a *App) releaseRun(ctx context.Context, opts config.LoadOptions, names ...string) error {
+	if err := a.acquireLock(opts); err != nil {
+		return err
+	}
+	defer a.releaseLock()
+	loaded, err := a.Loader.Load(opts)
+	if err != nil {
+		return err
+	}
+	desired := cloneSnapshot(loaded.Snapshot)
+	removed := false
+	for _, name := range names {
+		idx := findHostIndex(desired.Hosts, name)
+		if idx == -1 || !desired.Hosts[idx].Ephemeral {
+			continue
+		}
+		desired.Hosts = append(desired.Hosts[:idx], desired.Hosts[idx+1:]...)
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	_, err = a.saveState(ctx, loaded.Path, desired)
+	return err
+}
+
+// collectStaleRuns drops ephemeral hosts whose run marker is missing or names a process that
+// has exited, returning their names.
+func (a *App) collectStaleRuns(snapshot state.Snapshot) (state.Snapshot, []string) {
+	kept := make([]state.Host, 0, len(snapshot.Hosts))
+	var stale []string
+	for _, h := range snapshot.Hosts {
+		if h.Ephemeral && !a.runAlive(h.Name) {
+			stale = append(stale, h.Name)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	snapshot.Hosts = kept
+	return snapshot, stale
+}
+
+func (a *App) runAlive(name string) bool {
+	data, err := a.Loader.FS.ReadFile(a.runMarker(name))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && system.ProcessAlive(pid)
+}
+
+func (a *App) runMarker(name string) string {
+	return filepath.Join(a.StateDir, "run", name+".pid")
+}
+
+// freePort asks the kernel for an unused loopback port. The listener is closed before the
+// child binds it, so another process could take it in between; that window is accepted.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/backend"
+	"github.com/cdfuller/devhosts/internal/config"
+	"github.com/cdfuller/devhosts/internal/devproxy"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/localca"
+)
+
+const serveWatchInterval = time.Second
+
+func (a *App) handleServe(ctx context.Context, loaded config.Loaded, opts config.LoadOptions, args []string) error {
+	serveFlags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	serveFlags.SetOutput(a.Stderr)
+	var httpAddr, httpsAddr, caDir string
+	serveFlags.StringVar(&httpAddr, "http", ":80", "plain HTTP listen address (empty disables)")
+	serveFlags.StringVar(&httpsAddr, "https", ":443", "HTTPS listen address (empty disables)")
+	serveFlags.StringVar(&caDir, "ca-dir", backend.CADir(a.StateDir), "directory holding the local CA")
+	serveFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts serve [flags]\n\n")
+		fmt.Fprintln(a.Stderr, "Routes managed hosts by Host header without Caddy, reloading when devhosts.json changes.")
+		fmt.Fprintln(a.Stderr, "Set \"backend\": \"builtin\" in devhosts.json so add/remove/apply skip Caddy.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		serveFlags.PrintDefaults()
+	}
+	if err := serveFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	ca, err := localca.LoadOrCreateCA(a.Loader.FS, caDir)
+	if err != nil {
+		return fmt.Errorf("load local CA: %w", err)
+	}
+	srv := devproxy.NewServer(httpAddr, httpsAddr, ca)
+	if err := srv.Update(loaded.Snapshot.Qualified().Hosts); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go filesystem.Watch(ctx, a.Loader.FS, loaded.Path, serveWatchInterval, func() {
+		reloaded, err := a.Loader.Load(opts)
+		if err != nil {
+			fmt.Fprintf(a.Stderr, "Warning: keeping previous hosts: %v\n", err)
+			return
+		}
+		if err := srv.Update(reloaded.Snapshot.Qualified().Hosts); err != nil {
+			fmt.Fprintf(a.Stderr, "Warning: keeping previous hosts: %v\n", err)
+			return
+		}
+		fmt.Fprintf(a.Stdout, "Reloaded %d host(s) from %s.\n", len(reloaded.Snapshot.Hosts), reloaded.Path)
+	})
+
+	fmt.Fprintf(a.Stdout, "Serving %d host(s) (http %q, https %q).\n", len(loaded.Snapshot.Hosts), httpAddr, httpsAddr)
+	fmt.Fprintf(a.Stdout, "Trust %s to avoid certificate warnings.\n", ca.CertPath)
+	return srv.Run(ctx)
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cdfuller/devhosts/internal/backend"
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/status"
+)
+
+func (a *App) handleStatus(ctx context.Context, snapshot state.Snapshot, args []string) error {
+	statusFlags := flag.NewFlagSet("status", flag.ContinueOnError)
+	statusFlags.SetOutput(a.Stderr)
+	var asJSON bool
+	var httpAddr, httpsAddr, caCert string
+	statusFlags.BoolVar(&asJSON, "json", false, "shorthand for the global --output json")
+	statusFlags.StringVar(&httpAddr, "http", "127.0.0.1:80", "address the proxy serves plain HTTP on")
+	statusFlags.StringVar(&httpsAddr, "https", "127.0.0.1:443", "address the proxy serves HTTPS on")
+	statusFlags.StringVar(&caCert, "ca-cert", "", "root certificate TLS hosts should chain to (default: the backend's local CA)")
+	statusFlags.Usage = func() {
+		fmt.Fprintf(a.Stderr, "Usage: devhosts status [flags]\n\n")
+		fmt.Fprintln(a.Stderr, "Checks each host's hosts-file entry, DNS, upstream, proxy response, and TLS issuer.")
+		fmt.Fprintln(a.Stderr)
+		fmt.Fprintln(a.Stderr, "Flags:")
+		statusFlags.PrintDefaults()
+	}
+	if err := statusFlags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return withCode(CodeUsage, err)
+	}
+
+	qualified := snapshot.Qualified()
+	checker := status.Checker{
+		Hosts:     a.Hosts,
+		HostsPath: a.HostsPath,
+		HTTPAddr:  httpAddr,
+		HTTPSAddr: httpsAddr,
+	}
+	if caCert == "" {
+		caCert, checker.RootsErr = a.localCARoot(qualified)
+	}
+	if caCert != "" {
+		checker.Roots, checker.RootsErr = status.LoadRoots(a.Loader.FS, caCert)
+	}
+
+	results, err := checker.Run(ctx, qualified)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		a.Output = OutputJSON
+	}
+	if a.structured() {
+		if err := a.emit(struct {
+			Hosts []status.Result `json:"hosts"`
+		}{results}); err != nil {
+			return err
+		}
+	} else if err := a.printStatus(results); err != nil {
+		return err
+	}
+
+	failing := 0
+	for _, res := range results {
+		if res.Failed() {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return withCode(CodeChecksFailed, fmt.Errorf("%d of %d host(s) failing checks", failing, len(results)))
+	}
+	return nil
+}
+
+// localCARoot returns the root certificate the snapshot's backend issues TLS certificates from.
+func (a *App) localCARoot(snapshot state.Snapshot) (string, error) {
+	switch snapshot.Backend {
+	case "", state.BackendCaddy:
+		return caddy.LocalCARoot()
+	default:
+		return localca.CertPath(backend.CADir(a.StateDir)), nil
+	}
+}
+
+func (a *App) printStatus(results []status.Result) error {
+	if len(results) == 0 {
+		fmt.Fprintln(a.Stdout, "No hosts managed.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(a.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\t"+strings.ToUpper(strings.Join(status.Checks, "\t")))
+	var details []string
+	for _, res := range results {
+		cells := []string{res.Host}
+		for _, c := range res.Checks {
+			switch c.Status {
+			case status.StatusOK:
+				cells = append(cells, "ok")
+			case status.StatusSkip:
+				cells = append(cells, "-")
+			default:
+				cells = append(cells, "FAIL")
+				details = append(details, fmt.Sprintf("%s %s: %s", res.Host, c.Name, c.Detail))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(details) > 0 {
+		fmt.Fprintln(a.Stdout)
+		for _, d := range details {
+			fmt.Fprintln(a.Stdout, d)
+		}
+	}
+	return nil
+}
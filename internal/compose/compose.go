@@ -0,0 +1,212 @@
+// Package compose reads the services a Compose file publishes on the host so devhosts can
+// route a name to each of them.
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cdfuller/devhosts/internal/yaml"
+)
+
+// FileNames are the files looked for when no Compose file is named, in Compose's own order
+// of preference.
+var FileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// Extension is the service-level key that overrides how a service is routed. Labels with the
+// same names under a "devhosts." prefix do the same; the extension wins when both are set.
+const Extension = "x-devhosts"
+
+const labelPrefix = "devhosts."
+
+// Service is a Compose service published on a TCP port the loopback interface reaches.
+type Service struct {
+	// Service is the name in the Compose file; Name is the host to route, taken from the
+	// name setting or derived from Service.
+	Service string
+	Name    string
+	// Port is the published host port: the port setting, or the first TCP port published.
+	Port int
+	// TLS is nil unless the tls setting chose it.
+	TLS *bool
+}
+
+// Parse reads the services in a Compose file that publish a TCP port, sorted by service
+// name. Services without one, or with enable set to false, are left out.
+func Parse(data []byte) ([]Service, error) {
+	doc, err := yaml.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return nil, errors.New("compose file must be a mapping")
+	}
+	services, ok := root["services"].(map[string]any)
+	if !ok {
+		return nil, errors.New("compose file has no services")
+	}
+	var out []Service
+	for name, raw := range services {
+		def, _ := raw.(map[string]any)
+		svc, ok, err := parseService(name, def)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		if ok {
+			out = append(out, svc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out, nil
+}
+
+func parseService(name string, def map[string]any) (Service, bool, error) {
+	settings, err := serviceSettings(def)
+	if err != nil {
+		return Service{}, false, err
+	}
+	if enable, ok := settings["enable"]; ok {
+		on, err := strconv.ParseBool(enable)
+		if err != nil {
+			return Service{}, false, fmt.Errorf("enable %q invalid: %w", enable, err)
+		}
+		if !on {
+			return Service{}, false, nil
+		}
+	}
+	ports, err := publishedPorts(def["ports"])
+	if err != nil {
+		return Service{}, false, err
+	}
+	svc := Service{Service: name, Name: hostName(name)}
+	if override := settings["name"]; override != "" {
+		svc.Name = override
+	}
+	switch port := settings["port"]; {
+	case port != "":
+		if svc.Port, err = strconv.Atoi(port); err != nil {
+			return Service{}, false, fmt.Errorf("port %q invalid: must be a number", port)
+		}
+	case len(ports) > 0:
+		svc.Port = ports[0]
+	default:
+		return Service{}, false, nil
+	}
+	if tls, ok := settings["tls"]; ok {
+		on, err := strconv.ParseBool(tls)
+		if err != nil {
+			return Service{}, false, fmt.Errorf("tls %q invalid: %w", tls, err)
+		}
+		svc.TLS = &on
+	}
+	return svc, true, nil
+}
+
+// serviceSettings merges the devhosts.* labels with the x-devhosts extension.
+func serviceSettings(def map[string]any) (map[string]string, error) {
+	settings := map[string]string{}
+	switch labels := def["labels"].(type) {
+	case map[string]any:
+		for k, v := range labels {
+			if key, ok := strings.CutPrefix(k, labelPrefix); ok {
+				settings[key], _ = v.(string)
+			}
+		}
+	case []any:
+		for _, item := range labels {
+			label, _ := item.(string)
+			if k, v, _ := strings.Cut(label, "="); strings.HasPrefix(k, labelPrefix) {
+				settings[strings.TrimPrefix(k, labelPrefix)] = v
+			}
+		}
+	}
+	switch ext := def[Extension].(type) {
+	case nil:
+	case map[string]any:
+		for k, v := range ext {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s must be a scalar", Extension, k)
+			}
+			settings[k] = s
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a mapping", Extension)
+	}
+	return settings, nil
+}
+
+// hostName turns a service name into a bare host name: Compose allows _ and . where host
+// names do not.
+func hostName(service string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(service))
+}
+
+// publishedPorts returns the host ports a service publishes over TCP on an address the
+// loopback interface reaches, in the order listed. Ranges, random ports, and ports written
+// with ${VARIABLES} are skipped.
+func publishedPorts(v any) ([]int, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, errors.New("ports must be a list")
+	}
+	var ports []int
+	for _, item := range list {
+		var hostIP, published, protocol string
+		switch item := item.(type) {
+		case string:
+			hostIP, published, protocol = splitShortPort(item)
+		case map[string]any:
+			hostIP, _ = item["host_ip"].(string)
+			published, _ = item["published"].(string)
+			protocol, _ = item["protocol"].(string)
+		default:
+			return nil, fmt.Errorf("port %v invalid", item)
+		}
+		if protocol != "" && protocol != "tcp" || !reachable(hostIP) {
+			continue
+		}
+		if port, err := strconv.Atoi(published); err == nil && port > 0 && port < 65536 {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+// splitShortPort splits "[host_ip:]published:target[/protocol]". A lone target publishes
+// nothing predictable and comes back with published empty.
+func splitShortPort(spec string) (hostIP, published, protocol string) {
+	spec, protocol, _ = strings.Cut(spec, "/")
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]:")
+		if end < 0 {
+			return "", "", protocol
+		}
+		hostIP, spec = spec[1:end], spec[end+2:]
+	}
+	parts := strings.Split(spec, ":")
+	switch {
+	case len(parts) == 2:
+		published = parts[0]
+	case len(parts) == 3 && hostIP == "":
+		hostIP, published = parts[0], parts[1]
+	}
+	return hostIP, published, protocol
+}
+
+// reachable reports whether a port bound to hostIP answers on loopback.
+func reachable(hostIP string) bool {
+	if hostIP == "" {
+		return true
+	}
+	ip := net.ParseIP(hostIP)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
@@ -0,0 +1,68 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`services:
+  web_app:
+    image: nginx
+    ports:
+      - "8080:80"
+      - "8443:443"
+  api:
+    ports:
+      - target: 3000
+        published: "4000"
+        host_ip: 127.0.0.1
+    x-devhosts:
+      name: backend
+      tls: false
+  db:
+    ports:
+      - "5432"
+      - "192.168.1.5:5433:5432"
+      - "5434:5432/udp"
+  admin:
+    labels:
+      - devhosts.port=9001
+    ports:
+      - "[::1]:9000:80"
+      - "9001:81"
+  worker:
+    labels:
+      devhosts.enable: "false"
+    ports: ["7000:7000"]
+  range:
+    ports: ["6000-6001:6000-6001", "${PORT:-6002}:80"]
+`)
+	services, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	off := false
+	want := []Service{
+		{Service: "admin", Name: "admin", Port: 9001},
+		{Service: "api", Name: "backend", Port: 4000, TLS: &off},
+		{Service: "web_app", Name: "web-app", Port: 8080},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Fatalf("Parse = %+v, want %+v", services, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"- not a mapping\n",
+		"version: '3'\n",
+		"services:\n  web:\n    ports: 8080\n",
+		"services:\n  web:\n    ports: ['8080:80']\n    x-devhosts: web\n",
+		"services:\n  web:\n    ports: ['8080:80']\n    labels: {devhosts.tls: maybe}\n",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", data)
+		}
+	}
+}
@@ -7,8 +7,10 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/lockfile"
 	"github.com/cdfuller/devhosts/internal/state"
 )
 
@@ -23,6 +25,8 @@ type LoadOptions struct {
 type Loaded struct {
 	Snapshot state.Snapshot
 	Path     string
+	// StateDir holds devhosts-owned runtime files such as the local CA.
+	StateDir string
 }
 
 // Loader reads and writes devhosts configuration files.
@@ -75,10 +79,10 @@ func (l Loader) Load(opts LoadOptions) (Loaded, error) {
 		return Loaded{}, err
 	}
 
-	return Loaded{Snapshot: snapshot, Path: configPath}, nil
+	return Loaded{Snapshot: snapshot, Path: configPath, StateDir: StateDir(configPath)}, nil
 }
 
-// Save writes the snapshot back to disk with stable formatting.
+// Save writes the snapshot back to disk atomically with stable formatting.
 func (l Loader) Save(path string, snapshot state.Snapshot) error {
 	if l.FS == nil {
 		l.FS = filesystem.OS{}
@@ -97,7 +101,38 @@ func (l Loader) Save(path string, snapshot state.Snapshot) error {
 		return err
 	}
 
-	return l.FS.WriteFile(path, append(data, '\n'), 0o600)
+	// Write beside the config and rename over it so a crash never leaves it half-written.
+	tempPath := fmt.Sprintf("%s.devhosts.tmp-%d", path, time.Now().UnixNano())
+	if err := l.FS.WriteFile(tempPath, append(data, '\n'), 0o600); err != nil {
+		_ = l.FS.Remove(tempPath)
+		return err
+	}
+	if err := l.FS.Rename(tempPath, path); err != nil {
+		_ = l.FS.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+// Lock takes the advisory lock that guards the config named by opts, and the files applied
+// from it, against other devhosts processes. It waits up to timeout for a current holder.
+func (l Loader) Lock(opts LoadOptions, timeout time.Duration) (*lockfile.Lock, error) {
+	if l.FS == nil {
+		l.FS = filesystem.OS{}
+	}
+	configPath, err := resolveConfigPath(opts.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.FS.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		return nil, fmt.Errorf("ensure config dir: %w", err)
+	}
+	return lockfile.Acquire(LockPath(configPath), timeout)
+}
+
+// LockPath returns the lock file guarding the config at configPath.
+func LockPath(configPath string) string {
+	return configPath + ".lock"
 }
 
 func (l Loader) readSnapshot(path string) (state.Snapshot, error) {
@@ -127,6 +162,11 @@ func (l Loader) readSnapshot(path string) (state.Snapshot, error) {
 	return snapshot, nil
 }
 
+// StateDir returns the directory for runtime files belonging to the config at configPath.
+func StateDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), ".devhosts")
+}
+
 func resolveConfigPath(input string) (string, error) {
 	if input == "" {
 		def, err := defaultConfigPath()
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/cdfuller/devhosts/internal/filesystem"
@@ -71,3 +72,58 @@ func TestOverrides(t *testing.T) {
 		t.Fatalf("expected include override applied, got %s", loaded.Snapshot.IncludeCaddyfile)
 	}
 }
+
+func TestFindProjectWalksUp(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"hosts":[{"name":"Billing","upstream":"http://localhost:7000"}]}`
+	if err := os.WriteFile(filepath.Join(dir, ProjectFile), []byte(manifest), 0o644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	nested := filepath.Join(dir, "web", "src")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	project, err := NewLoader(filesystem.OS{}).FindProject(nested)
+	if err != nil {
+		t.Fatalf("FindProject returned error: %v", err)
+	}
+	if project.Dir != dir || len(project.Hosts) != 1 || project.Hosts[0].Name != "billing" || project.Hosts[0].Project != dir {
+		t.Fatalf("unexpected project: %+v", project)
+	}
+}
+
+func TestMergeProject(t *testing.T) {
+	snap := state.Snapshot{Version: 1, Hosts: []state.Host{
+		{Name: "user", Upstream: "http://localhost:8000"},
+		{Name: "old", Upstream: "http://localhost:7001", Project: "/src/billing"},
+		{Name: "shop", Upstream: "http://localhost:9000", Project: "/src/shop"},
+	}}
+	project := Project{Dir: "/src/billing", Hosts: []state.Host{{Name: "billing", Upstream: "http://localhost:7000", Project: "/src/billing"}}}
+	merged, err := MergeProject(snap, project)
+	if err != nil {
+		t.Fatalf("MergeProject returned error: %v", err)
+	}
+	var names []string
+	for _, h := range merged.Hosts {
+		names = append(names, h.Name)
+	}
+	if strings.Join(names, " ") != "user shop billing" {
+		t.Fatalf("expected the project's stale host to be replaced, got %v", names)
+	}
+
+	project.Hosts = append(project.Hosts, state.Host{Name: "shop"}, state.Host{Name: "user"})
+	_, err = MergeProject(snap, project)
+	if err == nil || !strings.Contains(err.Error(), `"shop" is already claimed by /src/shop`) || !strings.Contains(err.Error(), "the global config") {
+		t.Fatalf("expected both conflicts, got %v", err)
+	}
+	project.Hosts = []state.Host{{Name: "billing"}, {Name: "billing"}}
+	_, err = MergeProject(snap, project)
+	if err == nil || !strings.Contains(err.Error(), `"billing" is declared more than once`) || strings.Contains(err.Error(), "claimed") {
+		t.Fatalf("expected a duplicate error, got %v", err)
+	}
+
+	down, removed := RemoveProject(merged, "/src/billing")
+	if len(removed) != 1 || removed[0] != "billing" || len(down.Hosts) != 2 {
+		t.Fatalf("unexpected removal: %v %+v", removed, down.Hosts)
+	}
+}
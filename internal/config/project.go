@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// ProjectFile is the name of the per-project manifest checked into a repository.
+const ProjectFile = ".devhosts.json"
+
+// ErrNoProject is returned when no manifest exists in a directory or any of its parents.
+var ErrNoProject = errors.New("no " + ProjectFile + " found")
+
+// Project is a manifest found on disk. Dir is the directory holding it, which tags the hosts
+// the project adds to the global config.
+type Project struct {
+	Dir   string       `json:"-"`
+	Path  string       `json:"-"`
+	Hosts []state.Host `json:"hosts"`
+}
+
+// FindProject walks up from start to the filesystem root and reads the first manifest found.
+func (l Loader) FindProject(start string) (Project, error) {
+	if l.FS == nil {
+		l.FS = filesystem.OS{}
+	}
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return Project{}, err
+	}
+	for {
+		path := filepath.Join(dir, ProjectFile)
+		data, err := l.FS.ReadFile(path)
+		switch {
+		case err == nil:
+			return parseProject(dir, path, data)
+		case !errors.Is(err, fs.ErrNotExist):
+			return Project{}, fmt.Errorf("read %s: %w", path, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Project{}, fmt.Errorf("%w in %s or any parent directory", ErrNoProject, start)
+		}
+		dir = parent
+	}
+}
+
+func parseProject(dir, path string, data []byte) (Project, error) {
+	project := Project{Dir: dir, Path: path}
+	if err := json.Unmarshal(data, &project); err != nil {
+		return Project{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range project.Hosts {
+		project.Hosts[i].Name = state.NormalizeHostName(project.Hosts[i].Name)
+		project.Hosts[i].Project = dir
+	}
+	return project, nil
+}
+
+// MergeProject replaces the hosts project previously added to snapshot with its current ones.
+// A name already claimed by another project or added directly, or declared twice by project,
+// is a conflict, and every conflict is reported together.
+func MergeProject(snapshot state.Snapshot, project Project) (state.Snapshot, error) {
+	merged := snapshot
+	merged.Hosts = nil
+	owners := make(map[string]string, len(snapshot.Hosts))
+	for _, h := range snapshot.Hosts {
+		if h.Project == project.Dir {
+			continue
+		}
+		merged.Hosts = append(merged.Hosts, h)
+		owners[h.Name] = h.Project
+	}
+	var conflicts []error
+	declared := make(map[string]bool, len(project.Hosts))
+	for _, h := range project.Hosts {
+		if declared[h.Name] {
+			conflicts = append(conflicts, fmt.Errorf("host %q is declared more than once", h.Name))
+			continue
+		}
+		declared[h.Name] = true
+		if owner, taken := owners[h.Name]; taken {
+			if owner == "" {
+				owner = "the global config"
+			}
+			conflicts = append(conflicts, fmt.Errorf("host %q is already claimed by %s", h.Name, owner))
+			continue
+		}
+		owners[h.Name] = project.Dir
+		merged.Hosts = append(merged.Hosts, h)
+	}
+	if len(conflicts) > 0 {
+		return state.Snapshot{}, errors.Join(conflicts...)
+	}
+	if merged.Hosts == nil {
+		merged.Hosts = []state.Host{}
+	}
+	return merged, nil
+}
+
+// RemoveProject drops every host tagged with dir, returning the new snapshot and the names removed.
+func RemoveProject(snapshot state.Snapshot, dir string) (state.Snapshot, []string) {
+	out := snapshot
+	out.Hosts = []state.Host{}
+	var removed []string
+	for _, h := range snapshot.Hosts {
+		if h.Project == dir {
+			removed = append(removed, h.Name)
+			continue
+		}
+		out.Hosts = append(out.Hosts, h)
+	}
+	return out, removed
+}
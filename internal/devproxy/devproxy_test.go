@@ -0,0 +1,150 @@
+package devproxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestServerRoutesByHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "upstream saw "+r.Host+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	ca, err := localca.LoadOrCreateCA(filesystem.OS{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	srv := NewServer(":80", ":8443", ca)
+	if err := srv.Update([]state.Host{
+		{Name: "user", Upstream: upstream.URL},
+		{Name: "secure", Upstream: upstream.URL, TLS: true},
+	}); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://user/hello", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "upstream saw user/hello" {
+		t.Fatalf("unexpected proxy response %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://secure/x?y=1", nil))
+	if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != "https://secure:8443/x?y=1" {
+		t.Fatalf("expected https redirect, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://unknown/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown host, got %d", rec.Code)
+	}
+
+	if _, err := srv.GetCertificate(&tls.ClientHelloInfo{ServerName: "secure"}); err != nil {
+		t.Fatalf("expected certificate for TLS host: %v", err)
+	}
+	if _, err := srv.GetCertificate(&tls.ClientHelloInfo{ServerName: "user"}); err == nil {
+		t.Fatalf("expected no certificate for plain host")
+	}
+
+	if err := srv.Update(nil); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://user/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected routes to be replaced, got %d", rec.Code)
+	}
+}
+
+func TestServerRoutesByPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "default "+r.URL.Path)
+	}))
+	defer upstream.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "api "+r.URL.Path)
+	}))
+	defer api.Close()
+
+	srv := NewServer(":80", ":443", nil)
+	if err := srv.Update([]state.Host{{
+		Name:     "app",
+		Upstream: upstream.URL,
+		Routes:   []state.Route{{Path: "/api", Upstream: api.URL, StripPrefix: true}},
+	}}); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"/api/users": "api /users",
+		"/api":       "api /",
+		"/home":      "default /home",
+	} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://app"+path, nil))
+		if rec.Body.String() != want {
+			t.Fatalf("%s: got %q, want %q", path, rec.Body.String(), want)
+		}
+	}
+}
+
+func TestServerBalancesPool(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "a")
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "b")
+	}))
+	defer failing.Close()
+
+	srv := NewServer(":80", ":443", nil)
+	defer srv.stopHealthChecks()
+	host := state.Host{Name: "api", Upstreams: []string{healthy.URL, failing.URL}, Balancing: state.Balancing{LBPolicy: state.LBRoundRobin}}
+	if err := srv.Update([]state.Host{host}); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	var seen string
+	for range 4 {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api/", nil))
+		seen += rec.Body.String()
+	}
+	if seen != "abab" {
+		t.Fatalf("expected round robin, got %q", seen)
+	}
+
+	host.HealthCheck = &state.HealthCheck{Path: "/health", Interval: "1h"}
+	if err := srv.Update([]state.Host{host}); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	rt, _ := srv.lookup("api")
+	deadline := time.Now().Add(5 * time.Second)
+	for rt.proxy.members[1].healthy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected failing upstream to be marked unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for range 3 {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://api/", nil))
+		if rec.Body.String() != "a" {
+			t.Fatalf("expected only the healthy upstream, got %q", rec.Body.String())
+		}
+	}
+}
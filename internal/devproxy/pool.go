@@ -0,0 +1,198 @@
+package devproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+const (
+	// defaultHealthInterval matches Caddy's health_interval default.
+	defaultHealthInterval = 30 * time.Second
+	healthTimeout         = 5 * time.Second
+	stickyCookie          = "devhosts_lb"
+)
+
+// member is one upstream of a pool.
+type member struct {
+	target    *url.URL
+	transport http.RoundTripper
+	proxy     *httputil.ReverseProxy
+	healthy   atomic.Bool
+	active    atomic.Int64
+}
+
+// pool spreads requests across upstreams according to a Caddy-style lb_policy.
+type pool struct {
+	policy  string
+	members []*member
+	next    atomic.Uint64
+}
+
+func newPool(upstreams []string, b state.Balancing) (*pool, error) {
+	p := &pool{policy: b.LBPolicy}
+	for _, raw := range upstreams {
+		u, err := state.ParseUpstream(raw)
+		if err != nil {
+			return nil, err
+		}
+		m := &member{target: targetURL(u), transport: newTransport(u)}
+		m.proxy = newProxy(m.target, m.transport)
+		m.healthy.Store(true)
+		p.members = append(p.members, m)
+	}
+	return p, nil
+}
+
+// targetURL is the URL requests to u are rewritten to. For unix sockets the host is never
+// dialled; every connection goes to the socket.
+func targetURL(u state.Upstream) *url.URL {
+	if u.Unix() {
+		return &url.URL{Scheme: state.SchemeHTTP, Host: "localhost"}
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Dial()}
+}
+
+// newTransport returns the transport for u, or nil for the default transport.
+func newTransport(u state.Upstream) http.RoundTripper {
+	switch {
+	case u.Unix():
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", u.Socket)
+		}
+		return t
+	case u.HTTPS():
+		// Local dev servers terminating TLS themselves usually have self-signed certificates.
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		return t
+	}
+	return nil
+}
+
+func newProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			// Keep the original Host like Caddy's reverse_proxy does.
+			pr.Out.Host = pr.In.Host
+			pr.SetXForwarded()
+		},
+		Transport: transport,
+	}
+}
+
+// ServeHTTP proxies the request to the upstream the policy selects.
+func (p *pool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	idx := p.pick(r)
+	if idx < 0 {
+		http.Error(w, "devhosts: no healthy upstreams", http.StatusServiceUnavailable)
+		return
+	}
+	if p.policy == state.LBCookie {
+		http.SetCookie(w, &http.Cookie{Name: stickyCookie, Value: strconv.Itoa(idx), Path: "/", HttpOnly: true})
+	}
+	m := p.members[idx]
+	m.active.Add(1)
+	defer m.active.Add(-1)
+	m.proxy.ServeHTTP(w, r)
+}
+
+// pick returns the index of the member to use, or -1 when none is healthy.
+func (p *pool) pick(r *http.Request) int {
+	var healthy []int
+	for i, m := range p.members {
+		if m.healthy.Load() {
+			healthy = append(healthy, i)
+		}
+	}
+	if len(healthy) == 0 {
+		return -1
+	}
+	switch p.policy {
+	case state.LBRoundRobin:
+		return healthy[int((p.next.Add(1)-1)%uint64(len(healthy)))]
+	case state.LBLeastConn:
+		best := healthy[0]
+		for _, i := range healthy[1:] {
+			if p.members[i].active.Load() < p.members[best].active.Load() {
+				best = i
+			}
+		}
+		return best
+	case state.LBFirst:
+		return healthy[0]
+	case state.LBIPHash:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return healthy[hashIndex(host, len(healthy))]
+	case state.LBURIHash:
+		return healthy[hashIndex(r.URL.RequestURI(), len(healthy))]
+	case state.LBCookie:
+		if c, err := r.Cookie(stickyCookie); err == nil {
+			if i, err := strconv.Atoi(c.Value); err == nil && i >= 0 && i < len(p.members) && p.members[i].healthy.Load() {
+				return i
+			}
+		}
+	}
+	return healthy[rand.IntN(len(healthy))]
+}
+
+func hashIndex(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// watch runs active health checks against every member until ctx is cancelled.
+func (p *pool) watch(ctx context.Context, hc state.HealthCheck) {
+	interval := defaultHealthInterval
+	if d, err := time.ParseDuration(hc.Interval); err == nil && d > 0 {
+		interval = d
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.check(ctx, hc.Path)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check marks each member healthy when path answers with a 2xx status.
+func (p *pool) check(ctx context.Context, path string) {
+	for _, m := range p.members {
+		client := &http.Client{Transport: m.transport, Timeout: healthTimeout}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.target.JoinPath(path).String(), nil)
+		if err != nil {
+			m.healthy.Store(false)
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				m.healthy.Store(false)
+			}
+			continue
+		}
+		resp.Body.Close()
+		m.healthy.Store(resp.StatusCode >= 200 && resp.StatusCode < 300)
+	}
+}
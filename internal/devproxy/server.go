@@ -0,0 +1,209 @@
+// Package devproxy implements the built-in reverse proxy used when Caddy is not installed.
+package devproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+type route struct {
+	tls   bool
+	paths []pathRoute
+	proxy *pool
+}
+
+type pathRoute struct {
+	state.Route
+	proxy *pool
+}
+
+// Server routes requests by Host header to the upstreams of the managed hosts.
+type Server struct {
+	HTTPAddr  string
+	HTTPSAddr string
+	CA        *localca.CA
+
+	mu         sync.RWMutex
+	routes     map[string]route
+	stopChecks context.CancelFunc
+}
+
+// NewServer creates a Server listening on the given addresses; an empty address disables that listener.
+func NewServer(httpAddr, httpsAddr string, ca *localca.CA) *Server {
+	return &Server{HTTPAddr: httpAddr, HTTPSAddr: httpsAddr, CA: ca, routes: map[string]route{}}
+}
+
+// Update atomically replaces the routing table with the provided hosts and restarts health
+// checks for hosts that configure them.
+func (s *Server) Update(hosts []state.Host) error {
+	routes := make(map[string]route, len(hosts))
+	var checks []func(context.Context)
+	for _, h := range state.EnabledHosts(hosts) {
+		proxy, err := newPool(h.Pool(), h.Balancing)
+		if err != nil {
+			return fmt.Errorf("host %s upstream: %w", h.Name, err)
+		}
+		if hc := h.HealthCheck; hc != nil {
+			checks = append(checks, func(ctx context.Context) { proxy.watch(ctx, *hc) })
+		}
+		rt := route{tls: h.TLS, proxy: proxy}
+		for _, r := range h.Routes {
+			routeProxy, err := newPool([]string{r.Upstream}, state.Balancing{})
+			if err != nil {
+				return fmt.Errorf("host %s route %s upstream: %w", h.Name, r.Path, err)
+			}
+			rt.paths = append(rt.paths, pathRoute{Route: r, proxy: routeProxy})
+		}
+		routes[h.Name] = rt
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, check := range checks {
+		go check(ctx)
+	}
+	s.mu.Lock()
+	stop := s.stopChecks
+	s.routes, s.stopChecks = routes, cancel
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	return nil
+}
+
+// stopHealthChecks ends the health checks started by the last Update.
+func (s *Server) stopHealthChecks() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopChecks != nil {
+		s.stopChecks()
+		s.stopChecks = nil
+	}
+}
+
+func (s *Server) lookup(name string) (route, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.routes[name]
+	return r, ok
+}
+
+// ServeHTTP dispatches a request to the upstream registered for its Host.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := requestHost(r.Host)
+	rt, ok := s.lookup(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("devhosts: no host named %q", name), http.StatusNotFound)
+		return
+	}
+	if rt.tls && r.TLS == nil && s.HTTPSAddr != "" {
+		http.Redirect(w, r, "https://"+name+portSuffix(s.HTTPSAddr, "443")+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		return
+	}
+	for _, p := range rt.paths {
+		if !strings.HasPrefix(r.URL.Path, p.Path) {
+			continue
+		}
+		if p.StripPrefix {
+			r = r.Clone(r.Context())
+			r.URL.Path = p.StripPath(r.URL.Path)
+			r.URL.RawPath = ""
+		}
+		p.proxy.ServeHTTP(w, r)
+		return
+	}
+	rt.proxy.ServeHTTP(w, r)
+}
+
+// GetCertificate issues leaf certificates for managed TLS hosts only.
+func (s *Server) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	rt, ok := s.lookup(name)
+	if !ok || !rt.tls {
+		return nil, fmt.Errorf("devhosts: no TLS host named %q", name)
+	}
+	if s.CA == nil {
+		return nil, errors.New("devhosts: no local CA configured")
+	}
+	return s.CA.Issue(name)
+}
+
+// Run serves until ctx is cancelled, then shuts the listeners down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	defer s.stopHealthChecks()
+	var servers []*http.Server
+	errCh := make(chan error, 2)
+
+	if s.HTTPAddr != "" {
+		ln, err := net.Listen("tcp", s.HTTPAddr)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+		servers = append(servers, srv)
+		go func() { errCh <- srv.Serve(ln) }()
+	}
+	if s.HTTPSAddr != "" {
+		ln, err := net.Listen("tcp", s.HTTPSAddr)
+		if err != nil {
+			shutdown(servers)
+			return err
+		}
+		srv := &http.Server{
+			Handler:           s,
+			ReadHeaderTimeout: 10 * time.Second,
+			TLSConfig:         &tls.Config{GetCertificate: s.GetCertificate, MinVersion: tls.VersionTLS12},
+		}
+		servers = append(servers, srv)
+		go func() { errCh <- srv.ServeTLS(ln, "", "") }()
+	}
+	if len(servers) == 0 {
+		return errors.New("no listen address configured")
+	}
+
+	select {
+	case <-ctx.Done():
+		shutdown(servers)
+		return nil
+	case err := <-errCh:
+		shutdown(servers)
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+func shutdown(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+func requestHost(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		hostport = host
+	}
+	return strings.ToLower(strings.TrimSuffix(hostport, "."))
+}
+
+// portSuffix returns ":port" for addr unless it is the scheme default.
+func portSuffix(addr, defaultPort string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" || port == defaultPort {
+		return ""
+	}
+	return ":" + port
+}
@@ -0,0 +1,24 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	oldText := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	newText := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	got := Unified("hosts", "hosts (planned)", oldText, newText)
+	expected := "--- hosts\n+++ hosts (planned)\n" +
+		"@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n" +
+		"@@ -10,3 +10,4 @@\n j\n k\n l\n+m\n"
+	if got != expected {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+
+	if got := Unified("a", "b", "same\n", "same\n"); got != "" {
+		t.Fatalf("expected no diff for equal input, got:\n%s", got)
+	}
+
+	got = Unified("new", "new", "", "x\ny\n")
+	if got != "--- new\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n" {
+		t.Fatalf("unexpected diff for new file:\n%s", got)
+	}
+}
@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestParseAndPackRoundTrip(t *testing.T) {
+	msg := Message{
+		ID:        0x1234,
+		Flags:     flagQR | flagAA,
+		Questions: []Question{{Name: "api.user.test", Type: TypeA, Class: ClassIN}},
+		Answers:   []Record{{Name: "api.user.test", Type: TypeA, Class: ClassIN, TTL: 60, Data: []byte{127, 0, 0, 1}}},
+	}
+	wire, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("Pack returned error: %v", err)
+	}
+	parsed, err := Parse(wire)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if parsed.ID != msg.ID || parsed.Questions[0] != msg.Questions[0] || string(parsed.Answers[0].Data) != string(msg.Answers[0].Data) {
+		t.Fatalf("round trip mismatch: %+v", parsed)
+	}
+
+	if _, err := Parse(wire[:len(wire)-3]); err == nil {
+		t.Fatalf("expected truncated message to error")
+	}
+}
+
+func TestParseCompressedName(t *testing.T) {
+	// Two questions; the second is "api" followed by a pointer to "user.test" at offset 12.
+	wire := []byte{0, 1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0,
+		4, 'u', 's', 'e', 'r', 4, 't', 'e', 's', 't', 0, 0, 1, 0, 1,
+		3, 'a', 'p', 'i', 0xC0, 12, 0, 28, 0, 1}
+	parsed, err := Parse(wire)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if len(parsed.Questions) != 2 || parsed.Questions[1].Name != "api.user.test" || parsed.Questions[1].Type != TypeAAAA {
+		t.Fatalf("unexpected questions: %+v", parsed.Questions)
+	}
+
+	loop := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 1, 0, 1}
+	if _, err := Parse(loop); err == nil {
+		t.Fatalf("expected pointer loop to error")
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	srv := NewServer("", "test")
+	srv.Update([]state.Host{{Name: "user"}})
+	cases := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+	}{
+		{"user.test", TypeA, RcodeSuccess, 1},
+		{"api.user.test.", TypeAAAA, RcodeSuccess, 1},
+		{"user", TypeANY, RcodeSuccess, 2},
+		{"deep.api.user", TypeA, RcodeSuccess, 1},
+		{"user.test", 15, RcodeSuccess, 0},
+		{"other.test", TypeA, RcodeNXDomain, 0},
+		{"example.com", TypeA, RcodeRefused, 0},
+	}
+	for _, tc := range cases {
+		resp := srv.Answer(Message{ID: 7, Questions: []Question{{Name: tc.name, Type: tc.qtype, Class: ClassIN}}})
+		if resp.Rcode() != tc.rcode || len(resp.Answers) != tc.answers {
+			t.Errorf("%s type %d: rcode %d answers %d, want %d/%d", tc.name, tc.qtype, resp.Rcode(), len(resp.Answers), tc.rcode, tc.answers)
+		}
+	}
+}
+
+func TestServeOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewServer(conn.LocalAddr().String(), "test")
+	srv.Update([]state.Host{{Name: "user"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Serve(ctx, conn) }()
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+	lookupCtx, lookupCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer lookupCancel()
+	addrs, err := resolver.LookupHost(lookupCtx, "api.user.test")
+	if err != nil {
+		t.Fatalf("LookupHost returned error: %v", err)
+	}
+	sort.Strings(addrs)
+	if strings.Join(addrs, ",") != "127.0.0.1,::1" {
+		t.Fatalf("unexpected addresses: %v", addrs)
+	}
+	if _, err := resolver.LookupHost(lookupCtx, "missing.test"); err == nil {
+		t.Fatalf("expected unmanaged name to fail")
+	}
+}
+
+type recordingRunner struct {
+	calls []string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, name string, args ...string) (cmdutil.Result, error) {
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	return cmdutil.Result{}, nil
+}
+
+func TestResolvedInstaller(t *testing.T) {
+	runner := &recordingRunner{}
+	installer := NewResolvedInstaller(filesystem.OS{}, runner)
+	installer.DropInDir = filepath.Join(t.TempDir(), "resolved.conf.d")
+
+	if _, err := installer.Install(context.Background(), "127.0.0.1:53053", "test"); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+	data, err := os.ReadFile(installer.DropInPath())
+	if err != nil {
+		t.Fatalf("read drop-in: %v", err)
+	}
+	if !strings.Contains(string(data), "DNS=127.0.0.1:53053\nDomains=~test\n") {
+		t.Fatalf("unexpected drop-in:\n%s", data)
+	}
+
+	if _, err := installer.Uninstall(context.Background()); err != nil {
+		t.Fatalf("Uninstall returned error: %v", err)
+	}
+	if _, err := os.Stat(installer.DropInPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected drop-in to be removed, got %v", err)
+	}
+	if len(runner.calls) != 2 || runner.calls[0] != "systemctl restart systemd-resolved" {
+		t.Fatalf("unexpected calls: %v", runner.calls)
+	}
+}
@@ -0,0 +1,199 @@
+// Package dns answers A/AAAA queries for managed hosts from a loopback UDP listener.
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Record types and classes devhosts understands.
+const (
+	TypeA    uint16 = 1
+	TypeAAAA uint16 = 28
+	TypeANY  uint16 = 255
+	ClassIN  uint16 = 1
+)
+
+// Response codes.
+const (
+	RcodeSuccess  = 0
+	RcodeFormErr  = 1
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+)
+
+const (
+	headerLen   = 12
+	flagQR      = 1 << 15
+	flagAA      = 1 << 10
+	flagRD      = 1 << 8
+	opcodeMask  = 0xF << 11
+	maxPointers = 16
+)
+
+var errTruncated = errors.New("dns message truncated")
+
+// Question is a single entry of the question section.
+type Question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// Record is a resource record with raw RDATA.
+type Record struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// Message is the subset of a DNS message devhosts reads and writes.
+type Message struct {
+	ID        uint16
+	Flags     uint16
+	Questions []Question
+	Answers   []Record
+}
+
+// Opcode returns the opcode bits of the header.
+func (m Message) Opcode() int { return int(m.Flags&opcodeMask) >> 11 }
+
+// Rcode returns the response code bits of the header.
+func (m Message) Rcode() int { return int(m.Flags & 0xF) }
+
+// Parse decodes the header and question section; other sections are ignored.
+func Parse(b []byte) (Message, error) {
+	if len(b) < headerLen {
+		return Message{}, errTruncated
+	}
+	msg := Message{
+		ID:    binary.BigEndian.Uint16(b[0:2]),
+		Flags: binary.BigEndian.Uint16(b[2:4]),
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:6]))
+	off := headerLen
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return Message{}, err
+		}
+		if next+4 > len(b) {
+			return Message{}, errTruncated
+		}
+		msg.Questions = append(msg.Questions, Question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next : next+2]),
+			Class: binary.BigEndian.Uint16(b[next+2 : next+4]),
+		})
+		off = next + 4
+	}
+	ancount := int(binary.BigEndian.Uint16(b[6:8]))
+	for i := 0; i < ancount; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return Message{}, err
+		}
+		if next+10 > len(b) {
+			return Message{}, errTruncated
+		}
+		rr := Record{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next : next+2]),
+			Class: binary.BigEndian.Uint16(b[next+2 : next+4]),
+			TTL:   binary.BigEndian.Uint32(b[next+4 : next+8]),
+		}
+		rdlen := int(binary.BigEndian.Uint16(b[next+8 : next+10]))
+		if next+10+rdlen > len(b) {
+			return Message{}, errTruncated
+		}
+		rr.Data = append([]byte(nil), b[next+10:next+10+rdlen]...)
+		msg.Answers = append(msg.Answers, rr)
+		off = next + 10 + rdlen
+	}
+	return msg, nil
+}
+
+// readName decodes a possibly compressed name starting at off and returns the offset after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errTruncated
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, errTruncated
+			}
+			if jumps++; jumps > maxPointers {
+				return "", 0, errors.New("dns name has too many compression pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:off+2]) & 0x3FFF)
+		case length&0xC0 != 0:
+			return "", 0, fmt.Errorf("dns name uses unsupported label type %#x", length&0xC0)
+		default:
+			if off+1+length > len(b) {
+				return "", 0, errTruncated
+			}
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// Pack encodes the message without name compression.
+func (m Message) Pack() ([]byte, error) {
+	b := make([]byte, headerLen, 512)
+	binary.BigEndian.PutUint16(b[0:2], m.ID)
+	binary.BigEndian.PutUint16(b[2:4], m.Flags)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(m.Questions)))
+	binary.BigEndian.PutUint16(b[6:8], uint16(len(m.Answers)))
+	var err error
+	for _, q := range m.Questions {
+		if b, err = appendName(b, q.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, q.Type)
+		b = binary.BigEndian.AppendUint16(b, q.Class)
+	}
+	for _, rr := range m.Answers {
+		if b, err = appendName(b, rr.Name); err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint16(b, rr.Type)
+		b = binary.BigEndian.AppendUint16(b, rr.Class)
+		b = binary.BigEndian.AppendUint32(b, rr.TTL)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rr.Data)))
+		b = append(b, rr.Data...)
+	}
+	return b, nil
+}
+
+func appendName(b []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid dns label in %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// DefaultResolvedDropInDir is where systemd-resolved reads drop-in configuration.
+const DefaultResolvedDropInDir = "/etc/systemd/resolved.conf.d"
+
+const resolvedDropInName = "devhosts.conf"
+
+// ResolvedInstaller routes a pseudo-TLD to the devhosts DNS server through systemd-resolved.
+type ResolvedInstaller struct {
+	FS        filesystem.FS
+	Runner    cmdutil.Runner
+	DropInDir string
+}
+
+// NewResolvedInstaller constructs an installer writing to DefaultResolvedDropInDir.
+func NewResolvedInstaller(fs filesystem.FS, runner cmdutil.Runner) ResolvedInstaller {
+	if fs == nil {
+		fs = filesystem.OS{}
+	}
+	if runner == nil {
+		runner = cmdutil.ExecRunner{}
+	}
+	return ResolvedInstaller{FS: fs, Runner: runner, DropInDir: DefaultResolvedDropInDir}
+}
+
+// DropInPath returns the drop-in file the installer manages.
+func (i ResolvedInstaller) DropInPath() string {
+	dir := i.DropInDir
+	if dir == "" {
+		dir = DefaultResolvedDropInDir
+	}
+	return filepath.Join(dir, resolvedDropInName)
+}
+
+// DropInContent renders the drop-in sending queries for tld to the server at listen.
+func DropInContent(listen, tld string) string {
+	return fmt.Sprintf("# Managed by devhosts; remove with `devhosts dns uninstall`.\n[Resolve]\nDNS=%s\nDomains=~%s\n", listen, tld)
+}
+
+// Install writes the drop-in and restarts systemd-resolved so it takes effect.
+func (i ResolvedInstaller) Install(ctx context.Context, listen, tld string) (cmdutil.Result, error) {
+	path := i.DropInPath()
+	dir := filepath.Dir(path)
+	if err := i.FS.MkdirAll(dir, 0o755); err != nil {
+		return cmdutil.Result{}, system.WrapPermission("mkdir", dir, err)
+	}
+	if err := i.FS.WriteFile(path, []byte(DropInContent(listen, tld)), 0o644); err != nil {
+		return cmdutil.Result{}, system.WrapPermission("write", path, err)
+	}
+	return i.restart(ctx)
+}
+
+// Uninstall removes the drop-in and restarts systemd-resolved.
+func (i ResolvedInstaller) Uninstall(ctx context.Context) (cmdutil.Result, error) {
+	path := i.DropInPath()
+	if err := i.FS.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cmdutil.Result{}, nil
+		}
+		return cmdutil.Result{}, system.WrapPermission("remove", path, err)
+	}
+	return i.restart(ctx)
+}
+
+func (i ResolvedInstaller) restart(ctx context.Context) (cmdutil.Result, error) {
+	if i.Runner == nil {
+		i.Runner = cmdutil.ExecRunner{}
+	}
+	return i.Runner.Run(ctx, "systemctl", "restart", "systemd-resolved")
+}
@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// Defaults used when devhosts.json has no dns section.
+const (
+	DefaultListen = "127.0.0.1:53053"
+	DefaultTLD    = "test"
+	DefaultTTL    = 60
+)
+
+var (
+	loopbackV4 = net.IPv4(127, 0, 0, 1).To4()
+	loopbackV6 = net.IPv6loopback
+)
+
+// Server answers A/AAAA queries for managed names, their subdomains, and the same names
+// under the pseudo-TLD with loopback addresses.
+type Server struct {
+	Addr string
+	TLD  string
+	TTL  uint32
+
+	mu    sync.RWMutex
+	names map[string]struct{}
+}
+
+// NewServer creates a Server for addr and tld, falling back to the package defaults.
+func NewServer(addr, tld string) *Server {
+	if addr == "" {
+		addr = DefaultListen
+	}
+	if tld == "" {
+		tld = DefaultTLD
+	}
+	return &Server{Addr: addr, TLD: strings.ToLower(strings.Trim(tld, ".")), TTL: DefaultTTL, names: map[string]struct{}{}}
+}
+
+// Update replaces the set of names the server answers for.
+func (s *Server) Update(hosts []state.Host) {
+	names := make(map[string]struct{}, len(hosts))
+	for _, h := range state.EnabledHosts(hosts) {
+		names[h.Name] = struct{}{}
+	}
+	s.mu.Lock()
+	s.names = names
+	s.mu.Unlock()
+}
+
+// match reports whether qname is managed and whether it falls under the pseudo-TLD at all.
+func (s *Server) match(qname string) (managed, authoritative bool) {
+	name := strings.ToLower(strings.TrimSuffix(qname, "."))
+	if name == s.TLD {
+		return false, true
+	}
+	if trimmed, ok := strings.CutSuffix(name, "."+s.TLD); ok {
+		name = trimmed
+		authoritative = true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	labels := strings.Split(name, ".")
+	for i := range labels {
+		if _, ok := s.names[strings.Join(labels[i:], ".")]; ok {
+			return true, true
+		}
+	}
+	return false, authoritative
+}
+
+// Answer builds the response for a parsed query.
+func (s *Server) Answer(query Message) Message {
+	resp := Message{
+		ID:        query.ID,
+		Flags:     flagQR | (query.Flags & (opcodeMask | flagRD)),
+		Questions: query.Questions,
+	}
+	if query.Opcode() != 0 {
+		resp.Flags |= RcodeNotImp
+		return resp
+	}
+	if len(query.Questions) != 1 {
+		resp.Flags |= RcodeFormErr
+		return resp
+	}
+
+	q := query.Questions[0]
+	managed, authoritative := s.match(q.Name)
+	switch {
+	case !authoritative:
+		resp.Flags |= RcodeRefused
+		return resp
+	case !managed:
+		resp.Flags |= flagAA | RcodeNXDomain
+		return resp
+	}
+	resp.Flags |= flagAA
+	if q.Class != ClassIN {
+		return resp
+	}
+	if q.Type == TypeA || q.Type == TypeANY {
+		resp.Answers = append(resp.Answers, Record{Name: q.Name, Type: TypeA, Class: ClassIN, TTL: s.TTL, Data: loopbackV4})
+	}
+	if q.Type == TypeAAAA || q.Type == TypeANY {
+		resp.Answers = append(resp.Answers, Record{Name: q.Name, Type: TypeAAAA, Class: ClassIN, TTL: s.TTL, Data: loopbackV6})
+	}
+	return resp
+}
+
+// ListenAndServe binds the UDP address and serves until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, conn)
+}
+
+// Serve answers queries arriving on conn until ctx is cancelled; it closes conn on return.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		var resp Message
+		if query, err := Parse(buf[:n]); err == nil {
+			resp = s.Answer(query)
+		} else if n >= 2 {
+			resp = Message{ID: binary.BigEndian.Uint16(buf[:2]), Flags: flagQR | RcodeFormErr}
+		} else {
+			continue
+		}
+		out, err := resp.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteTo(out, addr)
+	}
+}
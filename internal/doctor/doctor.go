@@ -0,0 +1,435 @@
+// Package doctor diagnoses the environment devhosts depends on and repairs the safe parts.
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// Check names, in the order they run.
+const (
+	CheckCaddy         = "caddy"
+	CheckAdminAPI      = "admin-api"
+	CheckBase          = "base-caddyfile"
+	CheckHostsWritable = "hosts-writable"
+	CheckHostsBlock    = "hosts-block"
+	CheckTempFiles     = "temp-files"
+	CheckCATrust       = "ca-trust"
+	CheckHTTPPort      = "http-port"
+	CheckHTTPSPort     = "https-port"
+)
+
+// Finding outcomes.
+const (
+	StatusOK    = "ok"
+	StatusWarn  = "warn"
+	StatusFail  = "fail"
+	StatusSkip  = "skip"
+	StatusFixed = "fixed"
+)
+
+const defaultTimeout = 3 * time.Second
+
+// Finding is the outcome of one check with a hint on how to resolve it.
+type Finding struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+	// Fixable marks findings that Fix can repair without touching anything outside devhosts' reach.
+	Fixable bool `json:"fixable,omitempty"`
+	fix     func() error
+}
+
+// Failed reports whether the finding is a failure that still needs attention.
+func (f Finding) Failed() bool { return f.Status == StatusFail }
+
+func ok(name, detail string) Finding { return Finding{Name: name, Status: StatusOK, Detail: detail} }
+
+func skip(name, detail string) Finding {
+	return Finding{Name: name, Status: StatusSkip, Detail: detail}
+}
+
+func fail(name string, err error, hint string) Finding {
+	return Finding{Name: name, Status: StatusFail, Detail: err.Error(), Hint: hint}
+}
+
+func (f Finding) withFix(fix func() error) Finding {
+	f.Fixable, f.fix = true, fix
+	return f
+}
+
+// Doctor runs the checks. Zero-valued dependencies fall back to the real system.
+type Doctor struct {
+	FS        filesystem.FS
+	Runner    cmdutil.Runner
+	Caddy     caddy.Manager
+	Hosts     hostsfile.Manager
+	HostsPath string
+	// IncludePath is the backend's managed file, searched for leftover temp files.
+	IncludePath string
+	// CARoot is the root certificate TLS hosts chain to; CARootErr explains why it is empty.
+	CARoot    string
+	CARootErr error
+	// HTTPAddr and HTTPSAddr are the addresses the proxy should own.
+	HTTPAddr    string
+	HTTPSAddr   string
+	LookPath    func(file string) (string, error)
+	SystemRoots func() (*x509.CertPool, error)
+	Timeout     time.Duration
+}
+
+// Run checks the environment for snapshot, whose hosts should already be qualified.
+func (d Doctor) Run(ctx context.Context, snapshot state.Snapshot) []Finding {
+	if d.FS == nil {
+		d.FS = filesystem.OS{}
+	}
+	if d.Runner == nil {
+		d.Runner = cmdutil.ExecRunner{}
+	}
+	if d.LookPath == nil {
+		d.LookPath = exec.LookPath
+	}
+	if d.SystemRoots == nil {
+		d.SystemRoots = x509.SystemCertPool
+	}
+	if d.Timeout == 0 {
+		d.Timeout = defaultTimeout
+	}
+
+	var findings []Finding
+	if snapshot.Backend == "" || snapshot.Backend == state.BackendCaddy {
+		findings = append(findings, d.checkCaddy(ctx), d.checkAdminAPI(ctx, snapshot), d.checkBase(snapshot))
+	} else {
+		reason := "backend is " + snapshot.Backend
+		findings = append(findings, skip(CheckCaddy, reason), skip(CheckAdminAPI, reason), skip(CheckBase, reason))
+	}
+	findings = append(findings,
+		d.checkHostsWritable(),
+		d.checkHostsBlock(snapshot),
+		d.checkTempFiles(snapshot),
+		d.checkCATrust(snapshot),
+		d.checkPort(ctx, CheckHTTPPort, d.HTTPAddr, nil, snapshot),
+		d.checkPort(ctx, CheckHTTPSPort, d.HTTPSAddr, &tls.Config{ServerName: tlsServerName(snapshot), InsecureSkipVerify: true}, snapshot),
+	)
+	return findings
+}
+
+// Fix runs the repair of every fixable finding that did not pass, marking those that succeed
+// as fixed and recording why the others did not.
+func Fix(findings []Finding) []Finding {
+	out := make([]Finding, len(findings))
+	for i, f := range findings {
+		if f.fix != nil && (f.Status == StatusFail || f.Status == StatusWarn) {
+			if err := f.fix(); err != nil {
+				f.Detail = fmt.Sprintf("%s (fix failed: %v)", f.Detail, err)
+			} else {
+				f.Status, f.Hint = StatusFixed, ""
+			}
+		}
+		out[i] = f
+	}
+	return out
+}
+
+func (d Doctor) checkCaddy(ctx context.Context) Finding {
+	if _, err := d.LookPath("caddy"); err != nil {
+		return fail(CheckCaddy, errors.New("caddy not found in PATH"), "install Caddy: https://caddyserver.com/docs/install")
+	}
+	res, err := d.Runner.Run(ctx, "caddy", "version")
+	if err != nil {
+		return fail(CheckCaddy, err, "reinstall Caddy; `caddy version` should print its version")
+	}
+	version := strings.Fields(string(res.Stdout))
+	if len(version) == 0 {
+		return ok(CheckCaddy, "")
+	}
+	return ok(CheckCaddy, version[0])
+}
+
+func (d Doctor) checkAdminAPI(ctx context.Context, snapshot state.Snapshot) Finding {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+	admin := caddy.NewAdminClient(snapshot.AdminAddress, d.Caddy.HTTPClient)
+	if err := admin.Ping(ctx); err != nil {
+		config := snapshot.BaseCaddyfile
+		if snapshot.IncludeFormat == state.IncludeFormatJSON {
+			config = snapshot.IncludeCaddyfile
+		}
+		return fail(CheckAdminAPI, err, fmt.Sprintf("start Caddy with `caddy start --config %s`, or set admin_address in devhosts.json", config))
+	}
+	return ok(CheckAdminAPI, admin.Address)
+}
+
+func (d Doctor) checkBase(snapshot state.Snapshot) Finding {
+	if snapshot.IncludeFormat == state.IncludeFormatJSON {
+		if _, err := d.FS.Stat(snapshot.BaseCaddyfile); err != nil {
+			return fail(CheckBase, err, "point base_caddyfile at the JSON config devhosts merges into")
+		}
+		return ok(CheckBase, "JSON config "+snapshot.BaseCaddyfile)
+	}
+	err := d.Caddy.EnsureBaseReady(snapshot.BaseCaddyfile, snapshot.IncludeCaddyfile, snapshot.Hosts)
+	switch {
+	case err == nil:
+		return ok(CheckBase, "imports "+snapshot.IncludeCaddyfile)
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, caddy.ErrImportMissing), errors.Is(err, caddy.ErrTildeImport):
+		f := fail(CheckBase, err, fmt.Sprintf("add `import %s` to %s", snapshot.IncludeCaddyfile, snapshot.BaseCaddyfile))
+		return f.withFix(func() error {
+			_, err := d.Caddy.FixBase(snapshot.BaseCaddyfile, snapshot.IncludeCaddyfile)
+			return err
+		})
+	default:
+		return fail(CheckBase, err, "edit the base Caddyfile so it parses and leaves managed hosts to devhosts")
+	}
+}
+
+// checkHostsWritable opens the hosts file for writing without changing it; FS has no
+// way to test access short of writing.
+func (d Doctor) checkHostsWritable() Finding {
+	path, err := filesystem.ExpandUser(d.HostsPath)
+	if err != nil {
+		return fail(CheckHostsWritable, err, "")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+		return ok(CheckHostsWritable, path+" is writable")
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return fail(CheckHostsWritable, err, "check that the hosts file path is correct")
+	}
+	if _, lookErr := d.LookPath("sudo"); lookErr != nil {
+		return fail(CheckHostsWritable, fmt.Errorf("%s is not writable and sudo is not available", path),
+			"run devhosts as a user that can write "+path)
+	}
+	return Finding{Name: CheckHostsWritable, Status: StatusWarn, Detail: path + " needs sudo",
+		Hint: "run commands that change hosts with sudo"}
+}
+
+func (d Doctor) checkHostsBlock(snapshot state.Snapshot) Finding {
+	found, err := d.Hosts.CheckBlock(d.HostsPath)
+	if errors.Is(err, hostsfile.ErrMalformedBlock) {
+		return fail(CheckHostsBlock, err, fmt.Sprintf("edit %s to leave one devhosts BEGIN/END pair, then run `devhosts apply`", d.HostsPath))
+	}
+	if err != nil {
+		return fail(CheckHostsBlock, err, "")
+	}
+	managed, err := d.Hosts.ManagedNames(d.HostsPath)
+	if err != nil {
+		return fail(CheckHostsBlock, err, "")
+	}
+
+	want := make(map[string]bool, len(snapshot.Hosts))
+	for _, h := range state.EnabledHosts(snapshot.Hosts) {
+		want[h.Name] = true
+	}
+	var stale []string
+	for _, name := range managed {
+		if !want[name] {
+			stale = append(stale, name)
+		}
+		delete(want, name)
+	}
+	missing := make([]string, 0, len(want))
+	for name := range want {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(missing, ", "))
+	}
+	if len(stale) > 0 {
+		problems = append(problems, "stale "+strings.Join(stale, ", "))
+	}
+	switch {
+	case len(problems) > 0:
+		f := fail(CheckHostsBlock, errors.New(strings.Join(problems, "; ")), "run `devhosts apply`")
+		return f.withFix(func() error {
+			_, err := d.Hosts.Apply(d.HostsPath, snapshot.Hosts)
+			return err
+		})
+	case !found:
+		return ok(CheckHostsBlock, "no hosts managed")
+	}
+	return ok(CheckHostsBlock, fmt.Sprintf("%d name(s) in sync", len(managed)))
+}
+
+// checkTempFiles looks for the temp files an interrupted atomic write leaves next to its target.
+func (d Doctor) checkTempFiles(snapshot state.Snapshot) Finding {
+	targets := []string{d.HostsPath, d.IncludePath}
+	if snapshot.Backend == "" || snapshot.Backend == state.BackendCaddy {
+		targets = append(targets, snapshot.BaseCaddyfile)
+	}
+	var stray []string
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		resolved, err := filesystem.ExpandUser(target)
+		if err != nil {
+			continue
+		}
+		matches, _ := filepath.Glob(resolved + ".devhosts.tmp-*")
+		stray = append(stray, matches...)
+	}
+	if len(stray) == 0 {
+		return ok(CheckTempFiles, "none")
+	}
+	f := Finding{Name: CheckTempFiles, Status: StatusWarn, Detail: strings.Join(stray, ", "),
+		Hint: "left by an interrupted write; delete them"}
+	return f.withFix(func() error {
+		for _, path := range stray {
+			if err := d.FS.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d Doctor) checkCATrust(snapshot state.Snapshot) Finding {
+	usesTLS := false
+	for _, h := range state.EnabledHosts(snapshot.Hosts) {
+		usesTLS = usesTLS || h.TLS
+	}
+	if !usesTLS {
+		return skip(CheckCATrust, "no TLS hosts")
+	}
+	if d.CARoot == "" {
+		err := d.CARootErr
+		if err == nil {
+			err = errors.New("local CA unknown")
+		}
+		return fail(CheckCATrust, err, "")
+	}
+	trustHint := "add " + d.CARoot + " to the system trust store"
+	if snapshot.Backend == "" || snapshot.Backend == state.BackendCaddy {
+		trustHint = "run `caddy trust`"
+	}
+
+	data, err := d.FS.ReadFile(d.CARoot)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Finding{Name: CheckCATrust, Status: StatusWarn, Detail: d.CARoot + " does not exist yet",
+			Hint: "it is created with the first TLS certificate; then " + trustHint}
+	}
+	if err != nil {
+		return fail(CheckCATrust, err, "")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fail(CheckCATrust, fmt.Errorf("%s holds no PEM certificate", d.CARoot), "")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fail(CheckCATrust, fmt.Errorf("parse %s: %w", d.CARoot, err), "")
+	}
+	roots, err := d.SystemRoots()
+	if err != nil {
+		return fail(CheckCATrust, fmt.Errorf("load system roots: %w", err), "")
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots}); err != nil {
+		return fail(CheckCATrust, fmt.Errorf("%s is not trusted by the system", cert.Subject.CommonName), trustHint)
+	}
+	return ok(CheckCATrust, cert.Subject.CommonName+" is trusted")
+}
+
+// checkPort passes when nothing listens on addr or the configured proxy does, judged by
+// the Server header it answers with.
+func (d Doctor) checkPort(ctx context.Context, name, addr string, tlsConfig *tls.Config, snapshot state.Snapshot) Finding {
+	if addr == "" {
+		return skip(name, "no address")
+	}
+	conn, err := net.DialTimeout("tcp", addr, d.Timeout)
+	if err != nil {
+		return ok(name, addr+" is free")
+	}
+	conn.Close()
+
+	expected := proxyServerHeader(snapshot.Backend)
+	server, err := d.serverHeader(ctx, addr, tlsConfig)
+	switch {
+	case err != nil:
+		return Finding{Name: name, Status: StatusWarn, Detail: fmt.Sprintf("%s is in use and did not answer HTTP: %v", addr, err),
+			Hint: "make sure the proxy is what listens on " + addr}
+	case expected == "":
+		return Finding{Name: name, Status: StatusWarn, Detail: fmt.Sprintf("%s is in use (Server: %q)", addr, server),
+			Hint: "make sure the proxy is what listens on " + addr}
+	case strings.HasPrefix(strings.ToLower(server), strings.ToLower(expected)):
+		return ok(name, addr+" is held by "+expected)
+	}
+	owner := server
+	if owner == "" {
+		owner = "an unknown server"
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	return fail(name, fmt.Errorf("%s is held by %s, not %s", addr, owner, expected),
+		fmt.Sprintf("stop whatever listens on port %s (see `sudo lsof -i :%s`)", port, port))
+}
+
+func (d Doctor) serverHeader(ctx context.Context, addr string, tlsConfig *tls.Config) (string, error) {
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	client := &http.Client{
+		Timeout:       d.Timeout,
+		Transport:     &http.Transport{TLSClientConfig: tlsConfig},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, scheme+"://"+addr+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	if tlsConfig != nil && tlsConfig.ServerName != "" {
+		req.Host = tlsConfig.ServerName
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Server"), nil
+}
+
+// proxyServerHeader is the Server header prefix the backend's proxy answers with, or "" when
+// it sends none.
+func proxyServerHeader(backend string) string {
+	switch backend {
+	case "", state.BackendCaddy:
+		return "Caddy"
+	case state.BackendNginx:
+		return "nginx"
+	}
+	return ""
+}
+
+// tlsServerName picks a managed TLS name so the proxy has a certificate to present.
+func tlsServerName(snapshot state.Snapshot) string {
+	for _, h := range state.EnabledHosts(snapshot.Hosts) {
+		if h.TLS {
+			return h.Name
+		}
+	}
+	return "localhost"
+}
@@ -0,0 +1,136 @@
+package doctor
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cdfuller/devhosts/internal/caddy"
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+type versionRunner struct{}
+
+func (versionRunner) Run(ctx context.Context, name string, args ...string) (cmdutil.Result, error) {
+	return cmdutil.Result{Stdout: []byte("v2.8.4 h1:abc\n")}, nil
+}
+
+func TestDoctorFindsAndFixes(t *testing.T) {
+	dir := t.TempDir()
+	caddyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "Caddy")
+	}))
+	defer caddyServer.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	freeAddr := free.Addr().String()
+	free.Close()
+
+	base := filepath.Join(dir, "Caddyfile")
+	include := filepath.Join(dir, "devhosts.caddy")
+	hostsPath := filepath.Join(dir, "hosts")
+	writeFile(t, base, "other {\n}\n")
+	writeFile(t, hostsPath, "127.0.0.1 localhost\n# >>> devhosts BEGIN\n127.0.0.1    old\n# <<< devhosts END\n")
+	writeFile(t, include+".devhosts.tmp-123", "partial")
+
+	ca, err := localca.LoadOrCreateCA(filesystem.OS{}, filepath.Join(dir, "ca"))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	snapshot := state.Snapshot{
+		Hosts:            []state.Host{{Name: "user", Upstream: "http://localhost:8000", TLS: true}},
+		BaseCaddyfile:    base,
+		IncludeCaddyfile: include,
+		AdminAddress:     caddyServer.Listener.Addr().String(),
+	}
+	d := Doctor{
+		Runner:      versionRunner{},
+		Caddy:       caddy.NewManager(filesystem.OS{}, versionRunner{}),
+		Hosts:       hostsfile.NewManager(filesystem.OS{}),
+		HostsPath:   hostsPath,
+		IncludePath: include,
+		CARoot:      ca.CertPath,
+		HTTPAddr:    caddyServer.Listener.Addr().String(),
+		HTTPSAddr:   freeAddr,
+		LookPath: func(file string) (string, error) {
+			if file == "caddy" {
+				return "/usr/bin/caddy", nil
+			}
+			return "", errors.New("not found")
+		},
+		SystemRoots: func() (*x509.CertPool, error) { return x509.NewCertPool(), nil },
+	}
+
+	findings := d.Run(context.Background(), snapshot)
+	expectStatuses(t, findings, map[string]string{
+		CheckCaddy:         StatusOK,
+		CheckAdminAPI:      StatusOK,
+		CheckBase:          StatusFail,
+		CheckHostsWritable: StatusOK,
+		CheckHostsBlock:    StatusFail,
+		CheckTempFiles:     StatusWarn,
+		CheckCATrust:       StatusFail,
+		CheckHTTPPort:      StatusOK,
+		CheckHTTPSPort:     StatusOK,
+	})
+
+	fixed := Fix(findings)
+	expectStatuses(t, fixed, map[string]string{
+		CheckBase:       StatusFixed,
+		CheckHostsBlock: StatusFixed,
+		CheckTempFiles:  StatusFixed,
+		CheckCATrust:    StatusFail,
+	})
+
+	d.SystemRoots = func() (*x509.CertPool, error) { return ca.Pool(), nil }
+	for _, f := range d.Run(context.Background(), snapshot) {
+		if f.Status != StatusOK {
+			t.Fatalf("after fixing, %s is %s: %s", f.Name, f.Status, f.Detail)
+		}
+	}
+}
+
+func TestDoctorRejectsForeignPortOwner(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "Apache")
+	}))
+	defer other.Close()
+	d := Doctor{HTTPAddr: other.Listener.Addr().String()}
+	d.Timeout = defaultTimeout
+	f := d.checkPort(context.Background(), CheckHTTPPort, d.HTTPAddr, nil, state.Snapshot{})
+	if f.Status != StatusFail {
+		t.Fatalf("expected a port held by Apache to fail, got %+v", f)
+	}
+	f = d.checkPort(context.Background(), CheckHTTPPort, d.HTTPAddr, nil, state.Snapshot{Backend: state.BackendTraefik})
+	if f.Status != StatusWarn {
+		t.Fatalf("expected an unidentifiable owner to warn, got %+v", f)
+	}
+}
+
+func expectStatuses(t *testing.T, findings []Finding, want map[string]string) {
+	t.Helper()
+	for _, f := range findings {
+		if status, ok := want[f.Name]; ok && f.Status != status {
+			t.Fatalf("%s: status %s (%s), want %s", f.Name, f.Status, f.Detail, status)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -12,8 +12,13 @@ import (
 type FS interface {
 	ReadFile(path string) ([]byte, error)
 	WriteFile(path string, data []byte, perm fs.FileMode) error
+	// WriteNewFile is WriteFile for a file that must not exist yet; it fails with an error
+	// matching fs.ErrExist when it does.
+	WriteNewFile(path string, data []byte, perm fs.FileMode) error
 	MkdirAll(path string, perm fs.FileMode) error
 	Stat(path string) (fs.FileInfo, error)
+	// ReadDir lists the entries of the directory at path, sorted by name.
+	ReadDir(path string) ([]fs.DirEntry, error)
 	Rename(oldPath, newPath string) error
 	Remove(path string) error
 }
@@ -30,10 +35,27 @@ func (OS) WriteFile(path string, data []byte, perm fs.FileMode) error {
 	return nil
 }
 
+func (OS) WriteNewFile(path string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+	}
+	return err
+}
+
 func (OS) MkdirAll(path string, perm fs.FileMode) error { return os.MkdirAll(path, perm) }
 
 func (OS) Stat(path string) (fs.FileInfo, error) { return os.Stat(path) }
 
+func (OS) ReadDir(path string) ([]fs.DirEntry, error) { return os.ReadDir(path) }
+
 func (OS) Rename(oldPath, newPath string) error { return os.Rename(oldPath, newPath) }
 
 func (OS) Remove(path string) error { return os.Remove(path) }
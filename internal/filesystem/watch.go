@@ -0,0 +1,43 @@
+package filesystem
+
+import (
+	"context"
+	"time"
+)
+
+// Watch polls path every interval and calls onChange whenever its size or modification time
+// changes. It returns when ctx is cancelled.
+func Watch(ctx context.Context, fsys FS, path string, interval time.Duration, onChange func()) {
+	if fsys == nil {
+		fsys = OS{}
+	}
+	last := fingerprint(fsys, path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := fingerprint(fsys, path)
+			if current != last {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	exists  bool
+}
+
+func fingerprint(fsys FS, path string) fileStamp {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime(), exists: true}
+}
@@ -0,0 +1,34 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchDetectsChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devhosts.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int32
+	go Watch(ctx, OS{}, path, 10*time.Millisecond, func() { calls.Add(1) })
+
+	time.Sleep(30 * time.Millisecond)
+	if err := os.WriteFile(path, []byte(`{"version":1}`), 0o644); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls.Load() == 0 {
+		t.Fatalf("expected change to be observed")
+	}
+}
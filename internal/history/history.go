@@ -0,0 +1,166 @@
+// Package history keeps the config snapshots commands replaced, so a change can be listed
+// and undone later.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// DirName is the history directory inside the config's state directory.
+const DirName = "history"
+
+// Limit is how many entries Record keeps; older ones are deleted.
+const Limit = 50
+
+const (
+	version = 1
+	// fileTimeFormat sorts lexically in time order.
+	fileTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Entry records one change to the config: the command that made it and the snapshots on
+// either side.
+type Entry struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Command []string       `json:"command"`
+	Before  state.Snapshot `json:"before"`
+	After   state.Snapshot `json:"after"`
+}
+
+// Changes is the host-level difference between two snapshots.
+type Changes struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Names lists every host the changes touch.
+func (c Changes) Names() []string {
+	names := append(append(append([]string{}, c.Added...), c.Removed...), c.Changed...)
+	sort.Strings(names)
+	return names
+}
+
+// Dir returns the history location for the given state directory.
+func Dir(stateDir string) string {
+	return filepath.Join(stateDir, DirName)
+}
+
+// Record writes e to dir and deletes the entries beyond Limit.
+func Record(fsys filesystem.FS, dir string, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Version = version
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, e.Time.UTC().Format(fileTimeFormat)+".json")
+	plan := managedfile.Plan{Changed: true, Path: path, Content: string(data) + "\n"}
+	if _, err := managedfile.Write(fsys, plan); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	files, err := entryFiles(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > Limit {
+		if err := fsys.Remove(files[0]); err != nil {
+			return system.WrapPermission("remove", files[0], err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// List reads the entries in dir, newest first.
+func List(fsys filesystem.FS, dir string) ([]Entry, error) {
+	files, err := entryFiles(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		data, err := fsys.ReadFile(files[i])
+		if err != nil {
+			return nil, system.WrapPermission("read", files[i], err)
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("parse history %s: %w", files[i], err)
+		}
+		if e.Version != version {
+			return nil, fmt.Errorf("history %s has unsupported version %d", files[i], e.Version)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Changes reports the hosts e added, removed, or changed.
+func (e Entry) Changes() Changes {
+	return Diff(e.Before, e.After)
+}
+
+// Diff compares the hosts of two snapshots by name.
+func Diff(before, after state.Snapshot) Changes {
+	old := make(map[string]state.Host, len(before.Hosts))
+	for _, h := range before.Hosts {
+		old[h.Name] = h
+	}
+	c := Changes{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	seen := make(map[string]bool, len(after.Hosts))
+	for _, h := range after.Hosts {
+		seen[h.Name] = true
+		prev, ok := old[h.Name]
+		switch {
+		case !ok:
+			c.Added = append(c.Added, h.Name)
+		case !reflect.DeepEqual(prev, h):
+			c.Changed = append(c.Changed, h.Name)
+		}
+	}
+	for _, h := range before.Hosts {
+		if !seen[h.Name] {
+			c.Removed = append(c.Removed, h.Name)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	sort.Strings(c.Changed)
+	return c
+}
+
+// entryFiles lists the entry files in dir, oldest first; a missing dir has none.
+func entryFiles(fsys filesystem.FS, dir string) ([]string, error) {
+	entries, err := fsys.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, system.WrapPermission("read", dir, err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,53 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestRecordKeepsNewestEntries(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range Limit + 3 {
+		e := Entry{Time: start.Add(time.Duration(i) * time.Second), Command: []string{"add", "user:8000"}}
+		if err := Record(filesystem.OS{}, dir, e); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+	entries, err := List(filesystem.OS{}, dir)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(entries) != Limit {
+		t.Fatalf("got %d entries, want %d", len(entries), Limit)
+	}
+	if newest := start.Add((Limit + 2) * time.Second); !entries[0].Time.Equal(newest) {
+		t.Fatalf("newest entry at %s, want %s", entries[0].Time, newest)
+	}
+	if oldest := start.Add(3 * time.Second); !entries[Limit-1].Time.Equal(oldest) {
+		t.Fatalf("oldest entry at %s, want %s", entries[Limit-1].Time, oldest)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := state.Snapshot{Hosts: []state.Host{
+		{Name: "admin", Upstream: "http://localhost:9000"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	}}
+	after := state.Snapshot{Hosts: []state.Host{
+		{Name: "user", Upstream: "http://localhost:8001"},
+		{Name: "api", Upstream: "http://localhost:7000"},
+	}}
+	got := Diff(before, after)
+	want := Changes{Added: []string{"api"}, Removed: []string{"admin"}, Changed: []string{"user"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff = %+v, want %+v", got, want)
+	}
+	if names := got.Names(); !reflect.DeepEqual(names, []string{"admin", "api", "user"}) {
+		t.Fatalf("Names = %v", names)
+	}
+}
@@ -0,0 +1,124 @@
+package hostsfile
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"strings"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// LoopbackName is a name a hand-written line outside the managed block maps to loopback.
+type LoopbackName struct {
+	Name string
+	Line int
+}
+
+// LoopbackNames lists every name mapped to a loopback address outside the managed block of
+// the hosts file at path, in file order.
+func (m Manager) LoopbackNames(path string) ([]LoopbackName, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.FS.ReadFile(resolved)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, system.WrapPermission("read", resolved, err)
+	}
+	var found []LoopbackName
+	eachUnmanaged(string(data), func(i int, line string) string {
+		for _, name := range loopbackNames(line) {
+			found = append(found, LoopbackName{Name: strings.ToLower(name), Line: i + 1})
+		}
+		return line
+	})
+	return found, nil
+}
+
+// PlanRelease computes the hosts file with names dropped from the loopback lines outside the
+// managed block. A line left without names is commented out.
+func (m Manager) PlanRelease(path string, names []string) (managedfile.Plan, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return managedfile.Plan{}, err
+	}
+	original, err := m.FS.ReadFile(resolved)
+	if err != nil {
+		return managedfile.Plan{}, system.WrapPermission("read", resolved, err)
+	}
+	release := make(map[string]bool, len(names))
+	for _, name := range names {
+		release[strings.ToLower(name)] = true
+	}
+	content := eachUnmanaged(string(original), func(_ int, line string) string {
+		all := loopbackNames(line)
+		var kept []string
+		for _, name := range all {
+			if !release[strings.ToLower(name)] {
+				kept = append(kept, name)
+			}
+		}
+		switch {
+		case len(kept) == len(all):
+			return line
+		case len(kept) == 0:
+			return "# " + line
+		}
+		entry, comment, _ := strings.Cut(line, "#")
+		out := strings.Fields(entry)[0] + "    " + strings.Join(kept, " ")
+		if comment != "" {
+			out += " #" + comment
+		}
+		return out
+	})
+	return managedfile.Plan{
+		Changed:  content != string(original),
+		Path:     resolved,
+		Previous: original,
+		Existed:  true,
+		Content:  content,
+	}, nil
+}
+
+// eachUnmanaged replaces every line outside the managed block with fn's result; i is the
+// zero-based line index.
+func eachUnmanaged(content string, fn func(i int, line string) string) string {
+	lines := strings.Split(content, newline)
+	inside := false
+	for i, line := range lines {
+		switch {
+		case line == blockStart:
+			inside = true
+		case line == blockEnd:
+			inside = false
+		case !inside:
+			lines[i] = fn(i, line)
+		}
+	}
+	return strings.Join(lines, newline)
+}
+
+// loopbackNames returns the names on a hosts line whose address is loopback.
+func loopbackNames(line string) []string {
+	entry, _, _ := strings.Cut(line, "#")
+	fields := strings.Fields(entry)
+	if len(fields) < 2 {
+		return nil
+	}
+	if ip := net.ParseIP(fields[0]); ip == nil || !ip.IsLoopback() {
+		return nil
+	}
+	return fields[1:]
+}
@@ -0,0 +1,191 @@
+package hostsfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// Backup is a copy of the hosts file Write saved before changing it.
+type Backup struct {
+	Path string
+	// ID is the part of the name after the infix: the timestamp plus a sequence number when
+	// several backups were taken in the same second.
+	ID   string
+	Time time.Time
+	Size int64
+	// HasBlock reports whether the backup had a managed block; Names lists the names in it.
+	HasBlock bool
+	Names    []string
+	seq      int
+}
+
+// Retention decides which backups Prune keeps: the newest Keep, and any younger than MaxAge.
+// A zero field does not keep anything on its own.
+type Retention struct {
+	Keep   int
+	MaxAge time.Duration
+}
+
+// Backups lists the backups of the hosts file at path, newest first.
+func (m Manager) Backups(path string) ([]Backup, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(resolved)
+	entries, err := m.FS.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, system.WrapPermission("read", dir, err)
+	}
+	prefix := filepath.Base(resolved) + managedfile.BackupInfix
+	var matches []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	backups := make([]Backup, 0, len(matches))
+	for _, match := range matches {
+		b, ok := parseBackupName(resolved, match)
+		if !ok {
+			continue
+		}
+		info, err := m.FS.Stat(match)
+		if err != nil {
+			return nil, system.WrapPermission("stat", match, err)
+		}
+		b.Size = info.Size()
+		data, err := m.FS.ReadFile(match)
+		if err != nil {
+			return nil, system.WrapPermission("read", match, err)
+		}
+		b.HasBlock = strings.Contains(string(data), blockStart+newline)
+		b.Names = managedNamesIn(string(data))
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.After(backups[j].Time)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+	return backups, nil
+}
+
+// FindBackup looks a backup of the hosts file at path up by its ID, file name, or full path.
+func (m Manager) FindBackup(path, ref string) (Backup, error) {
+	backups, err := m.Backups(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, b := range backups {
+		if ref == b.ID || ref == b.Path || ref == filepath.Base(b.Path) {
+			return b, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("no backup %q of %s", ref, path)
+}
+
+// PlanRestore computes writing the backup's content over the hosts file at path.
+func (m Manager) PlanRestore(path string, b Backup) (managedfile.Plan, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return managedfile.Plan{}, err
+	}
+	content, err := m.FS.ReadFile(b.Path)
+	if err != nil {
+		return managedfile.Plan{}, system.WrapPermission("read", b.Path, err)
+	}
+	current, readErr := m.FS.ReadFile(resolved)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return managedfile.Plan{}, system.WrapPermission("read", resolved, readErr)
+	}
+	return managedfile.Plan{
+		Changed:  string(current) != string(content),
+		Path:     resolved,
+		Previous: current,
+		Existed:  readErr == nil,
+		Content:  string(content),
+	}, nil
+}
+
+// RestoreBackup writes the backup over the hosts file at path through Write, so the
+// replacement is atomic and the current file is backed up first.
+func (m Manager) RestoreBackup(path string, b Backup) (ApplyResult, error) {
+	plan, err := m.PlanRestore(path, b)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	return m.Write(plan)
+}
+
+// Prune returns the backups of the hosts file at path that policy does not keep, deleting
+// them unless dryRun is set.
+func (m Manager) Prune(path string, policy Retention, dryRun bool) ([]Backup, error) {
+	if policy.Keep <= 0 && policy.MaxAge <= 0 {
+		return nil, errors.New("retention policy must keep a count or an age")
+	}
+	backups, err := m.Backups(path)
+	if err != nil {
+		return nil, err
+	}
+	if m.Clock == nil {
+		m.Clock = realClock{}
+	}
+	now := m.Clock.Now()
+	var pruned []Backup
+	for i, b := range backups {
+		if i < policy.Keep || (policy.MaxAge > 0 && now.Sub(b.Time) < policy.MaxAge) {
+			continue
+		}
+		if !dryRun {
+			if err := m.FS.Remove(b.Path); err != nil {
+				return pruned, system.WrapPermission("remove", b.Path, err)
+			}
+		}
+		pruned = append(pruned, b)
+	}
+	return pruned, nil
+}
+
+// parseBackupName recognizes "<hosts>.devhosts.bak-YYYYMMDD-HHMMSS[-N]". Backup times are
+// written in local time, so they are parsed as such.
+func parseBackupName(resolved, name string) (Backup, bool) {
+	id, ok := strings.CutPrefix(name, resolved+managedfile.BackupInfix)
+	if !ok || len(id) < len(managedfile.BackupTimeFormat) {
+		return Backup{}, false
+	}
+	stamp, suffix := id[:len(managedfile.BackupTimeFormat)], id[len(managedfile.BackupTimeFormat):]
+	t, err := time.ParseInLocation(managedfile.BackupTimeFormat, stamp, time.Local)
+	if err != nil {
+		return Backup{}, false
+	}
+	seq := 1
+	if suffix != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+		if err != nil || !strings.HasPrefix(suffix, "-") || n < 2 {
+			return Backup{}, false
+		}
+		seq = n
+	}
+	return Backup{Path: name, ID: id, Time: t, seq: seq}, true
+}
@@ -0,0 +1,131 @@
+package hostsfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestBackupsWithinOneSecondDoNotCollide(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+		t.Fatalf("write seed: %v", err)
+	}
+	mgr := NewManager(filesystem.OS{})
+	mgr.Clock = fixedClock{t: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}
+
+	first, err := mgr.Apply(hostsPath, []state.Host{{Name: "user"}})
+	if err != nil {
+		t.Fatalf("first Apply: %v", err)
+	}
+	second, err := mgr.Apply(hostsPath, []state.Host{{Name: "admin"}})
+	if err != nil {
+		t.Fatalf("second Apply: %v", err)
+	}
+	if first.BackupPath == second.BackupPath {
+		t.Fatalf("both applies wrote %s", first.BackupPath)
+	}
+
+	backups, err := mgr.Backups(hostsPath)
+	if err != nil {
+		t.Fatalf("Backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("got %d backups, want 2", len(backups))
+	}
+	if backups[0].ID != "20240102-030405-2" || backups[1].ID != "20240102-030405" {
+		t.Fatalf("backups not newest first: %s, %s", backups[0].ID, backups[1].ID)
+	}
+	if !backups[0].HasBlock || len(backups[0].Names) != 1 || backups[0].Names[0] != "user" {
+		t.Fatalf("newest backup summary = %+v, want the block with user", backups[0])
+	}
+	if backups[1].HasBlock {
+		t.Fatalf("oldest backup predates the block: %+v", backups[1])
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	seed := "127.0.0.1 localhost\n"
+	if err := os.WriteFile(hostsPath, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write seed: %v", err)
+	}
+	mgr := NewManager(filesystem.OS{})
+	mgr.Clock = fixedClock{t: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}
+	if _, err := mgr.Apply(hostsPath, []state.Host{{Name: "user"}}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	b, err := mgr.FindBackup(hostsPath, "20240102-030405")
+	if err != nil {
+		t.Fatalf("FindBackup: %v", err)
+	}
+	res, err := mgr.RestoreBackup(hostsPath, b)
+	if err != nil {
+		t.Fatalf("RestoreBackup: %v", err)
+	}
+	data, err := os.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatalf("read hosts: %v", err)
+	}
+	if string(data) != seed {
+		t.Fatalf("hosts = %q, want %q", data, seed)
+	}
+	if res.BackupPath == "" || res.BackupPath == b.Path {
+		t.Fatalf("restore should back up the replaced file to a new name, got %q", res.BackupPath)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.Local)
+	for _, age := range []time.Duration{time.Hour, 2 * 24 * time.Hour, 5 * 24 * time.Hour, 9 * 24 * time.Hour} {
+		name := hostsPath + managedfile.BackupInfix + now.Add(-age).Format(managedfile.BackupTimeFormat)
+		if err := os.WriteFile(name, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+			t.Fatalf("write backup: %v", err)
+		}
+	}
+	mgr := NewManager(filesystem.OS{})
+	mgr.Clock = fixedClock{t: now}
+
+	tests := []struct {
+		name   string
+		policy Retention
+		want   int
+	}{
+		{"keep count", Retention{Keep: 3}, 1},
+		{"keep age", Retention{MaxAge: 3 * 24 * time.Hour}, 2},
+		{"either keeps", Retention{Keep: 1, MaxAge: 6 * 24 * time.Hour}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pruned, err := mgr.Prune(hostsPath, tt.policy, true)
+			if err != nil {
+				t.Fatalf("Prune: %v", err)
+			}
+			if len(pruned) != tt.want {
+				t.Fatalf("pruned %d backups, want %d", len(pruned), tt.want)
+			}
+		})
+	}
+
+	pruned, err := mgr.Prune(hostsPath, Retention{Keep: 2}, false)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	left, err := mgr.Backups(hostsPath)
+	if err != nil {
+		t.Fatalf("Backups: %v", err)
+	}
+	if len(pruned) != 2 || len(left) != 2 || !left[1].Time.Equal(now.Add(-2*24*time.Hour)) {
+		t.Fatalf("pruned %d, left %+v; want the two newest kept", len(pruned), left)
+	}
+	if _, err := mgr.Prune(hostsPath, Retention{}, true); err == nil {
+		t.Fatalf("empty policy should be rejected")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
 	"github.com/cdfuller/devhosts/internal/state"
 	"github.com/cdfuller/devhosts/internal/system"
 )
@@ -20,6 +21,9 @@ const (
 	newline    = "\n"
 )
 
+// ErrMalformedBlock reports devhosts markers in the hosts file that do not pair up.
+var ErrMalformedBlock = errors.New("malformed devhosts block")
+
 // Clock abstracts time for deterministic testing.
 type Clock interface {
 	Now() time.Time
@@ -45,26 +49,31 @@ func NewManager(fs filesystem.FS) Manager {
 
 // Apply ensures the managed block reflects the provided host list.
 func (m Manager) Apply(path string, hosts []state.Host) (ApplyResult, error) {
+	plan, err := m.Plan(path, hosts)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	return m.Write(plan)
+}
+
+// Plan computes the hosts file Apply would write without touching it.
+func (m Manager) Plan(path string, hosts []state.Host) (managedfile.Plan, error) {
 	if m.FS == nil {
 		m.FS = filesystem.OS{}
 	}
-	if m.Clock == nil {
-		m.Clock = realClock{}
-	}
 
 	resolved, err := filesystem.ExpandUser(path)
 	if err != nil {
-		return ApplyResult{}, err
+		return managedfile.Plan{}, err
 	}
 
 	original, readErr := m.FS.ReadFile(resolved)
 	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
-		return ApplyResult{}, system.WrapPermission("read", resolved, readErr)
+		return managedfile.Plan{}, system.WrapPermission("read", resolved, readErr)
 	}
 
 	withoutBlock, _ := stripManagedBlock(string(original))
-	hostnames := extractHostnames(hosts)
-	block := buildBlock(hostnames)
+	block := buildBlock(hosts)
 
 	final := withoutBlock
 	if block != "" {
@@ -77,15 +86,33 @@ func (m Manager) Apply(path string, hosts []state.Host) (ApplyResult, error) {
 		final += newline
 	}
 
-	if string(original) == final {
+	return managedfile.Plan{
+		Changed:  string(original) != final,
+		Path:     resolved,
+		Previous: original,
+		Existed:  readErr == nil,
+		Content:  final,
+	}, nil
+}
+
+// Write applies a plan from Plan, backing up the previous file first.
+func (m Manager) Write(plan managedfile.Plan) (ApplyResult, error) {
+	if !plan.Changed {
 		return ApplyResult{Changed: false}, nil
 	}
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	if m.Clock == nil {
+		m.Clock = realClock{}
+	}
+	resolved, original := plan.Path, plan.Previous
 
 	var backupPath string
-	if !errors.Is(readErr, fs.ErrNotExist) {
-		backupPath = fmt.Sprintf("%s.devhosts.bak-%s", resolved, m.Clock.Now().Format("20060102-150405"))
-		if writeErr := m.FS.WriteFile(backupPath, original, 0o644); writeErr != nil {
-			return ApplyResult{}, system.WrapPermission("backup", backupPath, writeErr)
+	if plan.Existed {
+		var err error
+		if backupPath, err = managedfile.Backup(m.FS, resolved, original, m.Clock.Now()); err != nil {
+			return ApplyResult{}, err
 		}
 	}
 
@@ -95,7 +122,7 @@ func (m Manager) Apply(path string, hosts []state.Host) (ApplyResult, error) {
 	}
 
 	tempPath := fmt.Sprintf("%s.devhosts.tmp-%d", resolved, m.Clock.Now().UnixNano())
-	if writeErr := m.FS.WriteFile(tempPath, []byte(final), 0o644); writeErr != nil {
+	if writeErr := m.FS.WriteFile(tempPath, []byte(plan.Content), 0o644); writeErr != nil {
 		_ = m.FS.Remove(tempPath)
 		return ApplyResult{}, system.WrapPermission("write", tempPath, writeErr)
 	}
@@ -138,10 +165,91 @@ func (m Manager) Restore(res ApplyResult) error {
 	return nil
 }
 
+// ManagedNames returns the names listed inside the managed block of the hosts file at path.
+func (m Manager) ManagedNames(path string) ([]string, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.FS.ReadFile(resolved)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, system.WrapPermission("read", resolved, err)
+	}
+	return managedNamesIn(string(data)), nil
+}
+
+// managedNamesIn returns the names listed inside the managed block of content.
+func managedNamesIn(content string) []string {
+	var names []string
+	inside := false
+	for _, line := range strings.Split(content, newline) {
+		switch {
+		case line == blockStart:
+			inside = true
+		case line == blockEnd:
+			inside = false
+		case inside:
+			if fields := strings.Fields(line); len(fields) > 1 && !strings.HasPrefix(fields[0], "#") {
+				names = append(names, fields[1:]...)
+			}
+		}
+	}
+	return names
+}
+
+// CheckBlock reports whether the hosts file at path has a managed block, returning
+// ErrMalformedBlock when the markers are nested, unterminated, unmatched, or repeated.
+func (m Manager) CheckBlock(path string) (bool, error) {
+	if m.FS == nil {
+		m.FS = filesystem.OS{}
+	}
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return false, err
+	}
+	data, err := m.FS.ReadFile(resolved)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, system.WrapPermission("read", resolved, err)
+	}
+	blocks := 0
+	inside := false
+	for i, line := range strings.Split(string(data), newline) {
+		switch line {
+		case blockStart:
+			if inside {
+				return true, fmt.Errorf("%w: nested %q on line %d", ErrMalformedBlock, blockStart, i+1)
+			}
+			inside = true
+			blocks++
+		case blockEnd:
+			if !inside {
+				return blocks > 0, fmt.Errorf("%w: unmatched %q on line %d", ErrMalformedBlock, blockEnd, i+1)
+			}
+			inside = false
+		}
+	}
+	switch {
+	case inside:
+		return true, fmt.Errorf("%w: %q is never closed", ErrMalformedBlock, blockStart)
+	case blocks > 1:
+		return true, fmt.Errorf("%w: %d blocks", ErrMalformedBlock, blocks)
+	}
+	return blocks == 1, nil
+}
+
 func extractHostnames(hosts []state.Host) []string {
 	names := make([]string, 0, len(hosts))
 	for _, h := range hosts {
-		if h.Name != "" {
+		if h.Name != "" && h.IsEnabled() {
 			names = append(names, h.Name)
 		}
 	}
@@ -149,11 +257,23 @@ func extractHostnames(hosts []state.Host) []string {
 	return names
 }
 
-func buildBlock(names []string) string {
-	if len(names) == 0 {
+// buildBlock writes one loopback line per group, each named group under a comment header.
+func buildBlock(hosts []state.Host) string {
+	var lines []string
+	for _, g := range state.GroupHosts(hosts) {
+		names := extractHostnames(g.Hosts)
+		if len(names) == 0 {
+			continue
+		}
+		if g.Name != "" {
+			lines = append(lines, "# group: "+g.Name)
+		}
+		lines = append(lines, "127.0.0.1    "+strings.Join(names, " "))
+	}
+	if len(lines) == 0 {
 		return ""
 	}
-	return fmt.Sprintf("%s\n127.0.0.1    %s\n%s\n", blockStart, strings.Join(names, " "), blockEnd)
+	return fmt.Sprintf("%s\n%s\n%s\n", blockStart, strings.Join(lines, newline), blockEnd)
 }
 
 func stripManagedBlock(content string) (string, bool) {
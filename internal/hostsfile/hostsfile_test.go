@@ -1,8 +1,10 @@
 package hostsfile
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -53,6 +55,29 @@ func TestApplyWritesManagedBlock(t *testing.T) {
 	}
 }
 
+func TestExtractHostnamesSkipsDisabled(t *testing.T) {
+	disabled := state.Host{Name: "admin"}
+	disabled.SetEnabled(false)
+	got := extractHostnames([]state.Host{{Name: "user"}, disabled, {Name: "staff"}})
+	if strings.Join(got, " ") != "staff user" {
+		t.Fatalf("unexpected hostnames: %v", got)
+	}
+}
+
+func TestBuildBlockGroups(t *testing.T) {
+	got := buildBlock([]state.Host{
+		{Name: "invoices", Group: "billing"},
+		{Name: "user"},
+		{Name: "api", Group: "billing"},
+		{Name: "old", Group: "legacy"},
+	})
+	expected := "# >>> devhosts BEGIN\n127.0.0.1    user\n# group: billing\n127.0.0.1    api invoices\n" +
+		"# group: legacy\n127.0.0.1    old\n# <<< devhosts END\n"
+	if got != expected {
+		t.Fatalf("unexpected block:\n%s", got)
+	}
+}
+
 func TestApplyRemovesBlockWhenEmpty(t *testing.T) {
 	dir := t.TempDir()
 	hostsPath := filepath.Join(dir, "hosts")
@@ -73,3 +98,59 @@ func TestApplyRemovesBlockWhenEmpty(t *testing.T) {
 		t.Fatalf("expected managed block to be removed, got %s", string(data))
 	}
 }
+
+func TestCheckBlock(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		found     bool
+		malformed bool
+	}{
+		{"no block", "127.0.0.1 localhost\n", false, false},
+		{"well formed", blockStart + "\n127.0.0.1    user\n" + blockEnd + "\n", true, false},
+		{"unterminated", blockStart + "\n127.0.0.1    user\n", true, true},
+		{"nested", blockStart + "\n" + blockStart + "\n" + blockEnd + "\n", true, true},
+		{"unmatched end", blockEnd + "\n", false, true},
+		{"repeated", blockStart + "\n" + blockEnd + "\n" + blockStart + "\n" + blockEnd + "\n", true, true},
+	}
+	mgr := NewManager(filesystem.OS{})
+	for _, tc := range cases {
+		path := filepath.Join(t.TempDir(), "hosts")
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatalf("write hosts: %v", err)
+		}
+		found, err := mgr.CheckBlock(path)
+		if found != tc.found || errors.Is(err, ErrMalformedBlock) != tc.malformed {
+			t.Fatalf("%s: found=%v err=%v", tc.name, found, err)
+		}
+	}
+}
+
+func TestLoopbackNamesAndRelease(t *testing.T) {
+	hostsPath := filepath.Join(t.TempDir(), "hosts")
+	seed := "127.0.0.1 localhost\n127.0.0.1 shop blog # dev\n::1 wiki\n10.0.0.5 nas\n" +
+		"# >>> devhosts BEGIN\n127.0.0.1    user\n# <<< devhosts END\n"
+	if err := os.WriteFile(hostsPath, []byte(seed), 0o644); err != nil {
+		t.Fatalf("write seed: %v", err)
+	}
+	mgr := NewManager(filesystem.OS{})
+
+	found, err := mgr.LoopbackNames(hostsPath)
+	if err != nil {
+		t.Fatalf("LoopbackNames returned error: %v", err)
+	}
+	want := []LoopbackName{{"localhost", 1}, {"shop", 2}, {"blog", 2}, {"wiki", 3}}
+	if !reflect.DeepEqual(found, want) {
+		t.Fatalf("LoopbackNames = %+v, want %+v", found, want)
+	}
+
+	plan, err := mgr.PlanRelease(hostsPath, []string{"shop", "wiki", "user"})
+	if err != nil {
+		t.Fatalf("PlanRelease returned error: %v", err)
+	}
+	wantContent := "127.0.0.1 localhost\n127.0.0.1    blog # dev\n# ::1 wiki\n10.0.0.5 nas\n" +
+		"# >>> devhosts BEGIN\n127.0.0.1    user\n# <<< devhosts END\n"
+	if plan.Content != wantContent {
+		t.Fatalf("PlanRelease content = %q, want %q", plan.Content, wantContent)
+	}
+}
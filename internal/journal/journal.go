@@ -0,0 +1,115 @@
+// Package journal records an apply in flight so a crash between writing the hosts file,
+// reloading the proxy, and saving the config can be rolled forward or back afterwards.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// FileName is the journal's name inside the config's state directory.
+const FileName = "journal.json"
+
+const version = 1
+
+// Journal describes one transaction: the files it is about to change and the snapshot it is
+// applying. It is written before the first change and removed once the config is saved.
+type Journal struct {
+	Version int       `json:"version"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	// ConfigPath is the devhosts.json the transaction saves Desired to.
+	ConfigPath string         `json:"config_path"`
+	Desired    state.Snapshot `json:"desired"`
+	// Files holds what every file looked like before the transaction touched it.
+	Files []File `json:"files"`
+}
+
+// File is the pre-transaction state of one file.
+type File struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	// Previous is the file's content when it existed; JSON carries it base64-encoded.
+	Previous []byte `json:"previous,omitempty"`
+}
+
+// FromPlan records the file a plan is about to overwrite.
+func FromPlan(plan managedfile.Plan) File {
+	return File{Path: plan.Path, Existed: plan.Existed, Previous: plan.Previous}
+}
+
+// Path returns the journal location for the given state directory.
+func Path(stateDir string) string {
+	return filepath.Join(stateDir, FileName)
+}
+
+// Begin writes j to path atomically. A journal already there means an earlier transaction was
+// never finished, so Begin refuses to overwrite it.
+func Begin(fsys filesystem.FS, path string, j Journal) error {
+	if _, err := fsys.Stat(path); err == nil {
+		return fmt.Errorf("%s exists: an earlier apply was interrupted; run `devhosts recover`", path)
+	}
+	j.Version = version
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	plan := managedfile.Plan{Changed: true, Path: path, Content: string(data) + "\n"}
+	if _, err := managedfile.Write(fsys, plan); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	return nil
+}
+
+// Load reads the journal at path, returning nil when there is none.
+func Load(fsys filesystem.FS, path string) (*Journal, error) {
+	data, err := fsys.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, system.WrapPermission("read", path, err)
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("parse journal %s: %w", path, err)
+	}
+	if j.Version != version {
+		return nil, fmt.Errorf("journal %s has unsupported version %d", path, j.Version)
+	}
+	return &j, nil
+}
+
+// Commit removes the journal once the transaction is complete.
+func Commit(fsys filesystem.FS, path string) error {
+	if err := fsys.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return system.WrapPermission("remove", path, err)
+	}
+	return nil
+}
+
+// Restore puts every journaled file back to its pre-transaction content, attempting all of
+// them before reporting failures.
+func (j Journal) Restore(fsys filesystem.FS) error {
+	var errs []string
+	for _, f := range j.Files {
+		res := managedfile.UpdateResult{Changed: true, Path: f.Path, Previous: f.Previous, Existed: f.Existed}
+		if err := managedfile.Restore(fsys, res); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
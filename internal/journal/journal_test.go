@@ -0,0 +1,68 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestBeginLoadRestoreCommit(t *testing.T) {
+	dir := t.TempDir()
+	fsys := filesystem.OS{}
+	hosts := filepath.Join(dir, "hosts")
+	created := filepath.Join(dir, "devhosts.caddy")
+	if err := os.WriteFile(hosts, []byte("127.0.0.1 localhost\n"), 0o644); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+	path := Path(dir)
+	j := Journal{
+		PID:     42,
+		Desired: state.Snapshot{Version: 1, Hosts: []state.Host{{Name: "user"}}},
+		Files: []File{
+			{Path: hosts, Existed: true, Previous: []byte("127.0.0.1 localhost\n")},
+			{Path: created},
+		},
+	}
+	if err := Begin(fsys, path, j); err != nil {
+		t.Fatalf("Begin returned error: %v", err)
+	}
+	if err := Begin(fsys, path, j); err == nil || !strings.Contains(err.Error(), "devhosts recover") {
+		t.Fatalf("expected Begin to refuse an unfinished journal, got %v", err)
+	}
+
+	// Simulate the interrupted apply.
+	if err := os.WriteFile(hosts, []byte("127.0.0.1 localhost\n127.0.0.1 user\n"), 0o644); err != nil {
+		t.Fatalf("write hosts: %v", err)
+	}
+	if err := os.WriteFile(created, []byte("user {}\n"), 0o644); err != nil {
+		t.Fatalf("write include: %v", err)
+	}
+
+	loaded, err := Load(fsys, path)
+	if err != nil || loaded == nil {
+		t.Fatalf("Load returned %v, %v", loaded, err)
+	}
+	if loaded.PID != 42 || len(loaded.Desired.Hosts) != 1 {
+		t.Fatalf("unexpected journal: %+v", loaded)
+	}
+	if err := loaded.Restore(fsys); err != nil {
+		t.Fatalf("Restore returned error: %v", err)
+	}
+	if data, _ := os.ReadFile(hosts); string(data) != "127.0.0.1 localhost\n" {
+		t.Fatalf("hosts not restored: %q", data)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("expected file created by the apply to be removed, got %v", err)
+	}
+
+	if err := Commit(fsys, path); err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+	if loaded, err := Load(fsys, path); loaded != nil || err != nil {
+		t.Fatalf("expected no journal after commit, got %v, %v", loaded, err)
+	}
+}
@@ -0,0 +1,241 @@
+// Package localca is a small certificate authority for backends without their own internal issuer.
+package localca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	caLifetime   = 10 * 365 * 24 * time.Hour
+	leafLifetime = 30 * 24 * time.Hour
+	// leafRenewal is how close to expiry a cached leaf may get before it is reissued.
+	leafRenewal = 24 * time.Hour
+)
+
+// CA is a local certificate authority that issues leaf certificates on demand.
+type CA struct {
+	Cert     *x509.Certificate
+	CertPath string
+
+	key    crypto.Signer
+	now    func() time.Time
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// LoadOrCreateCA reads the CA from dir, generating and persisting a new one when absent.
+func LoadOrCreateCA(fsys filesystem.FS, dir string) (*CA, error) {
+	if fsys == nil {
+		fsys = filesystem.OS{}
+	}
+	certPath := filepath.Join(dir, caCertFile)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	certPEM, certErr := fsys.ReadFile(certPath)
+	keyPEM, keyErr := fsys.ReadFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		return parseCA(certPEM, keyPEM, certPath)
+	}
+	if !errors.Is(certErr, fs.ErrNotExist) && certErr != nil {
+		return nil, system.WrapPermission("read", certPath, certErr)
+	}
+	if !errors.Is(keyErr, fs.ErrNotExist) && keyErr != nil {
+		return nil, system.WrapPermission("read", keyPath, keyErr)
+	}
+
+	certPEM, keyPEM, err := generateCA(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := fsys.MkdirAll(dir, 0o700); err != nil {
+		return nil, system.WrapPermission("mkdir", dir, err)
+	}
+	if err := fsys.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return nil, system.WrapPermission("write", keyPath, err)
+	}
+	if err := fsys.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return nil, system.WrapPermission("write", certPath, err)
+	}
+	return parseCA(certPEM, keyPEM, certPath)
+}
+
+func generateCA(now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "devhosts Local CA", Organization: []string{"devhosts"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caLifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func parseCA(certPEM, keyPEM []byte, certPath string) (*CA, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("parse %s: no PEM certificate", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", certPath, err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("parse CA key: no PEM key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse CA key: %w", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("parse CA key: unsupported key type %T", parsed)
+	}
+	return &CA{Cert: cert, CertPath: certPath, key: key, now: time.Now, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// Pool returns a cert pool containing only this CA, for clients that should trust it.
+func (c *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return pool
+}
+
+// Issue returns a cached leaf certificate for name, minting a new one when missing or expiring.
+func (c *CA) Issue(name string) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if leaf, ok := c.leaves[name]; ok && leaf.Leaf != nil && now.Add(leafRenewal).Before(leaf.Leaf.NotAfter) {
+		return leaf, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.Cert, key.Public(), c.key)
+	if err != nil {
+		return nil, fmt.Errorf("issue certificate for %s: %w", name, err)
+	}
+	leafCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	leaf := &tls.Certificate{
+		Certificate: [][]byte{der, c.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leafCert,
+	}
+	c.leaves[name] = leaf
+	return leaf, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// CertPath returns where LoadOrCreateCA keeps the CA certificate inside dir.
+func CertPath(dir string) string {
+	return filepath.Join(dir, caCertFile)
+}
+
+// LeafPaths returns where WriteLeaf stores the certificate and key for name inside dir.
+func LeafPaths(dir, name string) (string, string) {
+	return filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+}
+
+// WriteLeaf makes sure dir holds a current certificate and key for name, for servers that
+// read certificates from disk. Files are left alone until they near expiry.
+func (c *CA) WriteLeaf(fsys filesystem.FS, dir, name string) error {
+	if fsys == nil {
+		fsys = filesystem.OS{}
+	}
+	certPath, keyPath := LeafPaths(dir, name)
+	if existing, err := fsys.ReadFile(certPath); err == nil {
+		if block, _ := pem.Decode(existing); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil && c.now().Add(leafRenewal).Before(cert.NotAfter) {
+				if _, err := fsys.Stat(keyPath); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	leaf, err := c.Issue(name)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(leaf.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var certPEM []byte
+	for _, der := range leaf.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := fsys.MkdirAll(dir, 0o700); err != nil {
+		return system.WrapPermission("mkdir", dir, err)
+	}
+	if err := fsys.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return system.WrapPermission("write", keyPath, err)
+	}
+	if err := fsys.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return system.WrapPermission("write", certPath, err)
+	}
+	return nil
+}
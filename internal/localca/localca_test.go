@@ -0,0 +1,65 @@
+package localca
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+)
+
+func TestLoadOrCreateCAPersists(t *testing.T) {
+	dir := t.TempDir()
+	first, err := LoadOrCreateCA(filesystem.OS{}, dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA returned error: %v", err)
+	}
+	second, err := LoadOrCreateCA(filesystem.OS{}, dir)
+	if err != nil {
+		t.Fatalf("reload CA: %v", err)
+	}
+	if !first.Cert.Equal(second.Cert) {
+		t.Fatalf("expected CA to be reused across loads")
+	}
+
+	leaf, err := second.Issue("user")
+	if err != nil {
+		t.Fatalf("Issue returned error: %v", err)
+	}
+	if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "user", Roots: first.Pool()}); err != nil {
+		t.Fatalf("leaf does not chain to CA: %v", err)
+	}
+	again, err := second.Issue("user")
+	if err != nil || again != leaf {
+		t.Fatalf("expected cached leaf, got %v %v", again, err)
+	}
+}
+
+func TestWriteLeafKeepsCurrentFiles(t *testing.T) {
+	ca, err := LoadOrCreateCA(filesystem.OS{}, t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	dir := t.TempDir()
+	if err := ca.WriteLeaf(filesystem.OS{}, dir, "user"); err != nil {
+		t.Fatalf("WriteLeaf returned error: %v", err)
+	}
+	certPath, keyPath := LeafPaths(dir, "user")
+	first, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	if _, err := os.Stat(keyPath); err != nil {
+		t.Fatalf("expected key file: %v", err)
+	}
+	if err := ca.WriteLeaf(filesystem.OS{}, dir, "user"); err != nil {
+		t.Fatalf("WriteLeaf returned error: %v", err)
+	}
+	second, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	if string(first) != string(second) {
+		t.Fatalf("expected current certificate to be kept")
+	}
+}
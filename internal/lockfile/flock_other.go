@@ -0,0 +1,10 @@
+//go:build !unix
+
+package lockfile
+
+import "os"
+
+// Without flock the lock is a no-op; devhosts only manages hosts files on unix systems.
+func tryLock(*os.File) error { return nil }
+
+func unlock(*os.File) error { return nil }
@@ -0,0 +1,97 @@
+// Package lockfile serializes devhosts processes with an advisory lock on a file next to the
+// config. The lock file records the holder's pid so a waiting process can name it, and is
+// emptied on release. The kernel drops the lock when its holder exits, so a lock file left
+// behind by a crashed run never blocks; the pid still in it identifies the stale holder.
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often Acquire retries a held lock.
+const pollInterval = 50 * time.Millisecond
+
+// errWouldBlock is returned by tryLock when another process holds the lock.
+var errWouldBlock = errors.New("lock held")
+
+// HeldError reports that another process kept the lock past the timeout.
+type HeldError struct {
+	Path string
+	// PID is the holder recorded in the lock file, or 0 when it could not be read.
+	PID int
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("another devhosts is running (lock %s)", e.Path)
+	}
+	return fmt.Sprintf("another devhosts is running (pid %d); wait for it or remove %s if that process is not devhosts", e.PID, e.Path)
+}
+
+// Lock is a held lock; Release gives it up.
+type Lock struct {
+	// StalePID is the pid of an earlier holder that exited without releasing the lock.
+	StalePID int
+	file     *os.File
+}
+
+// Acquire takes the lock at path, creating the file if needed and waiting up to timeout for
+// another holder to release it. A timeout of zero tries once.
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock %s: %w", path, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errWouldBlock) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if !time.Now().Before(deadline) {
+			pid := holder(f)
+			f.Close()
+			return nil, &HeldError{Path: path, PID: pid}
+		}
+		time.Sleep(pollInterval)
+	}
+	lock := &Lock{StalePID: holder(f), file: f}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return lock, nil
+}
+
+// Release unlocks and closes the lock file. It leaves the file in place: removing it would
+// let a waiter lock a file that a newcomer then recreates under a different inode.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	_ = l.file.Truncate(0)
+	err := unlock(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+func holder(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
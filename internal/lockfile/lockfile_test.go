@@ -0,0 +1,56 @@
+package lockfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquireTimesOutWhileHeld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devhosts.json.lock")
+	first, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("Acquire returned error: %v", err)
+	}
+	_, err = Acquire(path, 0)
+	var held *HeldError
+	if !errors.As(err, &held) || held.PID != os.Getpid() {
+		t.Fatalf("expected HeldError naming this process, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+		t.Fatalf("error should name the holder: %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release returned error: %v", err)
+	}
+	second, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("expected lock after release, got %v", err)
+	}
+	if second.StalePID != 0 {
+		t.Fatalf("a released lock should not look stale, got pid %d", second.StalePID)
+	}
+	second.Release()
+}
+
+func TestAcquireTakesOverStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devhosts.json.lock")
+	if err := os.WriteFile(path, []byte("99999999\n"), 0o644); err != nil {
+		t.Fatalf("write stale lock: %v", err)
+	}
+	lock, err := Acquire(path, 0)
+	if err != nil {
+		t.Fatalf("Acquire returned error: %v", err)
+	}
+	defer lock.Release()
+	if lock.StalePID != 99999999 {
+		t.Fatalf("expected stale pid to be reported, got %d", lock.StalePID)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("expected lock file to record this process, got %q", data)
+	}
+}
@@ -0,0 +1,35 @@
+package managedfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+const (
+	// BackupInfix separates a file's path from the timestamp in the name of its backup.
+	BackupInfix = ".devhosts.bak-"
+	// BackupTimeFormat is the timestamp in backup names, in local time.
+	BackupTimeFormat = "20060102-150405"
+)
+
+// Backup saves content as a new backup of path taken at now and returns where it went. A
+// backup never replaces another: later ones within the same second get -2, -3, ... appended.
+func Backup(fsys filesystem.FS, path string, content []byte, now time.Time) (string, error) {
+	base := path + BackupInfix + now.Format(BackupTimeFormat)
+	backupPath := base
+	for seq := 2; ; seq++ {
+		err := fsys.WriteNewFile(backupPath, content, 0o644)
+		if err == nil {
+			return backupPath, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", system.WrapPermission("backup", backupPath, err)
+		}
+		backupPath = fmt.Sprintf("%s-%d", base, seq)
+	}
+}
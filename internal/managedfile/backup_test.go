@@ -0,0 +1,36 @@
+package managedfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+)
+
+func TestBackupFailsWhenTheDirectoryIsUnusable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "hosts")
+	if _, err := Backup(filesystem.OS{}, path, []byte("x"), time.Now()); err == nil {
+		t.Fatal("Backup into a missing directory succeeded")
+	}
+}
+
+func TestBackupNeverReplacesAnExistingBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	first, err := Backup(filesystem.OS{}, path, []byte("first"), now)
+	if err != nil {
+		t.Fatalf("first Backup: %v", err)
+	}
+	second, err := Backup(filesystem.OS{}, path, []byte("second"), now)
+	if err != nil {
+		t.Fatalf("second Backup: %v", err)
+	}
+	if second != first+"-2" {
+		t.Fatalf("second backup at %s, want %s-2", second, first)
+	}
+	if data, err := os.ReadFile(first); err != nil || string(data) != "first" {
+		t.Fatalf("first backup = %q (%v), want it untouched", data, err)
+	}
+}
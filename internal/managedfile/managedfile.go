@@ -0,0 +1,105 @@
+// Package managedfile writes devhosts-owned files atomically and restores them on rollback.
+package managedfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// UpdateResult captures include file update metadata.
+type UpdateResult struct {
+	Changed  bool
+	Path     string
+	Previous []byte
+	Existed  bool
+}
+
+// Plan is the computed next state of a managed file, ready to diff or write.
+type Plan struct {
+	Changed  bool
+	Path     string
+	Previous []byte
+	Existed  bool
+	Content  string
+}
+
+// Update writes content to path atomically and returns the previous contents for rollback.
+func Update(fsys filesystem.FS, path string, content string) (UpdateResult, error) {
+	plan, err := Prepare(fsys, path, content)
+	if err != nil {
+		return UpdateResult{}, err
+	}
+	return Write(fsys, plan)
+}
+
+// Prepare reads the current file and reports whether writing content would change it.
+func Prepare(fsys filesystem.FS, path string, content string) (Plan, error) {
+	resolved, err := filesystem.ExpandUser(path)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	previous, readErr := fsys.ReadFile(resolved)
+	if readErr != nil && !errors.Is(readErr, fs.ErrNotExist) {
+		return Plan{}, system.WrapPermission("read", resolved, readErr)
+	}
+
+	existed := readErr == nil
+	return Plan{
+		Changed:  !existed || string(previous) != content,
+		Path:     resolved,
+		Previous: previous,
+		Existed:  existed,
+		Content:  content,
+	}, nil
+}
+
+// Write applies a plan atomically; unchanged plans leave the file alone.
+func Write(fsys filesystem.FS, plan Plan) (UpdateResult, error) {
+	resolved := plan.Path
+	if !plan.Changed {
+		return UpdateResult{Changed: false, Path: resolved, Previous: plan.Previous, Existed: plan.Existed}, nil
+	}
+
+	dir := filepath.Dir(resolved)
+	if err := fsys.MkdirAll(dir, 0o755); err != nil {
+		return UpdateResult{}, system.WrapPermission("mkdir", dir, err)
+	}
+
+	tempPath := fmt.Sprintf("%s.devhosts.tmp-%d", resolved, time.Now().UnixNano())
+	if err := fsys.WriteFile(tempPath, []byte(plan.Content), 0o644); err != nil {
+		_ = fsys.Remove(tempPath)
+		return UpdateResult{}, system.WrapPermission("write", tempPath, err)
+	}
+
+	if err := fsys.Rename(tempPath, resolved); err != nil {
+		_ = fsys.Remove(tempPath)
+		return UpdateResult{}, system.WrapPermission("replace", resolved, err)
+	}
+
+	return UpdateResult{Changed: true, Path: resolved, Previous: plan.Previous, Existed: plan.Existed}, nil
+}
+
+// Restore attempts to put the file back to its previous bytes.
+func Restore(fsys filesystem.FS, res UpdateResult) error {
+	if res.Path == "" {
+		return nil
+	}
+	if !res.Existed {
+		// File did not exist previously; remove it if present.
+		if err := fsys.Remove(res.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return system.WrapPermission("remove", res.Path, err)
+		}
+		return nil
+	}
+	if err := fsys.WriteFile(res.Path, res.Previous, 0o644); err != nil {
+		return system.WrapPermission("restore", res.Path, err)
+	}
+	return nil
+}
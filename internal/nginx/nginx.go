@@ -0,0 +1,216 @@
+// Package nginx renders server blocks for managed hosts and reloads nginx.
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+// Manager orchestrates nginx include generation and reloads.
+type Manager struct {
+	FS     filesystem.FS
+	Runner cmdutil.Runner
+}
+
+// NewManager constructs a Manager with sensible defaults.
+func NewManager(fs filesystem.FS, runner cmdutil.Runner) Manager {
+	if fs == nil {
+		fs = filesystem.OS{}
+	}
+	if runner == nil {
+		runner = cmdutil.ExecRunner{}
+	}
+	return Manager{FS: fs, Runner: runner}
+}
+
+// GenerateInclude renders one server block per host. TLS hosts listen on 443 with
+// certificates from certDir and redirect plain HTTP there. Hosts with an upstream pool or
+// balancing settings get an upstream block of their own. Hosts in a group follow a "# group:"
+// comment naming it.
+func (m Manager) GenerateInclude(hosts []state.Host, certDir string) (string, error) {
+	hosts = state.EnabledHosts(hosts)
+	if len(hosts) == 0 {
+		return "", nil
+	}
+	blocks := make([]string, 0, len(hosts))
+	for _, g := range state.GroupHosts(hosts) {
+		first := len(blocks)
+		var err error
+		if blocks, err = appendHostBlocks(blocks, g.Hosts, certDir); err != nil {
+			return "", err
+		}
+		if g.Name != "" {
+			blocks[first] = "# group: " + g.Name + "\n" + blocks[first]
+		}
+	}
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+// appendHostBlocks appends the upstream and server blocks of each host to blocks.
+func appendHostBlocks(blocks []string, hosts []state.Host, certDir string) ([]string, error) {
+	for _, h := range hosts {
+		pass := proxyPass(h.Upstream)
+		if len(h.Pool()) > 1 || h.LBPolicy != "" || h.HealthCheck != nil {
+			block, target, err := upstreamBlock(h)
+			if err != nil {
+				return nil, fmt.Errorf("host %s: %w", h.Name, err)
+			}
+			blocks = append(blocks, block)
+			pass = target
+		}
+		if !h.TLS {
+			blocks = append(blocks, serverBlock(h, pass, []string{"    listen 80;"}))
+			continue
+		}
+		blocks = append(blocks, strings.Join([]string{
+			"server {",
+			"    listen 80;",
+			fmt.Sprintf("    server_name %s;", h.Name),
+			"    return 308 https://$host$request_uri;",
+			"}",
+		}, "\n"))
+		certPath, keyPath := localca.LeafPaths(certDir, h.Name)
+		blocks = append(blocks, serverBlock(h, pass, []string{
+			"    listen 443 ssl;",
+			fmt.Sprintf("    ssl_certificate %s;", certPath),
+			fmt.Sprintf("    ssl_certificate_key %s;", keyPath),
+		}))
+	}
+	return blocks, nil
+}
+
+// upstreamBlock renders h's pool as an nginx upstream and returns it with the proxy_pass
+// target naming it. Open-source nginx has no active health checks, so a health check
+// becomes passive failure tracking that skips a failed server for the check interval.
+func upstreamBlock(h state.Host) (string, string, error) {
+	name := "devhosts_" + strings.NewReplacer(".", "_", "-", "_").Replace(h.Name)
+	lines := []string{fmt.Sprintf("upstream %s {", name)}
+	switch h.LBPolicy {
+	case "", state.LBRoundRobin:
+	case state.LBRandom, state.LBLeastConn, state.LBIPHash:
+		lines = append(lines, fmt.Sprintf("    %s;", h.LBPolicy))
+	case state.LBURIHash:
+		lines = append(lines, "    hash $request_uri consistent;")
+	case state.LBFirst:
+	default:
+		return "", "", fmt.Errorf("lb_policy %s is not supported by nginx", h.LBPolicy)
+	}
+	var params string
+	if hc := h.HealthCheck; hc != nil {
+		timeout := 10 * time.Second
+		if d, err := time.ParseDuration(hc.Interval); err == nil {
+			timeout = d
+		}
+		params = fmt.Sprintf(" max_fails=1 fail_timeout=%ds", int(math.Ceil(timeout.Seconds())))
+	}
+	scheme := state.SchemeHTTP
+	for i, raw := range h.Pool() {
+		u, err := state.ParseUpstream(raw)
+		if err != nil {
+			return "", "", err
+		}
+		if u.HTTPS() {
+			scheme = state.SchemeHTTPS
+		}
+		server := u.Dial()
+		if u.Unix() {
+			server = "unix:" + u.Socket
+		}
+		if h.LBPolicy == state.LBFirst && i > 0 {
+			server += " backup"
+		}
+		lines = append(lines, fmt.Sprintf("    server %s%s;", server, params))
+	}
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n"), scheme + "://" + name, nil
+}
+
+func serverBlock(h state.Host, pass string, listen []string) string {
+	lines := []string{"server {"}
+	lines = append(lines, listen...)
+	lines = append(lines, fmt.Sprintf("    server_name %s;", h.Name))
+	for _, r := range h.Routes {
+		lines = append(lines, "")
+		lines = append(lines, location(r.Path, proxyPass(r.Upstream), r.StripPrefix)...)
+	}
+	lines = append(lines, "")
+	lines = append(lines, location("/", pass, false)...)
+	lines = append(lines, "}")
+	return strings.Join(lines, "\n")
+}
+
+func location(path, pass string, strip bool) []string {
+	lines := []string{fmt.Sprintf("    location %s {", path)}
+	if strip {
+		prefix := strings.TrimSuffix(path, "/")
+		lines = append(lines, fmt.Sprintf("        rewrite ^%s/?(.*)$ /$1 break;", regexp.QuoteMeta(prefix)))
+	}
+	lines = append(lines, fmt.Sprintf("        proxy_pass %s;", pass))
+	return append(lines,
+		"        proxy_http_version 1.1;",
+		"        proxy_set_header Host $host;",
+		"        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;",
+		"        proxy_set_header X-Forwarded-Proto $scheme;",
+		"        proxy_set_header Upgrade $http_upgrade;",
+		"        proxy_set_header Connection \"upgrade\";",
+		"    }",
+	)
+}
+
+// proxyPass converts an upstream to nginx's proxy_pass syntax. nginx does not verify
+// HTTPS upstream certificates unless proxy_ssl_verify is on, so https passes through as is.
+func proxyPass(upstream string) string {
+	u, err := state.ParseUpstream(upstream)
+	if err != nil {
+		return upstream
+	}
+	if u.Unix() {
+		return "http://unix:" + u.Socket + ":"
+	}
+	return u.String()
+}
+
+// UpdateInclude writes the include file atomically and returns the previous contents for rollback.
+func (m Manager) UpdateInclude(path string, content string) (managedfile.UpdateResult, error) {
+	plan, err := m.PlanInclude(path, content)
+	if err != nil {
+		return managedfile.UpdateResult{}, err
+	}
+	return m.WriteInclude(plan)
+}
+
+// PlanInclude computes what UpdateInclude would write without touching the include file.
+func (m Manager) PlanInclude(path string, content string) (managedfile.Plan, error) {
+	return managedfile.Prepare(m.FS, path, content)
+}
+
+// WriteInclude writes a plan from PlanInclude atomically.
+func (m Manager) WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error) {
+	return managedfile.Write(m.FS, plan)
+}
+
+// RestoreInclude attempts to put the include file back to its previous bytes.
+func (m Manager) RestoreInclude(res managedfile.UpdateResult) error {
+	return managedfile.Restore(m.FS, res)
+}
+
+// Reload checks the configuration with `nginx -t` and only then signals `nginx -s reload`.
+func (m Manager) Reload(ctx context.Context) (cmdutil.Result, error) {
+	if m.Runner == nil {
+		m.Runner = cmdutil.ExecRunner{}
+	}
+	if res, err := m.Runner.Run(ctx, "nginx", "-t"); err != nil {
+		return res, err
+	}
+	return m.Runner.Run(ctx, "nginx", "-s", "reload")
+}
@@ -0,0 +1,120 @@
+package nginx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cdfuller/devhosts/internal/cmdutil"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+type recordingRunner struct {
+	calls  []string
+	failOn string
+}
+
+func (r *recordingRunner) Run(ctx context.Context, name string, args ...string) (cmdutil.Result, error) {
+	call := strings.Join(append([]string{name}, args...), " ")
+	r.calls = append(r.calls, call)
+	if call == r.failOn {
+		return cmdutil.Result{Stderr: []byte("config test failed")}, errors.New("exit status 1")
+	}
+	return cmdutil.Result{}, nil
+}
+
+func TestGenerateInclude(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, &recordingRunner{})
+	content, err := mgr.GenerateInclude([]state.Host{
+		{Name: "user", Upstream: "http://localhost:8000", TLS: true},
+		{Name: "staff", Upstream: "http://127.0.0.1:9000"},
+		{Name: "rails", Upstream: "unix//tmp/rails.sock"},
+	}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateInclude returned error: %v", err)
+	}
+	for _, want := range []string{
+		"proxy_pass http://unix:/tmp/rails.sock:;",
+		"server_name user;\n    return 308 https://$host$request_uri;",
+		"listen 443 ssl;\n    ssl_certificate /certs/user.pem;\n    ssl_certificate_key /certs/user-key.pem;",
+		"proxy_pass http://localhost:8000;",
+		"listen 80;\n    server_name staff;\n\n    location / {\n        proxy_pass http://127.0.0.1:9000;",
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("include missing %q:\n%s", want, content)
+		}
+	}
+	if content, _ := mgr.GenerateInclude(nil, "/certs"); content != "" {
+		t.Fatalf("expected empty include for no hosts")
+	}
+}
+
+func TestGenerateIncludeGroups(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, &recordingRunner{})
+	content, err := mgr.GenerateInclude([]state.Host{
+		{Name: "api", Upstream: "http://localhost:8001", Group: "billing"},
+		{Name: "invoices", Upstream: "http://localhost:8002", Group: "billing"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateInclude returned error: %v", err)
+	}
+	user := strings.Index(content, "server_name user;")
+	header := strings.Index(content, "# group: billing\nserver {\n    listen 80;\n    server_name api;")
+	invoices := strings.Index(content, "server_name invoices;")
+	if user < 0 || header < user || invoices < header {
+		t.Fatalf("expected ungrouped hosts, then billing under its comment:\n%s", content)
+	}
+	if strings.Count(content, "# group:") != 1 {
+		t.Fatalf("expected one group comment:\n%s", content)
+	}
+}
+
+func TestGenerateIncludePool(t *testing.T) {
+	mgr := NewManager(filesystem.OS{}, &recordingRunner{})
+	host := state.Host{
+		Name:      "api",
+		Upstreams: []string{"http://localhost:8000", "http://localhost:8001"},
+		Balancing: state.Balancing{LBPolicy: state.LBLeastConn, HealthCheck: &state.HealthCheck{Path: "/health", Interval: "5s"}},
+	}
+	content, err := mgr.GenerateInclude([]state.Host{host}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateInclude returned error: %v", err)
+	}
+	for _, want := range []string{
+		"upstream devhosts_api {\n    least_conn;\n    server localhost:8000 max_fails=1 fail_timeout=5s;\n    server localhost:8001 max_fails=1 fail_timeout=5s;\n}",
+		"proxy_pass http://devhosts_api;",
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("include missing %q:\n%s", want, content)
+		}
+	}
+
+	host.LBPolicy = state.LBCookie
+	if _, err := mgr.GenerateInclude([]state.Host{host}, "/certs"); err == nil {
+		t.Fatalf("expected cookie policy to be rejected")
+	}
+}
+
+func TestReloadTestsBeforeSignalling(t *testing.T) {
+	runner := &recordingRunner{}
+	mgr := NewManager(filesystem.OS{}, runner)
+	if _, err := mgr.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload returned error: %v", err)
+	}
+	if strings.Join(runner.calls, "; ") != "nginx -t; nginx -s reload" {
+		t.Fatalf("unexpected calls: %v", runner.calls)
+	}
+
+	runner = &recordingRunner{failOn: "nginx -t"}
+	mgr = NewManager(filesystem.OS{}, runner)
+	res, err := mgr.Reload(context.Background())
+	if err == nil || string(res.Stderr) != "config test failed" {
+		t.Fatalf("expected config test failure, got %v %q", err, res.Stderr)
+	}
+	if len(runner.calls) != 1 {
+		t.Fatalf("expected reload to be skipped, got %v", runner.calls)
+	}
+}
@@ -0,0 +1,64 @@
+// Package procfile reads the process types a Procfile declares and the ports foreman would
+// give them.
+package procfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FileName is the file looked for when no Procfile is named.
+const FileName = "Procfile"
+
+// DefaultBasePort and PortStep follow foreman: the process type at index i gets
+// base + PortStep*i.
+const (
+	DefaultBasePort = 5000
+	PortStep        = 100
+)
+
+// Process is one "name: command" entry of a Procfile.
+type Process struct {
+	Name    string
+	Command string
+	Line    int
+}
+
+var entryPattern = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// Parse reads the entries of a Procfile in file order. Blank lines and # comments are skipped.
+func Parse(data []byte) ([]Process, error) {
+	var procs []Process
+	seen := make(map[string]int)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := entryPattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected \"name: command\"", i+1)
+		}
+		if first, dup := seen[m[1]]; dup {
+			return nil, fmt.Errorf("line %d: process %s already declared on line %d", i+1, m[1], first)
+		}
+		seen[m[1]] = i + 1
+		procs = append(procs, Process{Name: m[1], Command: strings.TrimSpace(m[2]), Line: i + 1})
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("no processes declared")
+	}
+	return procs, nil
+}
+
+// Port returns the port foreman gives the process type at index i.
+func Port(base, i int) int {
+	return base + PortStep*i
+}
+
+// HostName joins an app and a process type into a host name, replacing the characters a
+// directory or process name may hold but a host name may not.
+func HostName(app, process string) string {
+	return strings.NewReplacer("_", "-", ".", "-", " ", "-").Replace(strings.ToLower(app + "-" + process))
+}
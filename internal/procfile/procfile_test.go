@@ -0,0 +1,40 @@
+package procfile
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte("# processes\nweb: bundle exec rails s -p $PORT\n\nworker_1:   sidekiq -q default  \n")
+	procs, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	want := []Process{
+		{Name: "web", Command: "bundle exec rails s -p $PORT", Line: 2},
+		{Name: "worker_1", Command: "sidekiq -q default", Line: 4},
+	}
+	if !reflect.DeepEqual(procs, want) {
+		t.Fatalf("Parse = %+v, want %+v", procs, want)
+	}
+	if got := Port(DefaultBasePort, 1); got != 5100 {
+		t.Fatalf("Port = %d, want 5100", got)
+	}
+	if got := HostName("My_App", "worker_1"); got != "my-app-worker-1" {
+		t.Fatalf("HostName = %q", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"# only a comment\n",
+		"web rails s\n",
+		"web: rails s\nweb: puma\n",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", data)
+		}
+	}
+}
@@ -3,31 +3,193 @@ package state
 import (
 	"errors"
 	"fmt"
-	"net/url"
+	"net"
 	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
+)
+
+// Include formats supported for the managed Caddy output.
+const (
+	IncludeFormatCaddyfile = "caddyfile"
+	IncludeFormatJSON      = "json"
+)
+
+// Proxy backends devhosts can configure.
+const (
+	BackendCaddy   = "caddy"
+	BackendBuiltin = "builtin"
+	BackendNginx   = "nginx"
+	BackendTraefik = "traefik"
 )
 
 var (
-	hostPattern       = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
-	localhostPrefixes = []string{"http://localhost:", "http://127.0.0.1:"}
+	hostPattern      = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+	domainPattern    = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]*[a-z0-9])?)*$`)
+	routePathPattern = regexp.MustCompile(`^/[A-Za-z0-9._~/-]+$`)
+)
+
+// Load-balancing policies accepted in lb_policy; the names follow Caddy's.
+const (
+	LBRandom     = "random"
+	LBRoundRobin = "round_robin"
+	LBLeastConn  = "least_conn"
+	LBFirst      = "first"
+	LBIPHash     = "ip_hash"
+	LBURIHash    = "uri_hash"
+	LBCookie     = "cookie"
 )
 
+var lbPolicies = []string{LBRandom, LBRoundRobin, LBLeastConn, LBFirst, LBIPHash, LBURIHash, LBCookie}
+
 // Host describes a single managed hostname and its upstream target.
 type Host struct {
 	Name     string `json:"name"`
-	Upstream string `json:"upstream"`
-	TLS      bool   `json:"tls,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	// Upstreams replaces Upstream when the host is served by a pool of replicas.
+	Upstreams []string `json:"upstreams,omitempty"`
+	TLS       bool     `json:"tls,omitempty"`
+	Balancing
+	// Routes send path prefixes to other upstreams, tried in order before Upstream.
+	Routes []Route `json:"routes,omitempty"`
+	// Group names the project a host belongs to so selectors such as --group act on all of them.
+	Group string `json:"group,omitempty"`
+	// Project is the directory of the .devhosts.json, or the compose file or Procfile, that
+	// declared the host; empty for hosts added directly.
+	Project string `json:"project,omitempty"`
+	// Ephemeral marks a host registered by `devhosts run` for the lifetime of its child process.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// Enabled set to false keeps the host in the config without routing it; nil means enabled.
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// IsEnabled reports whether the host should be written to the hosts file and proxy config.
+func (h Host) IsEnabled() bool {
+	return h.Enabled == nil || *h.Enabled
+}
+
+// SetEnabled records whether the host is routed, leaving the field unset when it is.
+func (h *Host) SetEnabled(enabled bool) {
+	if enabled {
+		h.Enabled = nil
+		return
+	}
+	h.Enabled = &enabled
+}
+
+// EnabledHosts returns the hosts that are not disabled, in their original order.
+func EnabledHosts(hosts []Host) []Host {
+	out := make([]Host, 0, len(hosts))
+	for _, h := range hosts {
+		if h.IsEnabled() {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// HostGroup is a run of hosts sharing a Group, as rendered under one header.
+type HostGroup struct {
+	Name  string
+	Hosts []Host
+}
+
+// GroupHosts splits hosts by Group: ungrouped hosts first, then each group by name. Hosts keep
+// their relative order within a group.
+func GroupHosts(hosts []Host) []HostGroup {
+	index := map[string]int{}
+	var groups []HostGroup
+	for _, h := range hosts {
+		i, ok := index[h.Group]
+		if !ok {
+			i = len(groups)
+			index[h.Group] = i
+			groups = append(groups, HostGroup{Name: h.Group})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, h)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// Balancing controls how requests spread across a host's upstream pool.
+type Balancing struct {
+	LBPolicy    string       `json:"lb_policy,omitempty"`
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+}
+
+// HealthCheck configures active health checks against every upstream in the pool.
+type HealthCheck struct {
+	Path string `json:"path"`
+	// Interval is a Go duration such as "10s"; backends use their own default when empty.
+	Interval string `json:"interval,omitempty"`
+}
+
+// Pool returns the host's upstreams: Upstreams when set, otherwise Upstream alone.
+func (h Host) Pool() []string {
+	if len(h.Upstreams) > 0 {
+		return h.Upstreams
+	}
+	return []string{h.Upstream}
+}
+
+// SetPool stores upstreams in Upstream when there is one and in Upstreams otherwise.
+func (h *Host) SetPool(upstreams []string) {
+	if len(upstreams) == 1 {
+		h.Upstream, h.Upstreams = upstreams[0], nil
+		return
+	}
+	h.Upstream, h.Upstreams = "", upstreams
+}
+
+// Route sends requests whose path starts with Path to Upstream.
+type Route struct {
+	Path        string `json:"path"`
+	Upstream    string `json:"upstream"`
+	StripPrefix bool   `json:"strip_prefix,omitempty"`
+}
+
+// StripPath removes the route prefix from a request path, keeping it rooted at "/".
+func (r Route) StripPath(path string) string {
+	trimmed := strings.TrimPrefix(path, strings.TrimSuffix(r.Path, "/"))
+	if !strings.HasPrefix(trimmed, "/") {
+		trimmed = "/" + trimmed
+	}
+	return trimmed
 }
 
 // Snapshot represents the desired configuration state persisted to disk.
 type Snapshot struct {
-	Version          int    `json:"version"`
-	Hosts            []Host `json:"hosts"`
-	BaseCaddyfile    string `json:"base_caddyfile"`
-	IncludeCaddyfile string `json:"include_caddyfile"`
+	Version          int              `json:"version"`
+	Hosts            []Host           `json:"hosts"`
+	BaseCaddyfile    string           `json:"base_caddyfile"`
+	IncludeCaddyfile string           `json:"include_caddyfile"`
+	DomainSuffix     string           `json:"domain_suffix,omitempty"`
+	AdminAddress     string           `json:"admin_address,omitempty"`
+	IncludeFormat    string           `json:"include_format,omitempty"`
+	Backend          string           `json:"backend,omitempty"`
+	Nginx            *NginxSettings   `json:"nginx,omitempty"`
+	Traefik          *TraefikSettings `json:"traefik,omitempty"`
+	DNS              *DNSSettings     `json:"dns,omitempty"`
+}
+
+// DNSSettings configures the optional `devhosts dns` responder.
+type DNSSettings struct {
+	Listen string `json:"listen,omitempty"`
+	TLD    string `json:"tld,omitempty"`
+}
+
+// NginxSettings configures the nginx backend.
+type NginxSettings struct {
+	// Include is the file of server blocks devhosts owns; nginx.conf must include it.
+	Include string `json:"include"`
+}
+
+// TraefikSettings configures the Traefik file-provider backend.
+type TraefikSettings struct {
+	// DynamicConfig is the YAML file the file provider watches.
+	DynamicConfig string `json:"dynamic_config"`
 }
 
 // NormalizeHostName trims and lowercases a hostname.
@@ -35,6 +197,50 @@ func NormalizeHostName(raw string) string {
 	return strings.ToLower(strings.TrimSpace(raw))
 }
 
+// ValidName reports whether name is usable as a host name: a bare label of [a-z0-9-].
+func ValidName(name string) bool {
+	return hostPattern.MatchString(name)
+}
+
+// FQDN returns the name clients use for a host, with the domain suffix applied.
+func (s Snapshot) FQDN(name string) string {
+	if s.DomainSuffix == "" {
+		return name
+	}
+	return name + "." + s.DomainSuffix
+}
+
+// BareName strips the domain suffix from a user-supplied name so "user.test" and "user" match.
+func (s Snapshot) BareName(name string) string {
+	if s.DomainSuffix == "" {
+		return name
+	}
+	return strings.TrimSuffix(name, "."+s.DomainSuffix)
+}
+
+// URL returns the address a browser should open for h.
+func (s Snapshot) URL(h Host) string {
+	scheme := "http"
+	if h.TLS {
+		scheme = "https"
+	}
+	return scheme + "://" + s.FQDN(h.Name) + "/"
+}
+
+// Qualified returns a copy of the snapshot whose host names carry the domain suffix, ready
+// to render into the hosts file and proxy configuration. The copy's DomainSuffix is empty so
+// FQDN and URL do not add the suffix a second time.
+func (s Snapshot) Qualified() Snapshot {
+	out := s
+	out.DomainSuffix = ""
+	out.Hosts = make([]Host, len(s.Hosts))
+	for i, h := range s.Hosts {
+		h.Name = s.FQDN(h.Name)
+		out.Hosts[i] = h
+	}
+	return out
+}
+
 // ValidateSnapshot ensures the snapshot adheres to product rules.
 func ValidateSnapshot(s Snapshot) error {
 	if s.Version != 1 {
@@ -46,6 +252,42 @@ func ValidateSnapshot(s Snapshot) error {
 	if s.IncludeCaddyfile == "" {
 		return errors.New("include_caddyfile must be set")
 	}
+	if s.DomainSuffix != "" && !domainPattern.MatchString(s.DomainSuffix) {
+		return fmt.Errorf("domain_suffix %q invalid: must be lowercase dot-separated labels without a leading dot", s.DomainSuffix)
+	}
+	switch s.IncludeFormat {
+	case "", IncludeFormatCaddyfile, IncludeFormatJSON:
+	default:
+		return fmt.Errorf("include_format %q invalid: must be %q or %q", s.IncludeFormat, IncludeFormatCaddyfile, IncludeFormatJSON)
+	}
+	switch s.Backend {
+	case "", BackendCaddy, BackendBuiltin:
+	case BackendNginx:
+		if s.Nginx == nil || s.Nginx.Include == "" {
+			return errors.New("nginx.include must be set for the nginx backend")
+		}
+	case BackendTraefik:
+		if s.Traefik == nil || s.Traefik.DynamicConfig == "" {
+			return errors.New("traefik.dynamic_config must be set for the traefik backend")
+		}
+	default:
+		return fmt.Errorf("backend %q invalid: must be one of %s, %s, %s, %s", s.Backend, BackendCaddy, BackendBuiltin, BackendNginx, BackendTraefik)
+	}
+	if s.DNS != nil {
+		if s.DNS.Listen != "" {
+			if _, _, err := net.SplitHostPort(s.DNS.Listen); err != nil {
+				return fmt.Errorf("dns.listen %q invalid: %w", s.DNS.Listen, err)
+			}
+		}
+		if s.DNS.TLD != "" && !domainPattern.MatchString(s.DNS.TLD) {
+			return fmt.Errorf("dns.tld %q invalid: must be lowercase dot-separated labels", s.DNS.TLD)
+		}
+	}
+	if s.AdminAddress != "" {
+		if _, _, err := net.SplitHostPort(s.AdminAddress); err != nil {
+			return fmt.Errorf("admin_address %q invalid: %w", s.AdminAddress, err)
+		}
+	}
 
 	names := make(map[string]struct{}, len(s.Hosts))
 	for i := range s.Hosts {
@@ -73,51 +315,76 @@ func validateHost(h Host) error {
 	if !hostPattern.MatchString(h.Name) {
 		return errors.New("hostname must match [a-z0-9-]+ and start/end alphanumeric")
 	}
-	if err := validateUpstream(h.Upstream); err != nil {
-		return fmt.Errorf("upstream %q invalid: %w", h.Upstream, err)
+	if h.Group != "" && !hostPattern.MatchString(h.Group) {
+		return fmt.Errorf("group %q invalid: must match [a-z0-9-]+ and start/end alphanumeric", h.Group)
 	}
-	return nil
-}
-
-func validateUpstream(raw string) error {
-	if raw == "" {
-		return errors.New("upstream required")
-	}
-	allowed := false
-	for _, prefix := range localhostPrefixes {
-		if strings.HasPrefix(strings.ToLower(raw), prefix) {
-			allowed = true
-			break
-		}
+	if h.Upstream != "" && len(h.Upstreams) > 0 {
+		return errors.New("set upstream or upstreams, not both")
 	}
-	if !allowed {
-		return errors.New("must target http://localhost:<port> or http://127.0.0.1:<port>")
-	}
-
-	u, err := url.Parse(raw)
-	if err != nil {
+	if err := validatePool(h.Pool()); err != nil {
 		return err
 	}
-	host := strings.ToLower(u.Hostname())
-	if host != "localhost" && host != "127.0.0.1" {
-		return errors.New("hostname must be localhost or 127.0.0.1")
+	if err := validateBalancing(h.Balancing); err != nil {
+		return err
 	}
-	if u.Scheme != "http" {
-		return errors.New("scheme must be http")
+	paths := make(map[string]struct{}, len(h.Routes))
+	for _, r := range h.Routes {
+		if !routePathPattern.MatchString(r.Path) {
+			return fmt.Errorf("route path %q invalid: must start with / and not be the root", r.Path)
+		}
+		if _, exists := paths[r.Path]; exists {
+			return fmt.Errorf("duplicate route path %q", r.Path)
+		}
+		paths[r.Path] = struct{}{}
+		if _, err := ParseUpstream(r.Upstream); err != nil {
+			return fmt.Errorf("route %s upstream %q invalid: %w", r.Path, r.Upstream, err)
+		}
 	}
-	if u.Path != "" && u.Path != "/" {
-		return errors.New("path segments are not supported")
+	return nil
+}
+
+func validatePool(pool []string) error {
+	seen := make(map[string]struct{}, len(pool))
+	https := 0
+	for _, raw := range pool {
+		u, err := ParseUpstream(raw)
+		if err != nil {
+			return fmt.Errorf("upstream %q invalid: %w", raw, err)
+		}
+		if _, exists := seen[u.String()]; exists {
+			return fmt.Errorf("duplicate upstream %q", raw)
+		}
+		seen[u.String()] = struct{}{}
+		if u.HTTPS() {
+			https++
+		}
 	}
-	port := u.Port()
-	if port == "" {
-		return errors.New("port required")
+	if https > 0 && https < len(pool) {
+		return errors.New("upstreams must all use https or none of them")
 	}
-	portNum, err := strconv.Atoi(port)
-	if err != nil {
-		return fmt.Errorf("invalid port: %w", err)
+	return nil
+}
+
+func validateBalancing(b Balancing) error {
+	if b.LBPolicy != "" {
+		valid := false
+		for _, p := range lbPolicies {
+			valid = valid || p == b.LBPolicy
+		}
+		if !valid {
+			return fmt.Errorf("lb_policy %q invalid: must be one of %s", b.LBPolicy, strings.Join(lbPolicies, ", "))
+		}
 	}
-	if portNum < 1 || portNum > 65535 {
-		return fmt.Errorf("port out of range: %d", portNum)
+	if hc := b.HealthCheck; hc != nil {
+		if !strings.HasPrefix(hc.Path, "/") {
+			return fmt.Errorf("health_check.path %q invalid: must start with /", hc.Path)
+		}
+		if hc.Interval != "" {
+			d, err := time.ParseDuration(hc.Interval)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("health_check.interval %q invalid: must be a positive duration such as 10s", hc.Interval)
+			}
+		}
 	}
 	return nil
 }
@@ -53,3 +53,155 @@ func TestValidateUpstreamRejectsExternal(t *testing.T) {
 		t.Fatalf("expected error for non-local upstream")
 	}
 }
+
+func TestDomainSuffix(t *testing.T) {
+	snap := Snapshot{
+		Version:          1,
+		BaseCaddyfile:    "/tmp/Caddyfile",
+		IncludeCaddyfile: "/tmp/devhosts.caddy",
+		DomainSuffix:     "test",
+		Hosts: []Host{
+			{Name: "user", Upstream: "http://localhost:8000", TLS: true},
+			{Name: "admin", Upstream: "http://localhost:9000"},
+		},
+	}
+	if err := ValidateSnapshot(snap); err != nil {
+		t.Fatalf("expected snapshot to be valid: %v", err)
+	}
+	qualified := snap.Qualified()
+	if qualified.Hosts[0].Name != "admin.test" || snap.Hosts[0].Name != "admin" {
+		t.Fatalf("expected qualified copy without mutating the original, got %+v / %+v", qualified.Hosts, snap.Hosts)
+	}
+	if got := qualified.URL(qualified.Hosts[1]); got != "https://user.test/" {
+		t.Fatalf("qualified URL = %q, want the suffix once", got)
+	}
+	if got := snap.URL(snap.Hosts[1]); got != "https://user.test/" {
+		t.Fatalf("unexpected URL %q", got)
+	}
+	if got := snap.BareName("user.test"); got != "user" {
+		t.Fatalf("unexpected bare name %q", got)
+	}
+
+	bare := Snapshot{Hosts: []Host{{Name: "user"}}}
+	if bare.URL(bare.Hosts[0]) != "http://user/" || bare.Qualified().Hosts[0].Name != "user" {
+		t.Fatalf("expected bare names to be unchanged without a suffix")
+	}
+
+	for _, bad := range []string{".test", "Test", "a..b"} {
+		snap.DomainSuffix = bad
+		if err := ValidateSnapshot(snap); err == nil {
+			t.Fatalf("expected domain_suffix %q to be rejected", bad)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	snap := Snapshot{
+		Version:          1,
+		BaseCaddyfile:    "/tmp/Caddyfile",
+		IncludeCaddyfile: "/tmp/devhosts.caddy",
+		Hosts: []Host{
+			{Name: "user", Upstream: "http://localhost:8000", Group: "billing"},
+			{Name: "admin", Upstream: "http://localhost:9000"},
+		},
+	}
+	if err := ValidateSnapshot(snap); err != nil {
+		t.Fatalf("expected snapshot to be valid: %v", err)
+	}
+	groups := GroupHosts(snap.Hosts)
+	if len(groups) != 2 || groups[0].Name != "" || groups[1].Name != "billing" || groups[1].Hosts[0].Name != "user" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+	snap.Hosts[0].Group = "Billing Team"
+	if err := ValidateSnapshot(snap); err == nil {
+		t.Fatalf("expected invalid group to be rejected")
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	snap := Snapshot{
+		Version:          1,
+		BaseCaddyfile:    "/tmp/Caddyfile",
+		IncludeCaddyfile: "/tmp/devhosts.caddy",
+		Hosts: []Host{{
+			Name:     "app",
+			Upstream: "http://localhost:3000",
+			Routes:   []Route{{Path: "/api", Upstream: "http://localhost:5000", StripPrefix: true}},
+		}},
+	}
+	if err := ValidateSnapshot(snap); err != nil {
+		t.Fatalf("expected snapshot to be valid: %v", err)
+	}
+	if got := snap.Hosts[0].Routes[0].StripPath("/api/users"); got != "/users" {
+		t.Fatalf("unexpected stripped path %q", got)
+	}
+
+	for _, routes := range [][]Route{
+		{{Path: "/", Upstream: "http://localhost:5000"}},
+		{{Path: "api", Upstream: "http://localhost:5000"}},
+		{{Path: "/api", Upstream: "http://localhost:5000"}, {Path: "/api", Upstream: "http://localhost:5001"}},
+		{{Path: "/api", Upstream: "https://example.com"}},
+	} {
+		snap.Hosts[0].Routes = routes
+		if err := ValidateSnapshot(snap); err == nil {
+			t.Fatalf("expected error for routes %+v", routes)
+		}
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	cases := []struct {
+		raw  string
+		dial string
+		ok   bool
+	}{
+		{"http://localhost:8000", "localhost:8000", true},
+		{"http://[::1]:8000", "[::1]:8000", true},
+		{"https://LOCALHOST:8443/", "localhost:8443", true},
+		{"unix//tmp/app.sock", "unix//tmp/app.sock", true},
+		{"unix/tmp/app.sock", "", false},
+		{"ftp://localhost:21", "", false},
+		{"http://[::2]:8000", "", false},
+		{"http://localhost", "", false},
+		{"http://localhost:8000/api", "", false},
+	}
+	for _, tc := range cases {
+		u, err := ParseUpstream(tc.raw)
+		if (err == nil) != tc.ok {
+			t.Fatalf("%s: unexpected error state: %v", tc.raw, err)
+		}
+		if tc.ok && u.Dial() != tc.dial {
+			t.Fatalf("%s: dial %q, want %q", tc.raw, u.Dial(), tc.dial)
+		}
+	}
+}
+
+func TestValidatePool(t *testing.T) {
+	base := Snapshot{Version: 1, BaseCaddyfile: "/tmp/Caddyfile", IncludeCaddyfile: "/tmp/devhosts.caddy"}
+	valid := Host{
+		Name:      "api",
+		Upstreams: []string{"http://localhost:8000", "http://localhost:8001"},
+		Balancing: Balancing{LBPolicy: LBRoundRobin, HealthCheck: &HealthCheck{Path: "/health", Interval: "10s"}},
+	}
+	base.Hosts = []Host{valid}
+	if err := ValidateSnapshot(base); err != nil {
+		t.Fatalf("expected pool to be valid: %v", err)
+	}
+
+	invalid := []func(*Host){
+		func(h *Host) { h.Upstream = "http://localhost:9000" },
+		func(h *Host) { h.Upstreams = []string{"http://localhost:8000", "http://localhost:8000"} },
+		func(h *Host) { h.Upstreams = []string{"http://localhost:8000", "https://localhost:8443"} },
+		func(h *Host) { h.LBPolicy = "weighted" },
+		func(h *Host) { h.HealthCheck = &HealthCheck{Path: "health"} },
+		func(h *Host) { h.HealthCheck = &HealthCheck{Path: "/health", Interval: "-1s"} },
+	}
+	for i, mutate := range invalid {
+		h := valid
+		mutate(&h)
+		base.Hosts = []Host{h}
+		if err := ValidateSnapshot(base); err == nil {
+			t.Fatalf("case %d: expected error for %+v", i, h)
+		}
+	}
+}
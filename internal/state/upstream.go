@@ -0,0 +1,99 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Upstream schemes devhosts can proxy to.
+const (
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+	SchemeUnix  = "unix"
+)
+
+// unixPrefix is Caddy's network-address form for unix sockets, e.g. unix//run/app.sock.
+const unixPrefix = "unix/"
+
+var loopbackHosts = map[string]struct{}{"localhost": {}, "127.0.0.1": {}, "::1": {}}
+
+// Upstream is a parsed local upstream: a loopback HTTP(S) address or a unix socket.
+type Upstream struct {
+	Scheme string
+	Host   string
+	Port   int
+	// Socket is the absolute socket path for unix upstreams.
+	Socket string
+}
+
+// ParseUpstream parses and validates an upstream string such as http://localhost:8000,
+// http://[::1]:8000, https://localhost:8443, or unix//run/app.sock.
+func ParseUpstream(raw string) (Upstream, error) {
+	if raw == "" {
+		return Upstream{}, errors.New("upstream required")
+	}
+	if socket, ok := strings.CutPrefix(raw, unixPrefix); ok {
+		if !path.IsAbs(socket) {
+			return Upstream{}, errors.New("unix socket path must be absolute (unix//path/to.sock)")
+		}
+		return Upstream{Scheme: SchemeUnix, Socket: path.Clean(socket)}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Upstream{}, err
+	}
+	if u.Scheme != SchemeHTTP && u.Scheme != SchemeHTTPS {
+		return Upstream{}, errors.New("must target http(s)://localhost, 127.0.0.1 or [::1] with a port, or unix//path.sock")
+	}
+	host := strings.ToLower(u.Hostname())
+	if _, ok := loopbackHosts[host]; !ok {
+		return Upstream{}, errors.New("hostname must be localhost, 127.0.0.1 or [::1]")
+	}
+	if u.Path != "" && u.Path != "/" {
+		return Upstream{}, errors.New("path segments are not supported")
+	}
+	port := u.Port()
+	if port == "" {
+		return Upstream{}, errors.New("port required")
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return Upstream{}, fmt.Errorf("invalid port: %w", err)
+	}
+	if portNum < 1 || portNum > 65535 {
+		return Upstream{}, fmt.Errorf("port out of range: %d", portNum)
+	}
+	return Upstream{Scheme: u.Scheme, Host: host, Port: portNum}, nil
+}
+
+// Unix reports whether the upstream is a unix socket.
+func (u Upstream) Unix() bool {
+	return u.Scheme == SchemeUnix
+}
+
+// HTTPS reports whether the upstream already terminates TLS.
+func (u Upstream) HTTPS() bool {
+	return u.Scheme == SchemeHTTPS
+}
+
+// Dial returns the address in Caddy's network-address form: host:port or unix//path.
+func (u Upstream) Dial() string {
+	if u.Unix() {
+		return unixPrefix + u.Socket
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+}
+
+// String renders the upstream in the canonical form ParseUpstream accepts.
+func (u Upstream) String() string {
+	if u.Unix() {
+		return u.Dial()
+	}
+	return u.Scheme + "://" + u.Dial()
+}
@@ -0,0 +1,245 @@
+// Package status checks every layer between a managed name and its upstream.
+package status
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/state"
+	"github.com/cdfuller/devhosts/internal/system"
+)
+
+// Check names, in the order they run and are displayed.
+const (
+	CheckHosts    = "hosts"
+	CheckDNS      = "dns"
+	CheckUpstream = "upstream"
+	CheckProxy    = "proxy"
+	CheckTLS      = "tls"
+)
+
+// Checks lists every check name in display order.
+var Checks = []string{CheckHosts, CheckDNS, CheckUpstream, CheckProxy, CheckTLS}
+
+// Check outcomes.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+	StatusSkip = "skip"
+)
+
+const defaultTimeout = 3 * time.Second
+
+// Resolver looks names up the way the system resolver does; *net.Resolver satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Check is the outcome of one layer for one host.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Result holds every check for one host.
+type Result struct {
+	Host   string  `json:"host"`
+	URL    string  `json:"url"`
+	Checks []Check `json:"checks"`
+}
+
+// Failed reports whether any check failed.
+func (r Result) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Checker runs the checks. HTTPAddr and HTTPSAddr are where the proxy listens; requests go
+// there directly so a DNS failure does not hide the state of the proxy.
+type Checker struct {
+	Hosts     hostsfile.Manager
+	HostsPath string
+	Resolver  Resolver
+	HTTPAddr  string
+	HTTPSAddr string
+	// Roots holds the CA that should have issued TLS certificates; RootsErr explains why it is nil.
+	Roots    *x509.CertPool
+	RootsErr error
+	Timeout  time.Duration
+}
+
+// LoadRoots reads a PEM certificate file into a pool.
+func LoadRoots(fsys filesystem.FS, path string) (*x509.CertPool, error) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, system.WrapPermission("read", path, err)
+	}
+	pool := x509.NewCertPool()
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// Run checks every host of the qualified snapshot.
+func (c Checker) Run(ctx context.Context, snapshot state.Snapshot) ([]Result, error) {
+	if c.Resolver == nil {
+		c.Resolver = net.DefaultResolver
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+	managed, err := c.Hosts.ManagedNames(c.HostsPath)
+	if err != nil {
+		return nil, err
+	}
+	inBlock := make(map[string]struct{}, len(managed))
+	for _, name := range managed {
+		inBlock[name] = struct{}{}
+	}
+
+	results := make([]Result, 0, len(snapshot.Hosts))
+	for _, h := range state.EnabledHosts(snapshot.Hosts) {
+		res := Result{Host: h.Name, URL: snapshot.URL(h)}
+		if _, ok := inBlock[h.Name]; ok {
+			res.Checks = append(res.Checks, Check{Name: CheckHosts, Status: StatusOK})
+		} else {
+			res.Checks = append(res.Checks, fail(CheckHosts, fmt.Errorf("not in the managed block of %s", c.HostsPath)))
+		}
+		res.Checks = append(res.Checks, c.checkDNS(ctx, h.Name), c.checkUpstream(ctx, h))
+		proxy, peer := c.checkProxy(ctx, h)
+		res.Checks = append(res.Checks, proxy, c.checkTLS(h, peer))
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func fail(name string, err error) Check {
+	return Check{Name: name, Status: StatusFail, Detail: err.Error()}
+}
+
+func (c Checker) checkDNS(ctx context.Context, name string) Check {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	addrs, err := c.Resolver.LookupHost(ctx, name)
+	if err != nil {
+		return fail(CheckDNS, err)
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip == nil || !ip.IsLoopback() {
+			return fail(CheckDNS, fmt.Errorf("resolves to %s, not loopback", strings.Join(addrs, ", ")))
+		}
+	}
+	return Check{Name: CheckDNS, Status: StatusOK, Detail: strings.Join(addrs, ", ")}
+}
+
+func (c Checker) checkUpstream(ctx context.Context, h state.Host) Check {
+	var down []string
+	pool := h.Pool()
+	for _, raw := range pool {
+		u, err := state.ParseUpstream(raw)
+		if err != nil {
+			return fail(CheckUpstream, err)
+		}
+		network := "tcp"
+		if u.Unix() {
+			network = "unix"
+		}
+		address := u.Dial()
+		if u.Unix() {
+			address = u.Socket
+		}
+		dialer := net.Dialer{Timeout: c.Timeout}
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			down = append(down, raw)
+			continue
+		}
+		conn.Close()
+	}
+	if len(down) > 0 {
+		return fail(CheckUpstream, fmt.Errorf("%d/%d not accepting connections: %s", len(down), len(pool), strings.Join(down, ", ")))
+	}
+	return Check{Name: CheckUpstream, Status: StatusOK}
+}
+
+// checkProxy requests the host's URL through the proxy and returns the certificates it presented.
+func (c Checker) checkProxy(ctx context.Context, h state.Host) (Check, []*x509.Certificate) {
+	scheme, addr := "http", c.HTTPAddr
+	if h.TLS {
+		scheme, addr = "https", c.HTTPSAddr
+	}
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	client := &http.Client{
+		Timeout: c.Timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			// Trust is judged by the tls check, which reports a clearer reason.
+			TLSClientConfig: &tls.Config{ServerName: h.Name, InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+h.Name+"/", nil)
+	if err != nil {
+		return fail(CheckProxy, err), nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fail(CheckProxy, err), nil
+	}
+	resp.Body.Close()
+
+	var peer []*x509.Certificate
+	if resp.TLS != nil {
+		peer = resp.TLS.PeerCertificates
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fail(CheckProxy, fmt.Errorf("HTTP %d", resp.StatusCode)), peer
+	}
+	return Check{Name: CheckProxy, Status: StatusOK, Detail: fmt.Sprintf("HTTP %d", resp.StatusCode)}, peer
+}
+
+func (c Checker) checkTLS(h state.Host, peer []*x509.Certificate) Check {
+	if !h.TLS {
+		return Check{Name: CheckTLS, Status: StatusSkip, Detail: "tls disabled"}
+	}
+	if len(peer) == 0 {
+		return fail(CheckTLS, errors.New("no certificate presented"))
+	}
+	if c.Roots == nil {
+		err := c.RootsErr
+		if err == nil {
+			err = errors.New("local CA unknown")
+		}
+		return fail(CheckTLS, err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range peer[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := peer[0].Verify(x509.VerifyOptions{DNSName: h.Name, Roots: c.Roots, Intermediates: intermediates}); err != nil {
+		return fail(CheckTLS, err)
+	}
+	return Check{Name: CheckTLS, Status: StatusOK, Detail: "issued by " + peer[0].Issuer.CommonName}
+}
@@ -0,0 +1,112 @@
+package status
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cdfuller/devhosts/internal/devproxy"
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/hostsfile"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := f[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestCheckerRun(t *testing.T) {
+	dir := t.TempDir()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	hosts := []state.Host{
+		{Name: "user", Upstream: upstream.URL, TLS: true},
+		{Name: "down", Upstream: "http://127.0.0.1:1"},
+	}
+	snapshot := state.Snapshot{Hosts: hosts}
+
+	hostsPath := filepath.Join(dir, "hosts")
+	mgr := hostsfile.NewManager(filesystem.OS{})
+	if _, err := mgr.Apply(hostsPath, hosts[:1]); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	ca, err := localca.LoadOrCreateCA(filesystem.OS{}, filepath.Join(dir, "ca"))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+	proxy := devproxy.NewServer("", "", ca)
+	if err := proxy.Update(hosts); err != nil {
+		t.Fatalf("Update returned error: %v", err)
+	}
+	plain := httptest.NewServer(proxy)
+	defer plain.Close()
+	secure := httptest.NewUnstartedServer(proxy)
+	secure.TLS = &tls.Config{GetCertificate: proxy.GetCertificate}
+	secure.StartTLS()
+	defer secure.Close()
+
+	roots, err := LoadRoots(filesystem.OS{}, ca.CertPath)
+	if err != nil {
+		t.Fatalf("LoadRoots returned error: %v", err)
+	}
+	checker := Checker{
+		Hosts:     mgr,
+		HostsPath: hostsPath,
+		Resolver:  fakeResolver{"user": {"127.0.0.1", "::1"}, "down": {"192.0.2.1"}},
+		HTTPAddr:  plain.Listener.Addr().String(),
+		HTTPSAddr: secure.Listener.Addr().String(),
+		Roots:     roots,
+	}
+	results, err := checker.Run(context.Background(), snapshot)
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	want := map[string][]string{
+		"user": {StatusOK, StatusOK, StatusOK, StatusOK, StatusOK},
+		"down": {StatusFail, StatusFail, StatusFail, StatusFail, StatusSkip},
+	}
+	for _, res := range results {
+		for i, c := range res.Checks {
+			if c.Name != Checks[i] || c.Status != want[res.Host][i] {
+				t.Fatalf("%s %s: status %s (%s), want %s", res.Host, c.Name, c.Status, c.Detail, want[res.Host][i])
+			}
+		}
+	}
+	if results[0].Failed() || !results[1].Failed() {
+		t.Fatalf("unexpected Failed() results: %+v", results)
+	}
+}
+
+func TestCheckerRunURLCarriesSuffixOnce(t *testing.T) {
+	dir := t.TempDir()
+	snapshot := state.Snapshot{DomainSuffix: "test", Hosts: []state.Host{{Name: "user", Upstream: "http://127.0.0.1:1", TLS: true}}}
+	checker := Checker{
+		Hosts:     hostsfile.NewManager(filesystem.OS{}),
+		HostsPath: filepath.Join(dir, "hosts"),
+		Resolver:  fakeResolver{},
+		HTTPAddr:  "127.0.0.1:1",
+		HTTPSAddr: "127.0.0.1:1",
+		Timeout:   100 * time.Millisecond,
+	}
+	results, err := checker.Run(context.Background(), snapshot.Qualified())
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(results) != 1 || results[0].URL != "https://user.test/" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+}
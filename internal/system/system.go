@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"syscall"
 )
 
 // ErrNeedsSudo indicates the caller must retry with elevated permissions.
@@ -35,3 +36,16 @@ func IsErrNeedsSudo(err error) bool {
 	var target *ErrNeedsSudo
 	return errors.As(err, &target)
 }
+
+// ProcessAlive reports whether a process with pid exists, including one owned by another user.
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
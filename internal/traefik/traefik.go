@@ -0,0 +1,197 @@
+// Package traefik renders dynamic configuration for Traefik's file provider.
+package traefik
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/localca"
+	"github.com/cdfuller/devhosts/internal/managedfile"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+const namePrefix = "devhosts-"
+
+// insecureTransport skips certificate checks for HTTPS dev servers with self-signed certificates.
+const insecureTransport = namePrefix + "insecure"
+
+// Manager writes the dynamic configuration file Traefik watches.
+type Manager struct {
+	FS filesystem.FS
+}
+
+// NewManager constructs a Manager backed by the provided filesystem.
+func NewManager(fs filesystem.FS) Manager {
+	if fs == nil {
+		fs = filesystem.OS{}
+	}
+	return Manager{FS: fs}
+}
+
+// routePriority keeps path routers ahead of the host's catch-all router, in declared order.
+const routePriority = 10000
+
+type router struct {
+	name     string
+	rule     string
+	priority int
+	tls      bool
+	// strip is the prefix removed by the router's stripPrefix middleware, if any.
+	strip     string
+	upstreams []string
+	balancing state.Balancing
+	// group is the host's group, named in a comment above the group's first router.
+	group string
+}
+
+func (r router) middleware() string { return r.name + "-strip" }
+
+// GenerateDynamic renders a router and service per host and per path route. TLS routers use
+// certificates from certDir, which are listed under tls.certificates so Traefik serves them by SNI.
+// Traefik cannot proxy to unix sockets and only balances round-robin or by sticky cookie, so
+// other upstreams and policies are rejected. Routers of hosts in a group follow a "# group:"
+// comment naming it.
+func (m Manager) GenerateDynamic(hosts []state.Host, certDir string) (string, error) {
+	hosts = state.EnabledHosts(hosts)
+	if len(hosts) == 0 {
+		return "", nil
+	}
+	var ordered []state.Host
+	for _, g := range state.GroupHosts(hosts) {
+		ordered = append(ordered, g.Hosts...)
+	}
+	var routers []router
+	for _, h := range ordered {
+		hostRule := "Host(`" + h.Name + "`)"
+		for i, r := range h.Routes {
+			rt := router{
+				name:      fmt.Sprintf("%s%s-r%d", namePrefix, h.Name, i+1),
+				rule:      hostRule + " && PathPrefix(`" + r.Path + "`)",
+				priority:  routePriority - i,
+				tls:       h.TLS,
+				upstreams: []string{r.Upstream},
+				group:     h.Group,
+			}
+			if r.StripPrefix {
+				rt.strip = strings.TrimSuffix(r.Path, "/")
+			}
+			routers = append(routers, rt)
+		}
+		routers = append(routers, router{name: namePrefix + h.Name, rule: hostRule, tls: h.TLS, upstreams: h.Pool(), balancing: h.Balancing, group: h.Group})
+	}
+
+	upstreams := make([][]state.Upstream, len(routers))
+	insecure := false
+	for i, rt := range routers {
+		switch rt.balancing.LBPolicy {
+		case "", state.LBRoundRobin, state.LBRandom, state.LBCookie:
+		default:
+			return "", fmt.Errorf("%s: lb_policy %s is not supported by traefik", rt.name, rt.balancing.LBPolicy)
+		}
+		for _, raw := range rt.upstreams {
+			u, err := state.ParseUpstream(raw)
+			if err != nil {
+				return "", fmt.Errorf("%s upstream: %w", rt.name, err)
+			}
+			if u.Unix() {
+				return "", fmt.Errorf("%s upstream %s: traefik cannot proxy to unix sockets", rt.name, raw)
+			}
+			upstreams[i] = append(upstreams[i], u)
+			insecure = insecure || u.HTTPS()
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("http:\n  routers:\n")
+	for i, rt := range routers {
+		if rt.group != "" && (i == 0 || routers[i-1].group != rt.group) {
+			fmt.Fprintf(&b, "    # group: %s\n", rt.group)
+		}
+		fmt.Fprintf(&b, "    %s:\n", rt.name)
+		fmt.Fprintf(&b, "      rule: %s\n", strconv.Quote(rt.rule))
+		fmt.Fprintf(&b, "      service: %s\n", rt.name)
+		if rt.priority != 0 {
+			fmt.Fprintf(&b, "      priority: %d\n", rt.priority)
+		}
+		if rt.strip != "" {
+			fmt.Fprintf(&b, "      middlewares:\n        - %s\n", rt.middleware())
+		}
+		if rt.tls {
+			b.WriteString("      tls: {}\n")
+		}
+	}
+	wroteHeader := false
+	for _, rt := range routers {
+		if rt.strip == "" {
+			continue
+		}
+		if !wroteHeader {
+			b.WriteString("  middlewares:\n")
+			wroteHeader = true
+		}
+		fmt.Fprintf(&b, "    %s:\n      stripPrefix:\n        prefixes:\n          - %s\n", rt.middleware(), strconv.Quote(rt.strip))
+	}
+	b.WriteString("  services:\n")
+	for i, rt := range routers {
+		fmt.Fprintf(&b, "    %s:\n", rt.name)
+		b.WriteString("      loadBalancer:\n        passHostHeader: true\n")
+		if upstreams[i][0].HTTPS() {
+			fmt.Fprintf(&b, "        serversTransport: %s\n", insecureTransport)
+		}
+		if rt.balancing.LBPolicy == state.LBCookie {
+			b.WriteString("        sticky:\n          cookie:\n            name: devhosts_lb\n")
+		}
+		if hc := rt.balancing.HealthCheck; hc != nil {
+			fmt.Fprintf(&b, "        healthCheck:\n          path: %s\n", strconv.Quote(hc.Path))
+			if hc.Interval != "" {
+				fmt.Fprintf(&b, "          interval: %s\n", strconv.Quote(hc.Interval))
+			}
+		}
+		b.WriteString("        servers:\n")
+		for _, u := range upstreams[i] {
+			fmt.Fprintf(&b, "          - url: %s\n", strconv.Quote(u.String()))
+		}
+	}
+	if insecure {
+		fmt.Fprintf(&b, "  serversTransports:\n    %s:\n      insecureSkipVerify: true\n", insecureTransport)
+	}
+
+	var certs []string
+	for _, h := range hosts {
+		if h.TLS {
+			certPath, keyPath := localca.LeafPaths(certDir, h.Name)
+			certs = append(certs, fmt.Sprintf("    - certFile: %s\n      keyFile: %s\n", strconv.Quote(certPath), strconv.Quote(keyPath)))
+		}
+	}
+	if len(certs) > 0 {
+		b.WriteString("tls:\n  certificates:\n")
+		b.WriteString(strings.Join(certs, ""))
+	}
+	return b.String(), nil
+}
+
+// UpdateInclude writes the dynamic config atomically and returns the previous contents for rollback.
+func (m Manager) UpdateInclude(path string, content string) (managedfile.UpdateResult, error) {
+	plan, err := m.PlanInclude(path, content)
+	if err != nil {
+		return managedfile.UpdateResult{}, err
+	}
+	return m.WriteInclude(plan)
+}
+
+// PlanInclude computes what UpdateInclude would write without touching the dynamic config.
+func (m Manager) PlanInclude(path string, content string) (managedfile.Plan, error) {
+	return managedfile.Prepare(m.FS, path, content)
+}
+
+// WriteInclude writes a plan from PlanInclude atomically.
+func (m Manager) WriteInclude(plan managedfile.Plan) (managedfile.UpdateResult, error) {
+	return managedfile.Write(m.FS, plan)
+}
+
+// RestoreInclude attempts to put the dynamic config back to its previous bytes.
+func (m Manager) RestoreInclude(res managedfile.UpdateResult) error {
+	return managedfile.Restore(m.FS, res)
+}
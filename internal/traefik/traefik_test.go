@@ -0,0 +1,103 @@
+package traefik
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cdfuller/devhosts/internal/filesystem"
+	"github.com/cdfuller/devhosts/internal/state"
+)
+
+func TestGenerateDynamic(t *testing.T) {
+	mgr := NewManager(filesystem.OS{})
+	content, err := mgr.GenerateDynamic([]state.Host{
+		{Name: "user", Upstream: "http://localhost:8000", TLS: true},
+		{Name: "staff", Upstream: "http://127.0.0.1:9000"},
+	}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateDynamic returned error: %v", err)
+	}
+	expected := `http:
+  routers:
+    devhosts-user:
+      rule: "Host(` + "`user`" + `)"
+      service: devhosts-user
+      tls: {}
+    devhosts-staff:
+      rule: "Host(` + "`staff`" + `)"
+      service: devhosts-staff
+  services:
+    devhosts-user:
+      loadBalancer:
+        passHostHeader: true
+        servers:
+          - url: "http://localhost:8000"
+    devhosts-staff:
+      loadBalancer:
+        passHostHeader: true
+        servers:
+          - url: "http://127.0.0.1:9000"
+tls:
+  certificates:
+    - certFile: "/certs/user.pem"
+      keyFile: "/certs/user-key.pem"
+`
+	if content != expected {
+		t.Fatalf("unexpected dynamic config:\n%s", content)
+	}
+}
+
+func TestGenerateDynamicGroups(t *testing.T) {
+	mgr := NewManager(filesystem.OS{})
+	content, err := mgr.GenerateDynamic([]state.Host{
+		{Name: "api", Upstream: "http://localhost:8001", Group: "billing", Routes: []state.Route{{Path: "/v1", Upstream: "http://localhost:5000"}}},
+		{Name: "invoices", Upstream: "http://localhost:8002", Group: "billing"},
+		{Name: "user", Upstream: "http://localhost:8000"},
+	}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateDynamic returned error: %v", err)
+	}
+	routers, _, _ := strings.Cut(content, "  services:\n")
+	want := "  routers:\n    devhosts-user:\n"
+	if !strings.Contains(routers, want) {
+		t.Fatalf("expected ungrouped routers first:\n%s", content)
+	}
+	want = "    # group: billing\n    devhosts-api-r1:\n"
+	if !strings.Contains(routers, want) || strings.Count(content, "# group:") != 1 {
+		t.Fatalf("expected one comment above the billing routers:\n%s", content)
+	}
+	if strings.Index(routers, "devhosts-invoices:") < strings.Index(routers, "devhosts-api:") {
+		t.Fatalf("expected billing routers in declared order:\n%s", content)
+	}
+}
+
+func TestGenerateDynamicUpstreamKinds(t *testing.T) {
+	mgr := NewManager(filesystem.OS{})
+	content, err := mgr.GenerateDynamic([]state.Host{{Name: "secure", Upstream: "https://localhost:8443"}}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateDynamic returned error: %v", err)
+	}
+	if !strings.Contains(content, "        serversTransport: devhosts-insecure\n") ||
+		!strings.Contains(content, "  serversTransports:\n    devhosts-insecure:\n      insecureSkipVerify: true\n") {
+		t.Fatalf("expected insecure transport for https upstream:\n%s", content)
+	}
+
+	content, err = mgr.GenerateDynamic([]state.Host{{
+		Name:      "api",
+		Upstreams: []string{"http://localhost:8000", "http://localhost:8001"},
+		Balancing: state.Balancing{LBPolicy: state.LBCookie, HealthCheck: &state.HealthCheck{Path: "/health", Interval: "5s"}},
+	}}, "/certs")
+	if err != nil {
+		t.Fatalf("GenerateDynamic returned error: %v", err)
+	}
+	want := "        sticky:\n          cookie:\n            name: devhosts_lb\n" +
+		"        healthCheck:\n          path: \"/health\"\n          interval: \"5s\"\n" +
+		"        servers:\n          - url: \"http://localhost:8000\"\n          - url: \"http://localhost:8001\"\n"
+	if !strings.Contains(content, want) {
+		t.Fatalf("expected pool service:\n%s", content)
+	}
+
+	if _, err := mgr.GenerateDynamic([]state.Host{{Name: "rails", Upstream: "unix//tmp/rails.sock"}}, "/certs"); err == nil {
+		t.Fatalf("expected unix socket upstream to be rejected")
+	}
+}
@@ -0,0 +1,550 @@
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal parses the subset of YAML that hand-written configuration such as
+// docker-compose.yml uses: block and flow mappings and sequences, plain, quoted, and block
+// scalars, comments, anchors, aliases, and << merge keys. Only the first document is read.
+//
+// Mappings decode to map[string]any, sequences to []any, null and empty values to nil, and
+// every other scalar to its string form; callers decide what a scalar means.
+func Unmarshal(data []byte) (any, error) {
+	d := &decoder{anchors: map[string]any{}}
+	for _, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if line == "..." || (line == "---" || strings.HasPrefix(line, "--- ")) && d.hasContent() {
+			break
+		}
+		if line == "---" {
+			continue
+		}
+		d.lines = append(d.lines, line)
+	}
+	i, ok, err := d.nextContent()
+	if err != nil || !ok {
+		return nil, err
+	}
+	v, err := d.node(d.indent(i))
+	if err != nil {
+		return nil, err
+	}
+	if i, ok, err := d.nextContent(); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, d.errorf(i, "unexpected content at indentation %d", d.indent(i))
+	}
+	return v, nil
+}
+
+type decoder struct {
+	lines   []string
+	pos     int
+	anchors map[string]any
+}
+
+func (d *decoder) hasContent() bool {
+	for _, line := range d.lines {
+		if stripComment(line) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *decoder) errorf(i int, format string, args ...any) error {
+	return fmt.Errorf("yaml: line %d: %s", i+1, fmt.Sprintf(format, args...))
+}
+
+// nextContent skips blank and comment-only lines, returning the index of the next line that
+// holds a value.
+func (d *decoder) nextContent() (int, bool, error) {
+	for ; d.pos < len(d.lines); d.pos++ {
+		line := d.lines[d.pos]
+		if stripComment(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return 0, false, d.errorf(d.pos, "tabs are not allowed in indentation")
+		}
+		return d.pos, true, nil
+	}
+	return 0, false, nil
+}
+
+func (d *decoder) indent(i int) int {
+	return len(d.lines[i]) - len(strings.TrimLeft(d.lines[i], " "))
+}
+
+// text returns line i without indentation or a trailing comment.
+func (d *decoder) text(i int) string {
+	return stripComment(d.lines[i])
+}
+
+// node parses the block collection or scalar starting at the next content line, which sits
+// at indent.
+func (d *decoder) node(indent int) (any, error) {
+	i, _, _ := d.nextContent()
+	text := d.text(i)
+	switch {
+	case isSequenceEntry(text):
+		return d.sequence(indent)
+	case splitKey(text) >= 0:
+		return d.mapping(indent)
+	}
+	d.pos++
+	return d.value(i, text, indent)
+}
+
+func (d *decoder) sequence(indent int) ([]any, error) {
+	list := []any{}
+	for {
+		i, ok, err := d.nextContent()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || d.indent(i) != indent || !isSequenceEntry(d.text(i)) {
+			return list, nil
+		}
+		rest := strings.TrimLeft(d.text(i)[1:], " ")
+		var item any
+		switch {
+		case rest == "":
+			d.pos++
+			item, err = d.child(indent, false)
+		case isSequenceEntry(rest) || splitKey(rest) >= 0 && !isFlow(rest):
+			// "- key: value" opens a mapping whose keys line up with the first one; rewrite
+			// the dash as indentation and parse the entry as its own block.
+			inner := len(d.lines[i]) - len(strings.TrimLeft(d.lines[i][indent+1:], " "))
+			d.lines[i] = strings.Repeat(" ", inner) + d.lines[i][inner:]
+			item, err = d.node(inner)
+		default:
+			d.pos++
+			item, err = d.value(i, rest, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+}
+
+func (d *decoder) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	var merges []any
+	for {
+		i, ok, err := d.nextContent()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || d.indent(i) < indent {
+			break
+		}
+		text := d.text(i)
+		if d.indent(i) > indent {
+			return nil, d.errorf(i, "unexpected indentation")
+		}
+		if isSequenceEntry(text) {
+			break
+		}
+		colon := splitKey(text)
+		if colon < 0 {
+			return nil, d.errorf(i, "expected a key: value pair")
+		}
+		key, err := unquote(strings.TrimSpace(text[:colon]))
+		if err != nil {
+			return nil, d.errorf(i, "%v", err)
+		}
+		d.pos++
+		value, err := d.value(i, strings.TrimSpace(text[colon+1:]), indent)
+		if err != nil {
+			return nil, err
+		}
+		if key == "<<" {
+			merges = append(merges, value)
+			continue
+		}
+		m[key] = value
+	}
+	// Keys written out take precedence over merged ones, wherever the merge appears.
+	for _, merge := range merges {
+		sources, ok := merge.([]any)
+		if !ok {
+			sources = []any{merge}
+		}
+		for _, source := range sources {
+			src, ok := source.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("yaml: << merges a mapping or a list of mappings")
+			}
+			for k, v := range src {
+				if _, set := m[k]; !set {
+					m[k] = v
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// child parses the block nested under a key or dash at indent, or returns nil when the next
+// line is not part of it. A sequence may sit at its key's own indentation.
+func (d *decoder) child(indent int, allowSameIndentSequence bool) (any, error) {
+	i, ok, err := d.nextContent()
+	if err != nil || !ok {
+		return nil, err
+	}
+	switch next := d.indent(i); {
+	case next > indent:
+		return d.node(next)
+	case next == indent && allowSameIndentSequence && isSequenceEntry(d.text(i)):
+		return d.sequence(indent)
+	}
+	return nil, nil
+}
+
+// value interprets what follows a key or dash on line i, reading further lines for nested
+// blocks, block scalars, and flow collections that span lines.
+func (d *decoder) value(i int, text string, indent int) (any, error) {
+	var anchor string
+	if strings.HasPrefix(text, "&") {
+		name, rest, _ := strings.Cut(text[1:], " ")
+		anchor, text = name, strings.TrimSpace(rest)
+	}
+	var v any
+	var err error
+	switch {
+	case text == "":
+		v, err = d.child(indent, true)
+	case text[0] == '|' || text[0] == '>':
+		v, err = d.blockScalar(i, text, indent)
+	case isFlow(text):
+		v, err = d.flowValue(i, text)
+	default:
+		v, err = d.scalar(i, text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anchor != "" {
+		d.anchors[anchor] = v
+	}
+	return v, nil
+}
+
+func (d *decoder) scalar(i int, text string) (any, error) {
+	if strings.HasPrefix(text, "*") {
+		v, ok := d.anchors[text[1:]]
+		if !ok {
+			return nil, d.errorf(i, "unknown alias %s", text)
+		}
+		return v, nil
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		s, err := unquote(text)
+		if err != nil {
+			return nil, d.errorf(i, "%v", err)
+		}
+		return s, nil
+	}
+	return plain(text), nil
+}
+
+// blockScalar reads a | or > scalar from the lines indented past indent.
+func (d *decoder) blockScalar(i int, header string, indent int) (any, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	blockIndent := 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			blockIndent = indent + int(c-'0')
+		default:
+			return nil, d.errorf(i, "invalid block scalar header %q", header)
+		}
+	}
+	var lines []string
+	for ; d.pos < len(d.lines); d.pos++ {
+		line := d.lines[d.pos]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent == 0 {
+			blockIndent = n
+		}
+		if n <= indent || n < blockIndent {
+			break
+		}
+		lines = append(lines, line[blockIndent:])
+	}
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	// Folding joins adjacent lines with a space; each blank line stands for one newline.
+	var b strings.Builder
+	for j, line := range lines {
+		if j > 0 {
+			switch {
+			case !folded:
+				b.WriteByte('\n')
+			case line == "":
+				b.WriteByte('\n')
+				continue
+			case lines[j-1] != "":
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	switch {
+	case len(lines) == 0:
+	case chomp == '-':
+	case chomp == '+':
+		b.WriteString(strings.Repeat("\n", trailing+1))
+	default:
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// flowValue parses a [...] or {...} collection, joining following lines until it closes.
+func (d *decoder) flowValue(i int, text string) (any, error) {
+	for !flowClosed(text) {
+		if d.pos >= len(d.lines) {
+			return nil, d.errorf(i, "unterminated flow collection")
+		}
+		text += " " + stripComment(d.lines[d.pos])
+		d.pos++
+	}
+	p := &flowParser{d: d, line: i, s: text}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, d.errorf(i, "unexpected %q after flow collection", p.s[p.pos:])
+	}
+	return v, nil
+}
+
+type flowParser struct {
+	d    *decoder
+	line int
+	s    string
+	pos  int
+}
+
+func (p *flowParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *flowParser) value() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, p.d.errorf(p.line, "unexpected end of flow collection")
+	}
+	switch p.s[p.pos] {
+	case '[':
+		p.pos++
+		list := []any{}
+		for {
+			p.skipSpace()
+			if p.pos < len(p.s) && p.s[p.pos] == ']' {
+				p.pos++
+				return list, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if err := p.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		p.pos++
+		m := map[string]any{}
+		for {
+			p.skipSpace()
+			if p.pos < len(p.s) && p.s[p.pos] == '}' {
+				p.pos++
+				return m, nil
+			}
+			key, err := p.scalar(":,}")
+			if err != nil {
+				return nil, err
+			}
+			var value any
+			if p.skipSpace(); p.pos < len(p.s) && p.s[p.pos] == ':' {
+				p.pos++
+				if value, err = p.value(); err != nil {
+					return nil, err
+				}
+			}
+			name, _ := key.(string)
+			m[name] = value
+			if err := p.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p.scalar(",]}")
+}
+
+// separator consumes the comma between flow entries, leaving the closing bracket in place.
+func (p *flowParser) separator(closing byte) error {
+	p.skipSpace()
+	switch {
+	case p.pos >= len(p.s):
+		return p.d.errorf(p.line, "unterminated flow collection")
+	case p.s[p.pos] == ',':
+		p.pos++
+	case p.s[p.pos] != closing:
+		return p.d.errorf(p.line, "expected ',' or %q in flow collection", closing)
+	}
+	return nil
+}
+
+// scalar reads a quoted scalar or a plain one ending before any of stops.
+func (p *flowParser) scalar(stops string) (any, error) {
+	p.skipSpace()
+	start := p.pos
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		end := closingQuote(p.s, p.pos)
+		if end < 0 {
+			return nil, p.d.errorf(p.line, "unterminated quoted string")
+		}
+		p.pos = end + 1
+		return p.d.scalar(p.line, p.s[start:p.pos])
+	}
+	for p.pos < len(p.s) && !strings.ContainsRune(stops, rune(p.s[p.pos])) {
+		// In a flow mapping a colon only ends the key when a space follows it.
+		if p.s[p.pos] == ':' && p.pos+1 < len(p.s) && p.s[p.pos+1] != ' ' {
+			p.pos++
+			continue
+		}
+		p.pos++
+	}
+	text := strings.TrimSpace(p.s[start:p.pos])
+	if text == "" {
+		return nil, nil
+	}
+	return p.d.scalar(p.line, text)
+}
+
+// plain resolves an unquoted scalar; only the null forms are special.
+func plain(text string) any {
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	}
+	return text
+}
+
+func unquote(text string) (string, error) {
+	if text == "" || (text[0] != '"' && text[0] != '\'') {
+		return text, nil
+	}
+	if end := closingQuote(text, 0); end != len(text)-1 {
+		return "", fmt.Errorf("malformed quoted string %s", text)
+	}
+	if text[0] == '\'' {
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	s, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("malformed quoted string %s", text)
+	}
+	return s, nil
+}
+
+// closingQuote returns the index of the quote closing the string opened at start, or -1.
+func closingQuote(s string, start int) int {
+	q := s[start]
+	for i := start + 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q && q == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment trims line and drops a # comment that starts outside quotes.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || strings.ContainsRune("[{,:-", rune(line[i-1])) {
+				if end := closingQuote(line, i); end > 0 {
+					i = end
+				}
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// splitKey returns the index of the colon ending a mapping key in text, or -1 when text is
+// not a key: value pair.
+func splitKey(text string) int {
+	start := 0
+	if text != "" && (text[0] == '"' || text[0] == '\'') {
+		end := closingQuote(text, 0)
+		if end < 0 {
+			return -1
+		}
+		start = end + 1
+	} else if text == "" || isFlow(text) || strings.ContainsRune("&*|>", rune(text[0])) {
+		return -1
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+func isSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isFlow(text string) bool {
+	return text != "" && (text[0] == '[' || text[0] == '{')
+}
+
+// flowClosed reports whether every bracket opened in text outside quotes is closed.
+func flowClosed(text string) bool {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '"', '\'':
+			if end := closingQuote(text, i); end > 0 {
+				i = end
+			}
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
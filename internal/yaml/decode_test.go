@@ -0,0 +1,108 @@
+package yaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	input := `# compose file
+version: "3.9"
+x-common: &common
+  restart: unless-stopped
+  labels:
+    devhosts.tls: "false"
+services:
+  web:
+    <<: *common
+    image: nginx:1.27 # pinned
+    ports:
+      - "8000:80"
+      - 127.0.0.1:8443:443/tcp
+    environment:
+    - 'GREETING=it''s # not a comment'
+  api:
+    ports: ["9000:9000", '9001:9001']
+    x-devhosts: {name: backend, tls: true}
+    command: >
+      serve
+      --port 9000
+
+    healthcheck:
+      test: |
+        curl -f http://localhost:9000/
+        exit 0
+  db:
+    ports:
+      - target: 5432
+        published: 15432
+        protocol: tcp
+    volumes: []
+    deploy: ~
+`
+	got, err := Unmarshal([]byte(input))
+	if err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+	want := map[string]any{
+		"version":  "3.9",
+		"x-common": map[string]any{"restart": "unless-stopped", "labels": map[string]any{"devhosts.tls": "false"}},
+		"services": map[string]any{
+			"web": map[string]any{
+				"restart":     "unless-stopped",
+				"labels":      map[string]any{"devhosts.tls": "false"},
+				"image":       "nginx:1.27",
+				"ports":       []any{"8000:80", "127.0.0.1:8443:443/tcp"},
+				"environment": []any{"GREETING=it's # not a comment"},
+			},
+			"api": map[string]any{
+				"ports":       []any{"9000:9000", "9001:9001"},
+				"x-devhosts":  map[string]any{"name": "backend", "tls": "true"},
+				"command":     "serve --port 9000\n",
+				"healthcheck": map[string]any{"test": "curl -f http://localhost:9000/\nexit 0\n"},
+			},
+			"db": map[string]any{
+				"ports":   []any{map[string]any{"target": "5432", "published": "15432", "protocol": "tcp"}},
+				"volumes": []any{},
+				"deploy":  nil,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unmarshal mismatch:\n got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestUnmarshalReadsMarshalOutput(t *testing.T) {
+	doc := map[string]any{
+		"hosts": []any{map[string]any{"name": "user", "upstreams": []any{"http://localhost:8000"}}},
+		"empty": []any{},
+		"note":  "a: b",
+	}
+	data, err := Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal returned error: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Fatalf("round trip mismatch:\n got %#v\nwant %#v\n%s", got, doc, data)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, input := range []string{
+		"a: *missing\n",
+		"a: [1, 2\n",
+		"a:\n\t- b\n",
+		"a: 1\n  b: 2\n",
+		`a: "open` + "\n",
+	} {
+		if _, err := Unmarshal([]byte(input)); err == nil || !strings.HasPrefix(err.Error(), "yaml: line") {
+			t.Fatalf("expected a line error for %q, got %v", input, err)
+		}
+	}
+}
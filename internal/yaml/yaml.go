@@ -0,0 +1,229 @@
+// Package yaml writes YAML for the structured CLI output and reads the subset of YAML that
+// compose files use. Values written go through encoding/json first, so json struct tags
+// decide field names and order and the output is the YAML rendering of exactly the document
+// --output json prints.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Marshal renders v as a YAML document.
+func Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return FromJSON(data)
+}
+
+// FromJSON converts a JSON document to YAML, keeping object keys in their original order.
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("yaml: trailing data after JSON value")
+	}
+	var b strings.Builder
+	writeDocument(&b, root)
+	return []byte(b.String()), nil
+}
+
+// member is one key of an object; objects are slices to keep their order.
+type member struct {
+	key   string
+	value any
+}
+
+type object []member
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := object{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, member{key: keyTok.(string), value: value})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		list := []any{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	}
+	return tok, nil
+}
+
+func writeDocument(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case object:
+		if len(v) > 0 {
+			writeObject(b, v, "")
+			return
+		}
+	case []any:
+		if len(v) > 0 {
+			writeList(b, v, "")
+			return
+		}
+	}
+	b.WriteString(scalar(v))
+	b.WriteByte('\n')
+}
+
+// writeObject writes one "key: value" line per member at indent; nested collections
+// start on the following line.
+func writeObject(b *strings.Builder, obj object, indent string) {
+	for i, m := range obj {
+		if i > 0 {
+			b.WriteString(indent)
+		}
+		b.WriteString(scalar(m.key))
+		b.WriteByte(':')
+		writeNested(b, m.value, indent+"  ")
+	}
+}
+
+// writeList writes "- item" lines at indent. Objects start on the dash line so a list of
+// hosts reads like a list of records.
+func writeList(b *strings.Builder, list []any, indent string) {
+	for i, item := range list {
+		if i > 0 {
+			b.WriteString(indent)
+		}
+		b.WriteString("-")
+		switch item := item.(type) {
+		case object:
+			if len(item) > 0 {
+				b.WriteByte(' ')
+				writeObject(b, item, indent+"  ")
+				continue
+			}
+		case []any:
+			if len(item) > 0 {
+				b.WriteByte(' ')
+				writeList(b, item, indent+"  ")
+				continue
+			}
+		}
+		b.WriteByte(' ')
+		b.WriteString(scalar(item))
+		b.WriteByte('\n')
+	}
+}
+
+// writeNested finishes a "key:" line with the value inline or as an indented block.
+func writeNested(b *strings.Builder, v any, indent string) {
+	switch v := v.(type) {
+	case object:
+		if len(v) > 0 {
+			b.WriteString("\n" + indent)
+			writeObject(b, v, indent)
+			return
+		}
+	case []any:
+		if len(v) > 0 {
+			// Block sequences may sit at the parent key's indentation.
+			parent := strings.TrimSuffix(indent, "  ")
+			b.WriteString("\n" + parent)
+			writeList(b, v, parent)
+			return
+		}
+	}
+	b.WriteByte(' ')
+	b.WriteString(scalar(v))
+	b.WriteByte('\n')
+}
+
+func scalar(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		return quoteString(v)
+	case object:
+		return "{}"
+	case []any:
+		return "[]"
+	}
+	return fmt.Sprint(v)
+}
+
+// quoteString leaves plain strings bare and double-quotes anything YAML would read as
+// another type or structure. JSON string escapes are valid in YAML double quotes.
+func quoteString(s string) string {
+	if !needsQuotes(s) {
+		return s
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func needsQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n", ".inf", "-.inf", ".nan":
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	if strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":") {
+		return true
+	}
+	for _, r := range s {
+		if r < ' ' || r == 0x7f {
+			return true
+		}
+	}
+	return looksNumeric(s)
+}
+
+// looksNumeric reports whether s would load as an int or float.
+func looksNumeric(s string) bool {
+	if _, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
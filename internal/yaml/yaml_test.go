@@ -0,0 +1,74 @@
+package yaml
+
+import "testing"
+
+func TestMarshal(t *testing.T) {
+	type route struct {
+		Path string `json:"path"`
+	}
+	type host struct {
+		Name      string   `json:"name"`
+		Upstreams []string `json:"upstreams"`
+		TLS       bool     `json:"tls"`
+		Routes    []route  `json:"routes,omitempty"`
+	}
+	doc := struct {
+		Version int    `json:"version"`
+		Command string `json:"command"`
+		Hosts   []host `json:"hosts"`
+		Empty   []host `json:"empty"`
+		Note    string `json:"note"`
+	}{
+		Version: 1,
+		Command: "list",
+		Hosts: []host{
+			{Name: "user", Upstreams: []string{"http://localhost:8000"}, TLS: true, Routes: []route{{Path: "/api"}}},
+			{Name: "true", Upstreams: []string{"http://localhost:8001", "http://localhost:8002"}},
+		},
+		Empty: []host{},
+		Note:  "a: b\nc",
+	}
+	got, err := Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	expected := `version: 1
+command: list
+hosts:
+- name: user
+  upstreams:
+  - http://localhost:8000
+  tls: true
+  routes:
+  - path: /api
+- name: "true"
+  upstreams:
+  - http://localhost:8001
+  - http://localhost:8002
+  tls: false
+empty: []
+note: "a: b\nc"
+`
+	if string(got) != expected {
+		t.Fatalf("unexpected yaml:\n%s", got)
+	}
+}
+
+func TestQuoteString(t *testing.T) {
+	cases := map[string]string{
+		"plain":      "plain",
+		"":           `""`,
+		"123":        `"123"`,
+		"1.5":        `"1.5"`,
+		"no":         `"no"`,
+		"- dash":     `"- dash"`,
+		"#comment":   `"#comment"`,
+		" padded":    `" padded"`,
+		"/etc/hosts": "/etc/hosts",
+	}
+	for in, want := range cases {
+		if got := quoteString(in); got != want {
+			t.Fatalf("quoteString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}